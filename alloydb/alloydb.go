@@ -0,0 +1,141 @@
+/*
+Package alloydb implements a lazydsn provider for Google AlloyDB instances,
+reached through the AlloyDB Go connector (cloud.google.com/go/alloydbconn)
+instead of a host and port. The connector sets up TLS with ephemeral client
+certificates, and optionally authenticates with IAM, every time a connection
+is dialed; this package has connections dialed that way, while credentials
+(or just the user, with IAM authentication) keep coming from another
+provider, evaluated with every new connection as usual:
+
+	dialer, err := alloydbconn.NewDialer(ctx, alloydbconn.WithIAMAuthN())
+	...
+	creds := lazydsn.DSNProviderFunc(func(string) (string, error) {
+		return "user=app@my-project.iam dbname=app", nil
+	})
+	p := alloydb.New(creds, "projects/my-project/locations/us-central1/clusters/main/instances/primary",
+		func(ctx context.Context, instance string) (net.Conn, error) {
+			return dialer.Dial(ctx, instance)
+		},
+	)
+	lazydsn.Register("lazydsn:alloydb", stdlib.GetDefaultDriver(), p)
+
+	db, err := sql.Open("lazydsn:alloydb", "app")
+
+The master DSN is given to the credentials provider as is. The DSN it returns
+is for github.com/jackc/pgx, in any of the formats it understands; connections
+are opened with pgx whatever the inner driver is, because the connector needs
+to be plugged in as pgx's dial function. Hosts, ports and TLS settings in the
+DSN are ignored, since the connector takes care of all of them.
+
+Cloud SQL for PostgreSQL works the same way, with a dialer from
+cloud.google.com/go/cloudsqlconn and an instance connection name.
+*/
+package alloydb
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// A DialFunc connects to the instance with the given URI; e.g., with the Dial
+// method of an alloydbconn.Dialer.
+type DialFunc func(ctx context.Context, instance string) (net.Conn, error)
+
+// Provider resolves DSNs for an AlloyDB instance. It implements
+// lazydsn.InfoDSNProvider.
+type Provider struct {
+	creds    lazydsn.DSNProvider
+	info     lazydsn.InfoDSNProvider
+	instance string
+	dial     DialFunc
+}
+
+// New creates a provider for the instance with the given URI, in the form
+// projects/PROJECT/locations/REGION/clusters/CLUSTER/instances/INSTANCE, that
+// fetches credentials from creds, and dials with dial. If creds implements
+// lazydsn.InfoDSNProvider, the details it reports (like the version, or when
+// the credential was issued) are carried over.
+func New(creds lazydsn.DSNProvider, instance string, dial DialFunc) *Provider {
+	p := &Provider{
+		creds:    creds,
+		instance: instance,
+		dial:     dial,
+	}
+
+	p.info, _ = creds.(lazydsn.InfoDSNProvider)
+
+	return p
+}
+
+// FetchDSN implements the lazydsn.DSNProvider interface.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the lazydsn.FullDSNProvider interface.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo fetches the credentials DSN, and has connections opened with it
+// dialed through the connector. The instance URI is reported as the endpoint.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	var info lazydsn.DSNInfo
+	var err error
+
+	switch creds := p.creds.(type) {
+	case lazydsn.InfoDSNProvider:
+		info, err = creds.FetchDSNInfo(ctx, dsn)
+	case lazydsn.FullDSNProvider:
+		info.DSN, err = creds.FetchDSNWithContext(ctx, dsn)
+	default:
+		info.DSN, err = creds.FetchDSN(dsn)
+	}
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	info.Endpoint = p.instance
+	info.Connector = p.connector
+
+	return info, nil
+}
+
+// connector builds a pgx connector for innerDSN, that dials the instance
+// through the connector.
+func (p *Provider) connector(innerDSN string) (driver.Connector, error) {
+	cfg, err := pgx.ParseConfig(innerDSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The connector hands over connections already encrypted, and doesn't
+	// care about the address; make sure that pgx doesn't either, and that
+	// it doesn't try to resolve it.
+	cfg.TLSConfig = nil
+	cfg.Fallbacks = nil
+	cfg.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+	cfg.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return p.dial(ctx, p.instance)
+	}
+
+	return stdlib.GetConnector(*cfg), nil
+}
+
+// Provider implements the lazydsn.InfoDSNProvider and lazydsn.FullDSNProvider
+// interfaces.
+var (
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.FullDSNProvider = &Provider{}
+)
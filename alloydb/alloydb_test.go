@@ -0,0 +1,135 @@
+package alloydb_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/alloydb"
+)
+
+// instance is the URI of the instance used in tests.
+const instance = "projects/p/locations/us-central1/clusters/main/instances/primary"
+
+// errDial is returned by the dialer used in tests.
+var errDial = errors.New("dial refused")
+
+// dialer records the instances dialed, refusing to connect.
+type dialer struct {
+	mu        sync.Mutex
+	instances []string
+}
+
+func (d *dialer) dial(_ context.Context, instance string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.instances = append(d.instances, instance)
+
+	return nil, errDial
+}
+
+// infoProvider reports a version and issue time along with the DSN.
+type infoProvider struct {
+	issued time.Time
+}
+
+func (p infoProvider) FetchDSN(string) (string, error) {
+	return "user=app dbname=app", nil
+}
+
+func (p infoProvider) FetchDSNInfo(context.Context, string) (lazydsn.DSNInfo, error) {
+	return lazydsn.DSNInfo{DSN: "user=app dbname=app", Version: "v2", Issued: p.issued}, nil
+}
+
+// TestFetch checks that the credentials are fetched as the provider allows,
+// and carried over along with the instance.
+func TestFetch(t *testing.T) {
+	issued := time.Now()
+
+	tests := []struct {
+		name  string
+		creds lazydsn.DSNProvider
+		want  lazydsn.DSNInfo
+	}{
+		{
+			name: "plain",
+			creds: lazydsn.DSNProviderFunc(func(string) (string, error) {
+				return "user=app dbname=app", nil
+			}),
+			want: lazydsn.DSNInfo{DSN: "user=app dbname=app"},
+		},
+		{
+			name: "with context",
+			creds: lazydsn.DSNProviderWCFunc(func(context.Context, string) (string, error) {
+				return "postgres://app@/app", nil
+			}),
+			want: lazydsn.DSNInfo{DSN: "postgres://app@/app"},
+		},
+		{
+			name:  "info",
+			creds: infoProvider{issued: issued},
+			want:  lazydsn.DSNInfo{DSN: "user=app dbname=app", Version: "v2", Issued: issued},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dialer{}
+			info, err := alloydb.New(tt.creds, instance, d.dial).FetchDSNInfo(context.Background(), "app")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.DSN != tt.want.DSN || info.Version != tt.want.Version || !info.Issued.Equal(tt.want.Issued) {
+				t.Errorf("got %+v, want %+v", info, tt.want)
+			}
+
+			if info.Endpoint != instance {
+				t.Errorf("got endpoint %q, want %q", info.Endpoint, instance)
+			}
+
+			if info.Connector == nil {
+				t.Error("got no connector")
+			}
+		})
+	}
+}
+
+// TestConnector checks that connections are dialed to the instance through
+// the dialer, whatever the host in the DSN, and that bad DSNs are refused.
+func TestConnector(t *testing.T) {
+	creds := lazydsn.DSNProviderFunc(func(string) (string, error) {
+		return "host=ignored.example.com port=1 user=app dbname=app sslmode=require", nil
+	})
+
+	d := &dialer{}
+	info, err := alloydb.New(creds, instance, d.dial).FetchDSNInfo(context.Background(), "app")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connector, err := info.Connector(info.DSN)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := connector.Connect(context.Background()); !errors.Is(err, errDial) {
+		t.Errorf("got %v, want %v", err, errDial)
+	}
+
+	if len(d.instances) == 0 || d.instances[0] != instance {
+		t.Errorf("got instances %q dialed, want %q", d.instances, instance)
+	}
+
+	if _, err := info.Connector("port=notanumber"); err == nil {
+		t.Error("got a connector for a bad DSN")
+	}
+}
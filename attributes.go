@@ -0,0 +1,128 @@
+package lazydsn
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Attribute keys set by the driver itself; see WithConnAttributes.
+const (
+	AttrService = "service"
+	AttrVersion = "version"
+	AttrAlias   = "alias"
+)
+
+// SetConnAttributes returns dsn with attrs set as connection attributes, as
+// far as its database supports them, so that sessions can be told apart on the
+// database side (e.g., in pg_stat_activity or performance_schema). Key order
+// doesn't matter; attributes are always written sorted by key.
+//
+// For MySQL DSNs, attributes are added to the connectionAttributes parameter
+// (as supported by github.com/go-sql-driver/mysql), after the ones there
+// already; commas and colons in keys and values are replaced with
+// underscores, since they can't be escaped. For PostgreSQL DSNs (URLs with a
+// postgres or postgresql scheme, and keyword/value DSNs) there's a single
+// application_name instead: it's set to the service attribute (see
+// AttrService), or the application_name in dsn if none, followed by the rest
+// of the attributes as key=value pairs; PostgreSQL truncates it to 63 bytes.
+// Other DSNs are returned unchanged.
+func SetConnAttributes(dsn string, attrs map[string]string) (string, error) {
+	if len(attrs) == 0 {
+		return dsn, nil
+	}
+
+	view := ParseDSN(dsn)
+
+	switch {
+	case view.Format == FormatMySQL:
+		return SetParams(dsn, map[string]string{
+			"connectionAttributes": mysqlAttributes(view.Params["connectionAttributes"], attrs),
+		}, true)
+	case view.Format == FormatKeyValue, view.Format == FormatURL && isPostgresURL(dsn):
+		return SetParams(dsn, map[string]string{
+			"application_name": applicationName(view.Params["application_name"], attrs),
+		}, true)
+	}
+
+	return dsn, nil
+}
+
+// isPostgresURL tells whether dsn is a PostgreSQL URL.
+func isPostgresURL(dsn string) bool {
+	u, err := url.Parse(strings.TrimSpace(dsn))
+
+	return err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql")
+}
+
+// mysqlAttributes adds attrs to the existing value of a connectionAttributes
+// parameter.
+func mysqlAttributes(existing string, attrs map[string]string) string {
+	var parts []string
+
+	if existing != "" {
+		parts = append(parts, existing)
+	}
+
+	clean := strings.NewReplacer(",", "_", ":", "_")
+
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		parts = append(parts, clean.Replace(k)+":"+clean.Replace(attrs[k]))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// applicationName builds a PostgreSQL application_name out of attrs, with
+// existing as the name if there's no service attribute.
+func applicationName(existing string, attrs map[string]string) string {
+	parts := []string{existing}
+
+	if service, ok := attrs[AttrService]; ok {
+		parts[0] = service
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		if k != AttrService {
+			parts = append(parts, k+"="+attrs[k])
+		}
+	}
+
+	if parts[0] == "" {
+		parts = parts[1:]
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// attribute sets the connection attributes for info in its DSN, if there
+// are any; see WithConnAttributes.
+func (d *Driver) attribute(info DSNInfo) (DSNInfo, error) {
+	if d.attributes == nil && len(info.Attributes) == 0 {
+		return info, nil
+	}
+
+	attrs := maps.Clone(d.attributes)
+
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+
+	if d.attributes != nil {
+		if info.Version != "" {
+			attrs[AttrVersion] = info.Version
+		}
+
+		if d.alias != "" {
+			attrs[AttrAlias] = d.alias
+		}
+	}
+
+	maps.Copy(attrs, info.Attributes)
+
+	var err error
+	info.DSN, err = SetConnAttributes(info.DSN, attrs)
+
+	return info, err
+}
@@ -0,0 +1,46 @@
+package lazydsn
+
+import (
+	"time"
+)
+
+// An AuditRecord describes a single use of a credential to open a connection,
+// whether the connection succeeded or not. Records never carry secrets: the
+// identity and target are taken from a redacted view of the inner DSN.
+type AuditRecord struct {
+	DSN     DSNView   // User, host and database the credential was used for
+	Version string    // Secret version, if reported by the provider
+	Time    time.Time // When the connection attempt finished
+	Err     error     // Why the attempt failed, if it did (redacted)
+}
+
+// An Auditor receives a record every time the driver uses a credential, so
+// that security teams can ship credential usage to their SIEM. Auditors are
+// called synchronously from the goroutine opening the connection, so they
+// should return quickly and must be safe for concurrent use.
+type Auditor interface {
+	Audit(AuditRecord)
+}
+
+// AuditorFunc allows using a plain function as an Auditor.
+type AuditorFunc func(AuditRecord)
+
+// Audit exercises the original function.
+func (f AuditorFunc) Audit(r AuditRecord) {
+	f(r)
+}
+
+// audit reports the use of the credential in info to the auditor, if any. The
+// error must have been redacted already.
+func (d *Driver) audit(info DSNInfo, err error) {
+	if d.auditor == nil {
+		return
+	}
+
+	d.auditor.Audit(AuditRecord{
+		DSN:     ParseDSN(info.DSN),
+		Version: info.Version,
+		Time:    d.clock.Now(),
+		Err:     err,
+	})
+}
@@ -0,0 +1,216 @@
+/*
+Package aurora implements a lazydsn provider aware of Amazon Aurora cluster
+topology. Aurora moves the writer role between instances on failover, and the
+cluster endpoint follows by means of a DNS CNAME record; clients holding on to
+stale DNS answers keep connecting to the old writer, that's now read only.
+Rotating credentials on top of that usually ends up handled by two separate,
+ad hoc layers. This package handles both in one place, resolving the instance
+to connect to with every new connection:
+
+	creds := awssm.New(client, awssm.MySQL)
+	p, err := aurora.New(creds, "prod.cluster-abc123.us-east-1.rds.amazonaws.com", aurora.Writer)
+	...
+	lazydsn.Register("lazydsn:mysql", &mysql.MySQLDriver{}, p)
+
+By default, the cluster endpoint's CNAME record is looked up every time,
+which points straight to the writer instance. Readers, and a faster reaction
+to failovers, require querying the topology tables that Aurora keeps in every
+instance; see WithTopology. Either way, the credentials DSN is pointed to the
+instances found (see lazydsn.DiscoveryProvider), keeping its port. A failover
+shows up as a change in the resolved DSN, just like a rotation does.
+*/
+package aurora
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// ErrNotClusterEndpoint is returned for endpoints that don't look like an
+// Aurora cluster (or reader) endpoint.
+var ErrNotClusterEndpoint = errors.New("aurora: not a cluster endpoint")
+
+// Role selects the instances that DSNs should point to.
+type Role int
+
+// Instance roles.
+const (
+	// Writer points DSNs to the writer instance only.
+	Writer Role = iota
+
+	// Reader points DSNs to every reader instance, or to the writer if
+	// there are no readers, as the reader endpoint does.
+	Reader
+)
+
+// Instance describes a member of the cluster.
+type Instance struct {
+	ID     string // Instance identifier, as in its endpoint
+	Writer bool   // Whether it's the current writer
+}
+
+// Topology reports the current members of the cluster.
+type Topology interface {
+	Instances(ctx context.Context) ([]Instance, error)
+}
+
+// TopologyFunc lets an inline function literal be used as a Topology.
+type TopologyFunc func(context.Context) ([]Instance, error)
+
+// Instances exercises the original function.
+func (f TopologyFunc) Instances(ctx context.Context) ([]Instance, error) {
+	return f(ctx)
+}
+
+// Resolver is the subset of the DNS API used by the locator. *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// Locator finds the cluster instances to connect to. It implements
+// lazydsn.Locator.
+type Locator struct {
+	endpoint string
+	suffix   string
+	role     Role
+	topology Topology
+	resolver Resolver
+}
+
+// An Option configures optional behavior for a Locator.
+type Option func(*Locator)
+
+// WithTopology makes the locator rely on t to find instances. DNS is only
+// used as a fallback, when t fails; the writer can still be found that way,
+// but readers can't. See QueryTopology.
+func WithTopology(t Topology) Option {
+	return func(l *Locator) {
+		l.topology = t
+	}
+}
+
+// WithResolver sets the resolver used for lookups, instead of
+// net.DefaultResolver.
+func WithResolver(r Resolver) Option {
+	return func(l *Locator) {
+		l.resolver = r
+	}
+}
+
+// NewLocator creates a locator for the cluster with the given endpoint, which
+// can be either the cluster endpoint or the reader endpoint; e.g.,
+// prod.cluster-abc123.us-east-1.rds.amazonaws.com. Instance endpoints are
+// derived from it.
+func NewLocator(endpoint string, role Role, opts ...Option) (*Locator, error) {
+	suffix, err := suffixOf(endpoint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Locator{
+		endpoint: endpoint,
+		suffix:   suffix,
+		role:     role,
+		resolver: net.DefaultResolver,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l, nil
+}
+
+// New creates a provider for the cluster with the given endpoint, that
+// fetches credentials from creds. See NewLocator and
+// lazydsn.NewDiscoveryProvider.
+func New(creds lazydsn.DSNProvider, endpoint string, role Role, opts ...Option) (*lazydsn.DiscoveryProvider, error) {
+	l, err := NewLocator(endpoint, role, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return lazydsn.NewDiscoveryProvider(creds, l), nil
+}
+
+// Locate returns the hosts of the instances to connect to.
+func (l *Locator) Locate(ctx context.Context) ([]string, error) {
+	if l.topology != nil {
+		instances, err := l.topology.Instances(ctx)
+
+		if err == nil {
+			if hosts := l.hosts(instances); len(hosts) > 0 {
+				return hosts, nil
+			}
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	// The cluster endpoint is a CNAME for the writer instance.
+	cname, err := l.resolver.LookupCNAME(ctx, l.writerEndpoint())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strings.TrimSuffix(cname, ".")}, nil
+}
+
+// hosts returns the endpoints for the instances with the role wanted.
+func (l *Locator) hosts(instances []Instance) []string {
+	var writer, readers []string
+
+	for _, instance := range instances {
+		host := instance.ID + "." + l.suffix
+
+		if instance.Writer {
+			writer = append(writer, host)
+		} else {
+			readers = append(readers, host)
+		}
+	}
+
+	if l.role == Reader && len(readers) > 0 {
+		return readers
+	}
+
+	return writer
+}
+
+// writerEndpoint returns the cluster endpoint, even if the locator was
+// created with the reader endpoint.
+func (l *Locator) writerEndpoint() string {
+	name, _, _ := strings.Cut(l.endpoint, ".")
+
+	return name + ".cluster-" + l.suffix
+}
+
+// suffixOf returns the part of a cluster endpoint that's shared with instance
+// endpoints; e.g., abc123.us-east-1.rds.amazonaws.com for
+// prod.cluster-abc123.us-east-1.rds.amazonaws.com.
+func suffixOf(endpoint string) (string, error) {
+	parts := strings.SplitN(endpoint, ".", 3)
+
+	if len(parts) < 3 || parts[0] == "" {
+		return "", ErrNotClusterEndpoint
+	}
+
+	for _, prefix := range []string{"cluster-ro-", "cluster-"} {
+		if id, ok := strings.CutPrefix(parts[1], prefix); ok && id != "" {
+			return id + "." + parts[2], nil
+		}
+	}
+
+	return "", ErrNotClusterEndpoint
+}
+
+// Locator implements the lazydsn.Locator interface.
+var _ lazydsn.Locator = &Locator{}
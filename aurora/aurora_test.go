@@ -0,0 +1,174 @@
+package aurora_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkristic/lazydsn/aurora"
+)
+
+// cluster is the endpoint of the cluster used in tests.
+const cluster = "prod.cluster-abc123.us-east-1.rds.amazonaws.com"
+
+// fakeResolver answers CNAME lookups from a fixed table, recording the hosts
+// looked up.
+type fakeResolver struct {
+	cnames  map[string]string
+	lookups []string
+}
+
+func (r *fakeResolver) LookupCNAME(_ context.Context, host string) (string, error) {
+	r.lookups = append(r.lookups, host)
+
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+
+	return "", errors.New("no such host")
+}
+
+// instances is a topology with a writer and two readers.
+var instances = aurora.TopologyFunc(func(context.Context) ([]aurora.Instance, error) {
+	return []aurora.Instance{{ID: "db-2"}, {ID: "db-1", Writer: true}, {ID: "db-3"}}, nil
+})
+
+// TestNewLocator checks which endpoints are accepted.
+func TestNewLocator(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		err      error
+	}{
+		{cluster, nil},
+		{"prod.cluster-ro-abc123.us-east-1.rds.amazonaws.com", nil},
+		{"db-1.abc123.us-east-1.rds.amazonaws.com", aurora.ErrNotClusterEndpoint},
+		{"prod.cluster-.us-east-1.rds.amazonaws.com", aurora.ErrNotClusterEndpoint},
+		{".cluster-abc123.us-east-1.rds.amazonaws.com", aurora.ErrNotClusterEndpoint},
+		{"localhost", aurora.ErrNotClusterEndpoint},
+	}
+
+	for _, tt := range tests {
+		if _, err := aurora.NewLocator(tt.endpoint, aurora.Writer); !errors.Is(err, tt.err) {
+			t.Errorf("got %v for %q, want %v", err, tt.endpoint, tt.err)
+		}
+	}
+}
+
+// TestLocate checks the instances found, by role, with and without a
+// topology.
+func TestLocate(t *testing.T) {
+	failing := aurora.TopologyFunc(func(context.Context) ([]aurora.Instance, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	writerOnly := aurora.TopologyFunc(func(context.Context) ([]aurora.Instance, error) {
+		return []aurora.Instance{{ID: "db-1", Writer: true}}, nil
+	})
+
+	tests := []struct {
+		name     string
+		endpoint string
+		role     aurora.Role
+		topology aurora.Topology
+		want     []string
+	}{
+		{
+			name:     "writer from dns",
+			endpoint: cluster,
+			role:     aurora.Writer,
+			want:     []string{"db-1.abc123.us-east-1.rds.amazonaws.com"},
+		},
+		{
+			name:     "writer from reader endpoint",
+			endpoint: "prod.cluster-ro-abc123.us-east-1.rds.amazonaws.com",
+			role:     aurora.Writer,
+			want:     []string{"db-1.abc123.us-east-1.rds.amazonaws.com"},
+		},
+		{
+			name:     "writer from topology",
+			endpoint: cluster,
+			role:     aurora.Writer,
+			topology: instances,
+			want:     []string{"db-1.abc123.us-east-1.rds.amazonaws.com"},
+		},
+		{
+			name:     "readers from topology",
+			endpoint: cluster,
+			role:     aurora.Reader,
+			topology: instances,
+			want:     []string{"db-2.abc123.us-east-1.rds.amazonaws.com", "db-3.abc123.us-east-1.rds.amazonaws.com"},
+		},
+		{
+			name:     "no readers",
+			endpoint: cluster,
+			role:     aurora.Reader,
+			topology: writerOnly,
+			want:     []string{"db-1.abc123.us-east-1.rds.amazonaws.com"},
+		},
+		{
+			name:     "topology failing",
+			endpoint: cluster,
+			role:     aurora.Reader,
+			topology: failing,
+			want:     []string{"db-1.abc123.us-east-1.rds.amazonaws.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeResolver{cnames: map[string]string{
+				cluster: "db-1.abc123.us-east-1.rds.amazonaws.com.",
+			}}
+
+			opts := []aurora.Option{aurora.WithResolver(resolver)}
+
+			if tt.topology != nil {
+				opts = append(opts, aurora.WithTopology(tt.topology))
+			}
+
+			l, err := aurora.NewLocator(tt.endpoint, tt.role, opts...)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := l.Locate(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestLocateCanceled checks that DNS isn't used as a fallback when the
+// topology fails because the fetch is done.
+func TestLocateCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resolver := &fakeResolver{}
+	l, err := aurora.NewLocator(cluster, aurora.Writer,
+		aurora.WithResolver(resolver),
+		aurora.WithTopology(aurora.TopologyFunc(func(ctx context.Context) ([]aurora.Instance, error) {
+			return nil, ctx.Err()
+		})),
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Locate(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	if len(resolver.lookups) > 0 {
+		t.Errorf("got lookups %q, want none", resolver.lookups)
+	}
+}
@@ -0,0 +1,74 @@
+package aurora
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Engine selects the flavor of the topology tables to query.
+type Engine int
+
+// Aurora engines.
+const (
+	MySQL Engine = iota
+	PostgreSQL
+)
+
+// writerSession is the session ID that Aurora reports for the writer.
+const writerSession = "MASTER_SESSION_ID"
+
+// topologyQueries has the query that lists the cluster members, by engine.
+var topologyQueries = [...]string{
+	MySQL:      "SELECT server_id, session_id FROM information_schema.replica_host_status",
+	PostgreSQL: "SELECT server_id, session_id FROM aurora_replica_status()",
+}
+
+// dbTopology is a Topology backed by Aurora's topology tables.
+type dbTopology struct {
+	db     *sql.DB
+	engine Engine
+}
+
+// QueryTopology returns a Topology that queries Aurora's topology tables with
+// db. Every instance in the cluster has the same view, so db can point to any
+// of them (e.g., through the reader endpoint), but it must not be the
+// database opened with the provider that uses the topology, to avoid a
+// deadlock while the pool is exhausted. A database with a single, long lived
+// connection is enough.
+func QueryTopology(db *sql.DB, engine Engine) Topology {
+	return &dbTopology{
+		db:     db,
+		engine: engine,
+	}
+}
+
+// Instances lists the cluster members.
+func (t *dbTopology) Instances(ctx context.Context) ([]Instance, error) {
+	rows, err := t.db.QueryContext(ctx, topologyQueries[t.engine])
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var instances []Instance
+
+	for rows.Next() {
+		var id, session string
+
+		if err := rows.Scan(&id, &session); err != nil {
+			return nil, err
+		}
+
+		instances = append(instances, Instance{
+			ID:     id,
+			Writer: session == writerSession,
+		})
+	}
+
+	return instances, rows.Err()
+}
+
+// dbTopology implements the Topology interface.
+var _ Topology = &dbTopology{}
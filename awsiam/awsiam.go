@@ -0,0 +1,285 @@
+/*
+Package awsiam implements a lazydsn provider that authenticates with AWS
+signed tokens instead of passwords, as Amazon RDS and Aurora do with IAM
+database authentication, and Aurora DSQL does always. Tokens last 15 minutes
+at most, so there's no way to put one in a DSN given to sql.Open; the provider
+signs a new one every time a connection is opened, and injects it into the
+master DSN as the password (see lazydsn.ReplacePassword):
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	...
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(),
+		awsiam.New(cfg, awsiam.DSQLAdmin),
+	)
+
+	db, err := sql.Open("lazydsn:pgx",
+		"postgres://admin@abc123.dsql.us-east-1.on.aws:5432/postgres?sslmode=verify-full")
+
+The master DSN names the user and the endpoint, and carries no password. The
+region is taken from the endpoint, for RDS and DSQL host names, and from the
+AWS configuration otherwise; see WithRegion. Tokens are signed with the
+configuration's credentials, or with those of an IAM role; see WithRole.
+
+Note that MySQL clients must send tokens in clear text, over TLS; e.g., with
+"tls=true&allowCleartextPasswords=true" for github.com/go-sql-driver/mysql.
+Tokens carry no secrets beyond the signature, but are valid until they
+expire, for as many connections as needed. Signing is done locally, and
+costs no API calls.
+*/
+package awsiam
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/gkristic/lazydsn"
+)
+
+// ErrNoRegion is returned when the region can't be told from the endpoint,
+// and none was configured.
+var ErrNoRegion = errors.New("awsiam: unknown region")
+
+// Service selects the kind of token to sign.
+type Service int
+
+// Services supported.
+const (
+	// RDS signs tokens for IAM database authentication with RDS and
+	// Aurora, for MySQL and PostgreSQL. The user in the master DSN must
+	// be granted the rds_iam role (or the AWSAuthenticationPlugin, for
+	// MySQL).
+	RDS Service = iota
+
+	// DSQL signs tokens for Aurora DSQL, for custom database roles.
+	DSQL
+
+	// DSQLAdmin signs tokens for Aurora DSQL, for the admin role.
+	DSQLAdmin
+)
+
+// maxExpiry is the longest that tokens are accepted for, by both RDS and
+// DSQL.
+const maxExpiry = 15 * time.Minute
+
+// emptyPayload is the SHA-256 hash of an empty payload, which is what tokens
+// are signed for.
+const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Provider signs authentication tokens for database endpoints. It implements
+// lazydsn.InfoDSNProvider.
+type Provider struct {
+	cfg     aws.Config
+	service Service
+	region  string
+	role    string
+	expiry  time.Duration
+	creds   aws.CredentialsProvider
+	signer  *v4.Signer
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithRegion sets the region that tokens are signed for, instead of telling
+// it from the endpoint.
+func WithRegion(region string) Option {
+	return func(p *Provider) {
+		p.region = region
+	}
+}
+
+// WithRole has tokens signed with the credentials of the IAM role with the
+// given ARN, assumed with the configuration's credentials. Those are renewed
+// as needed.
+func WithRole(arn string) Option {
+	return func(p *Provider) {
+		p.role = arn
+	}
+}
+
+// WithExpiry sets how long tokens are valid for, up to 15 minutes, which is
+// also the default. That bounds the time that a token leaked can be used for,
+// but it doesn't affect connections already open.
+func WithExpiry(d time.Duration) Option {
+	return func(p *Provider) {
+		p.expiry = d
+	}
+}
+
+// New creates a provider that signs tokens for service, with the credentials
+// in cfg.
+func New(cfg aws.Config, service Service, opts ...Option) *Provider {
+	p := &Provider{
+		cfg:     cfg,
+		service: service,
+		expiry:  maxExpiry,
+		creds:   cfg.Credentials,
+		signer:  v4.NewSigner(),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if p.expiry <= 0 || p.expiry > maxExpiry {
+		p.expiry = maxExpiry
+	}
+
+	if p.role != "" {
+		p.creds = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), p.role))
+	}
+
+	return p
+}
+
+// FetchDSN implements the lazydsn.DSNProvider interface.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the lazydsn.FullDSNProvider interface.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo signs a new token for the endpoint and user in dsn, and
+// returns dsn with the token as the password. Tokens are reported as issued
+// right away.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	view := lazydsn.ParseDSN(dsn)
+
+	if view.Host == "" {
+		return lazydsn.DSNInfo{}, errors.New("awsiam: no endpoint in DSN")
+	}
+
+	host, port, err := net.SplitHostPort(view.Host)
+
+	if err != nil {
+		host, port = view.Host, defaultPort(view)
+	}
+
+	region := p.region
+
+	if region == "" {
+		region = regionOf(host)
+	}
+
+	if region == "" {
+		region = p.cfg.Region
+	}
+
+	if region == "" {
+		return lazydsn.DSNInfo{}, ErrNoRegion
+	}
+
+	creds, err := p.creds.Retrieve(ctx)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	now := time.Now()
+	token, err := p.sign(ctx, creds, host, port, view.User, region, now)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	inner, err := lazydsn.ReplacePassword(dsn, token)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	return lazydsn.DSNInfo{
+		DSN:    inner,
+		Issued: now,
+	}, nil
+}
+
+// sign returns a token for the given endpoint and user. Tokens are presigned
+// URLs, without the scheme.
+func (p *Provider) sign(ctx context.Context, creds aws.Credentials, host, port, user, region string,
+	now time.Time) (string, error) {
+	query := url.Values{}
+	var service string
+
+	switch p.service {
+	case DSQL:
+		service = "dsql"
+		query.Set("Action", "DbConnect")
+	case DSQLAdmin:
+		service = "dsql"
+		query.Set("Action", "DbConnectAdmin")
+	default:
+		service = "rds-db"
+		host = net.JoinHostPort(host, port)
+		query.Set("Action", "connect")
+		query.Set("DBUser", user)
+	}
+
+	query.Set("X-Amz-Expires", strconv.Itoa(int(p.expiry/time.Second)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/?"+query.Encode(), nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	signed, _, err := p.signer.PresignHTTP(ctx, creds, req, emptyPayload, service, region, now)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// defaultPort returns the port that the inner driver would connect to, for
+// DSNs that don't set one.
+func defaultPort(view lazydsn.DSNView) string {
+	switch {
+	case view.Params["port"] != "":
+		// A port key that couldn't be joined to the host.
+		return view.Params["port"]
+	case view.Format == lazydsn.FormatMySQL:
+		return "3306"
+	}
+
+	return "5432"
+}
+
+// regionOf tells the region from RDS and DSQL host names; e.g.,
+// db.abc123.us-east-1.rds.amazonaws.com or abc123.dsql.us-east-1.on.aws.
+func regionOf(host string) string {
+	labels := strings.Split(host, ".")
+
+	for i, label := range labels {
+		switch {
+		case label == "rds" && i > 1:
+			return labels[i-1]
+		case label == "dsql" && i+1 < len(labels):
+			return labels[i+1]
+		}
+	}
+
+	return ""
+}
+
+// Provider implements the lazydsn.InfoDSNProvider and lazydsn.FullDSNProvider
+// interfaces.
+var (
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.FullDSNProvider = &Provider{}
+)
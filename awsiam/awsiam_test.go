@@ -0,0 +1,174 @@
+package awsiam_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/gkristic/lazydsn/awsiam"
+)
+
+// config has static credentials, and no region.
+var config = aws.Config{
+	Credentials: credentials.NewStaticCredentialsProvider("AKIDEXAMPLE", "secret", ""),
+}
+
+// tokenOf returns the password in dsn, which is either a URL or a MySQL DSN.
+func tokenOf(t *testing.T, dsn string) string {
+	t.Helper()
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		password, _ := u.User.Password()
+
+		return password
+	}
+
+	start := strings.Index(dsn, ":") + 1
+	end := strings.LastIndex(dsn, "@tcp(")
+
+	return dsn[start:end]
+}
+
+// TestFetch checks the tokens signed, and the DSNs assembled with them.
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		name    string
+		service awsiam.Service
+		dsn     string
+		opts    []awsiam.Option
+		cfg     string
+		host    string
+		action  string
+		user    string
+		scope   string
+		expires string
+	}{
+		{
+			name:    "rds",
+			service: awsiam.RDS,
+			dsn:     "postgres://app@db.abc123.us-east-1.rds.amazonaws.com:5432/orders?sslmode=require",
+			host:    "db.abc123.us-east-1.rds.amazonaws.com:5432",
+			action:  "connect",
+			user:    "app",
+			scope:   "/us-east-1/rds-db/aws4_request",
+			expires: "900",
+		},
+		{
+			name:    "rds mysql",
+			service: awsiam.RDS,
+			dsn:     "app@tcp(db.abc123.eu-west-1.rds.amazonaws.com)/orders?tls=true",
+			opts:    []awsiam.Option{awsiam.WithExpiry(5 * time.Minute)},
+			host:    "db.abc123.eu-west-1.rds.amazonaws.com:3306",
+			action:  "connect",
+			user:    "app",
+			scope:   "/eu-west-1/rds-db/aws4_request",
+			expires: "300",
+		},
+		{
+			name:    "dsql admin",
+			service: awsiam.DSQLAdmin,
+			dsn:     "postgres://admin@abc123.dsql.us-east-2.on.aws:5432/postgres",
+			host:    "abc123.dsql.us-east-2.on.aws",
+			action:  "DbConnectAdmin",
+			scope:   "/us-east-2/dsql/aws4_request",
+			expires: "900",
+		},
+		{
+			name:    "dsql region from config",
+			service: awsiam.DSQL,
+			dsn:     "postgres://app@db.internal:5432/postgres",
+			opts:    []awsiam.Option{awsiam.WithExpiry(time.Hour)},
+			cfg:     "ap-south-1",
+			host:    "db.internal",
+			action:  "DbConnect",
+			scope:   "/ap-south-1/dsql/aws4_request",
+			expires: "900",
+		},
+		{
+			name:    "region set",
+			service: awsiam.RDS,
+			dsn:     "postgres://app@db.abc123.us-east-1.rds.amazonaws.com/orders",
+			opts:    []awsiam.Option{awsiam.WithRegion("us-west-2")},
+			host:    "db.abc123.us-east-1.rds.amazonaws.com:5432",
+			action:  "connect",
+			user:    "app",
+			scope:   "/us-west-2/rds-db/aws4_request",
+			expires: "900",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Copy()
+			cfg.Region = tt.cfg
+
+			info, err := awsiam.New(cfg, tt.service, tt.opts...).FetchDSNInfo(context.Background(), tt.dsn)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Issued.IsZero() {
+				t.Error("got no issue time")
+			}
+
+			token := tokenOf(t, info.DSN)
+			host, rawQuery, ok := strings.Cut(token, "/?")
+
+			if !ok || host != tt.host {
+				t.Fatalf("got token %q, want one for %q", token, tt.host)
+			}
+
+			query, err := url.ParseQuery(rawQuery)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := query.Get("Action"); got != tt.action {
+				t.Errorf("got action %q, want %q", got, tt.action)
+			}
+
+			if got := query.Get("DBUser"); got != tt.user {
+				t.Errorf("got user %q, want %q", got, tt.user)
+			}
+
+			if got := query.Get("X-Amz-Credential"); !strings.HasPrefix(got, "AKIDEXAMPLE/") || !strings.HasSuffix(got, tt.scope) {
+				t.Errorf("got credential %q, want one scoped to %q", got, tt.scope)
+			}
+
+			if got := query.Get("X-Amz-Expires"); got != tt.expires {
+				t.Errorf("got expiry %q, want %q", got, tt.expires)
+			}
+
+			if query.Get("X-Amz-Signature") == "" {
+				t.Error("got an unsigned token")
+			}
+		})
+	}
+}
+
+// TestFetchErrors checks that DSNs that tokens can't be signed for are
+// refused.
+func TestFetchErrors(t *testing.T) {
+	p := awsiam.New(config, awsiam.RDS)
+
+	if _, err := p.FetchDSN("postgres://app@db.internal:5432/orders"); !errors.Is(err, awsiam.ErrNoRegion) {
+		t.Errorf("got %v without a region, want %v", err, awsiam.ErrNoRegion)
+	}
+
+	if _, err := p.FetchDSN("dbname=orders user=app"); err == nil {
+		t.Error("got no error without an endpoint")
+	}
+}
@@ -0,0 +1,317 @@
+/*
+Package awssm implements a lazydsn provider backed by AWS Secrets Manager. The
+master DSN given to sql.Open is the secret's ARN (or name), and the inner DSN is
+built from the secret's value every time a connection is opened:
+
+	client := secretsmanager.NewFromConfig(cfg)
+	lazydsn.Register("lazydsn:mysql", &mysql.MySQLDriver{},
+		awssm.New(client, awssm.MySQL),
+		lazydsn.WithClassifier(mysqlClassifier),
+	)
+
+	db, err := sql.Open("lazydsn:mysql", "arn:aws:secretsmanager:...")
+
+The provider is aware of Secrets Manager's staging labels. DSNs are resolved
+from the AWSCURRENT version by default, but the multi-step rotation process
+leaves a window where the database already accepts AWSPENDING only, or still
+accepts AWSPREVIOUS only. The provider reports both as fallbacks, so the driver
+retries with them when the database rejects the current credentials. Note that
+the driver can only tell authentication errors apart with the help of a
+lazydsn.Classifier for the inner driver.
+
+The AWSPENDING version can also be tried ahead of time, while a rotation is
+in progress, to catch broken rotations before they take effect; see
+lazydsn.WithDryRun.
+
+Secrets replicated to other regions can be read from there when the primary
+region's API can't be reached; see WithReplicas.
+*/
+package awssm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/gkristic/lazydsn"
+)
+
+// Staging labels used by Secrets Manager rotation.
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePending  = "AWSPENDING"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// Client is the subset of the Secrets Manager API used by the provider.
+// *secretsmanager.Client implements it.
+type Client interface {
+	GetSecretValue(context.Context, *secretsmanager.GetSecretValueInput,
+		...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// Secret is the value of a secret. Secrets managed by RDS, and those following
+// the same JSON structure, have their fields decoded. Raw always holds the
+// value exactly as stored.
+type Secret struct {
+	Engine   string      `json:"engine"`
+	Host     string      `json:"host"`
+	Port     json.Number `json:"port"`
+	Username string      `json:"username"`
+	Password string      `json:"password"`
+	DBName   string      `json:"dbname"`
+
+	Raw string `json:"-"`
+}
+
+// A Formatter builds the inner DSN out of a secret. It's given the master DSN
+// too, which is the secret's ARN or name.
+type Formatter func(masterDSN string, s *Secret) (string, error)
+
+// Provider resolves DSNs from Secrets Manager. It implements both
+// lazydsn.FullDSNProvider and lazydsn.VersionedDSNProvider, so the driver can
+// be pinned to a given version of the secret too; versions are either staging
+// labels or version IDs.
+type Provider struct {
+	clients []Client
+	format  Formatter
+
+	mu     sync.Mutex
+	newest time.Time
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithReplicas adds clients for the regions that the secret is replicated to,
+// in order of preference. When reading the secret fails with a client, the
+// next one is tried. Replication is asynchronous, so a replica can still hold
+// an older version of the secret for a while after a rotation; replicas whose
+// current version is older than the newest one seen so far are skipped in
+// favor of the next one, and only used if nothing newer is available.
+func WithReplicas(clients ...Client) Option {
+	return func(p *Provider) {
+		p.clients = append(p.clients, clients...)
+	}
+}
+
+// New creates a provider using the given client, for the secret's primary
+// region. If format is nil, secrets are expected to hold the inner DSN as
+// plain text. Options, if any, are applied in order.
+func New(client Client, format Formatter, opts ...Option) *Provider {
+	if format == nil {
+		format = Raw
+	}
+
+	p := &Provider{
+		clients: []Client{client},
+		format:  format,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// FetchDSN resolves the DSN from the current version of the secret.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves the DSN from the current version of the
+// secret.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNVersion(ctx, dsn, "")
+
+	return info.DSN, err
+}
+
+// FetchDSNVersion resolves the DSN from the given version of the secret, which
+// can be either a staging label or a version ID. An empty version stands for
+// AWSCURRENT; only then are AWSPENDING and AWSPREVIOUS reported as fallbacks,
+// because an explicitly requested version must be honored.
+func (p *Provider) FetchDSNVersion(ctx context.Context, dsn, version string) (lazydsn.DSNInfo, error) {
+	in := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(dsn),
+	}
+
+	switch {
+	case version == "":
+		in.VersionStage = aws.String(StageCurrent)
+	case isVersionID(version):
+		in.VersionId = aws.String(version)
+	default:
+		in.VersionStage = aws.String(version)
+	}
+
+	out, err := p.get(ctx, in, version == "")
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	secret, err := decode(out)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	innerDSN, err := p.format(dsn, secret)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	info := lazydsn.DSNInfo{
+		DSN:     innerDSN,
+		Version: aws.ToString(out.VersionId),
+		Issued:  aws.ToTime(out.CreatedDate),
+	}
+
+	if version == "" {
+		info.Fallbacks = []string{StagePending, StagePrevious}
+	}
+
+	return info, nil
+}
+
+// FetchStagedDSN resolves the DSN from the AWSPENDING version of the secret,
+// while a rotation is in progress; i.e., when there's such a version, and it's
+// not AWSCURRENT yet. See lazydsn.StagedDSNProvider.
+func (p *Provider) FetchStagedDSN(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	info, err := p.FetchDSNVersion(ctx, dsn, StagePending)
+
+	if notFound := (*types.ResourceNotFoundException)(nil); errors.As(err, &notFound) {
+		return lazydsn.DSNInfo{}, lazydsn.ErrNothingStaged
+	}
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	current, err := p.FetchDSNVersion(ctx, dsn, StageCurrent)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	if current.Version == info.Version {
+		return lazydsn.DSNInfo{}, lazydsn.ErrNothingStaged
+	}
+
+	return info, nil
+}
+
+// get reads the secret, trying every client in order until one succeeds. When
+// reading the current version, responses older than the newest seen are only
+// used if no other client has anything newer. The error from the first client
+// is returned if all fail.
+func (p *Provider) get(ctx context.Context, in *secretsmanager.GetSecretValueInput,
+	current bool) (*secretsmanager.GetSecretValueOutput, error) {
+	var (
+		stale    *secretsmanager.GetSecretValueOutput
+		firstErr error
+	)
+
+	p.mu.Lock()
+	newest := p.newest
+	p.mu.Unlock()
+
+	for _, client := range p.clients {
+		out, err := client.GetSecretValue(ctx, in)
+
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		}
+
+		created := aws.ToTime(out.CreatedDate)
+
+		if !current || !created.Before(newest) {
+			if current {
+				p.seen(created)
+			}
+
+			return out, nil
+		}
+
+		if stale == nil || created.After(aws.ToTime(stale.CreatedDate)) {
+			stale = out
+		}
+	}
+
+	if stale != nil {
+		return stale, nil
+	}
+
+	return nil, firstErr
+}
+
+// seen takes note of the creation time of a current version.
+func (p *Provider) seen(created time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if created.After(p.newest) {
+		p.newest = created
+	}
+}
+
+// decode extracts the secret from a response.
+func decode(out *secretsmanager.GetSecretValueOutput) (*Secret, error) {
+	s := &Secret{}
+
+	if out.SecretString != nil {
+		s.Raw = *out.SecretString
+	} else {
+		s.Raw = string(out.SecretBinary)
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(s.Raw), "{") {
+		if err := json.Unmarshal([]byte(s.Raw), s); err != nil {
+			return nil, errors.New("awssm: secret is not valid JSON")
+		}
+	}
+
+	return s, nil
+}
+
+// isVersionID tells whether v looks like a version ID (a UUID) rather than a
+// staging label.
+func isVersionID(v string) bool {
+	n := 0
+
+	for _, r := range v {
+		switch {
+		case r == '-':
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+			n++
+		default:
+			return false
+		}
+	}
+
+	return n == 32
+}
+
+// Provider implements the lazydsn provider interfaces.
+var (
+	_ lazydsn.FullDSNProvider      = &Provider{}
+	_ lazydsn.VersionedDSNProvider = &Provider{}
+	_ lazydsn.StagedDSNProvider    = &Provider{}
+)
@@ -0,0 +1,114 @@
+package awssm_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/awssm"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// fakeClient serves secret values out of a map, by staging label.
+type fakeClient map[string]string
+
+func (c fakeClient) GetSecretValue(_ context.Context, in *secretsmanager.GetSecretValueInput,
+	_ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := c[aws.ToString(in.VersionStage)]
+
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+
+	return &secretsmanager.GetSecretValueOutput{
+		SecretString: aws.String(value),
+		VersionId:    aws.String("id-" + value),
+	}, nil
+}
+
+// TestStageFallbacks checks that credentials rejected by the database are
+// retried with the AWSPENDING and AWSPREVIOUS versions, in that order, and
+// that versions holding credentials tried already are skipped.
+func TestStageFallbacks(t *testing.T) {
+	tests := []struct {
+		name     string
+		stages   fakeClient
+		rejected []string
+		want     []string
+	}{
+		{
+			name: "pending",
+			stages: fakeClient{
+				awssm.StageCurrent:  "db/old",
+				awssm.StagePending:  "db/new",
+				awssm.StagePrevious: "db/older",
+			},
+			rejected: []string{"db/old", "db/older"},
+			want:     []string{"db/old", "db/new"},
+		},
+		{
+			name: "previous",
+			stages: fakeClient{
+				awssm.StageCurrent:  "db/new",
+				awssm.StagePending:  "db/new",
+				awssm.StagePrevious: "db/old",
+			},
+			rejected: []string{"db/new"},
+			want:     []string{"db/new", "db/old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := lazydsntest.NewDriver()
+
+			for _, dsn := range tt.rejected {
+				inner.Fail(dsn, lazydsntest.ErrAuth)
+			}
+
+			db := sql.OpenDB(lazydsn.NewConnector(inner, "secret", awssm.New(tt.stages, nil),
+				lazydsn.WithClassifier(lazydsntest.Classifier),
+			))
+			defer db.Close()
+
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := inner.Attempts(); !slices.Equal(got, tt.want) {
+				t.Errorf("got attempts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExplicitVersion checks that no fallbacks are reported for versions
+// requested explicitly.
+func TestExplicitVersion(t *testing.T) {
+	p := awssm.New(fakeClient{
+		awssm.StageCurrent: "db/new",
+		awssm.StagePending: "db/next",
+	}, nil)
+
+	info, err := p.FetchDSNVersion(context.Background(), "secret", awssm.StagePending)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.DSN != "db/next" || len(info.Fallbacks) != 0 {
+		t.Errorf("got %q with fallbacks %v, want db/next and none", info.DSN, info.Fallbacks)
+	}
+
+	if _, err := p.FetchDSNVersion(context.Background(), "secret", awssm.StagePrevious); err == nil {
+		t.Error("got no error for a missing stage")
+	} else if notFound := (*types.ResourceNotFoundException)(nil); !errors.As(err, &notFound) {
+		t.Errorf("got %v, want the client's", err)
+	}
+}
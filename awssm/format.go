@@ -0,0 +1,54 @@
+package awssm
+
+import (
+	"errors"
+	"net"
+	"net/url"
+)
+
+// errNoHost is returned by formatters when the secret lacks the fields needed.
+var errNoHost = errors.New("awssm: secret has no host")
+
+// Raw uses the secret's value as the inner DSN, as is.
+func Raw(_ string, s *Secret) (string, error) {
+	return s.Raw, nil
+}
+
+// MySQL builds a DSN for github.com/go-sql-driver/mysql from an RDS style
+// secret. Port defaults to 3306.
+func MySQL(_ string, s *Secret) (string, error) {
+	if s.Host == "" {
+		return "", errNoHost
+	}
+
+	port := s.Port.String()
+
+	if port == "" {
+		port = "3306"
+	}
+
+	return s.Username + ":" + s.Password + "@tcp(" + net.JoinHostPort(s.Host, port) + ")/" + s.DBName, nil
+}
+
+// PostgreSQL builds a URL style DSN, as understood by both github.com/lib/pq
+// and github.com/jackc/pgx, from an RDS style secret. Port defaults to 5432.
+func PostgreSQL(_ string, s *Secret) (string, error) {
+	if s.Host == "" {
+		return "", errNoHost
+	}
+
+	port := s.Port.String()
+
+	if port == "" {
+		port = "5432"
+	}
+
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(s.Username, s.Password),
+		Host:   net.JoinHostPort(s.Host, port),
+		Path:   "/" + s.DBName,
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,60 @@
+package lazydsn
+
+// Balancing selects how new connections are distributed among the candidate
+// DSNs returned by a MultiDSNProvider. Whatever the strategy, candidates that
+// failed recently are only tried once all others did; see WithCooldown.
+type Balancing int
+
+// Balancing strategies.
+const (
+	// Failover sends every connection to the first candidate that works,
+	// in the provider's order. This is the default, meant for active and
+	// standby pairs.
+	Failover Balancing = iota
+
+	// RoundRobin rotates the first candidate tried with every new
+	// connection, meant for fleets of equivalent replicas.
+	RoundRobin
+
+	// LeastOpen tries first the candidate with the fewest connections
+	// currently open through the driver. Connections are wrapped to find
+	// out when they are closed; the wrapper preserves all the optional
+	// interfaces that database/sql knows about.
+	LeastOpen
+
+	// Weighted spreads connections in proportion to each candidate's
+	// Weight (see DSNInfo), with smooth weighted round robin. Choices are
+	// deterministic and interleaved, so proportions hold even for small
+	// pools; e.g., weights 3 and 1 yield A, A, B, A, and over again.
+	// Candidates with no weight are only tried when those with weights
+	// fail, unless no candidate has weights, in which case they all weigh
+	// the same.
+	Weighted
+)
+
+// String returns the name of the strategy.
+func (b Balancing) String() string {
+	switch b {
+	case Failover:
+		return "failover"
+	case RoundRobin:
+		return "round-robin"
+	case LeastOpen:
+		return "least-open"
+	case Weighted:
+		return "weighted"
+	}
+
+	return "unknown"
+}
+
+// EndpointStats describes the health of a single endpoint, as seen by the
+// driver. Failures counts consecutive failed connection attempts, and is reset
+// by the first success. Open is only tracked when balancing with LeastOpen.
+// Evicted is only set by health probes; see HealthProbe.
+type EndpointStats struct {
+	Open     int64 // Connections currently open
+	Failures int64 // Consecutive failures to connect
+	Cooling  bool  // Whether it's in its cool-down period
+	Evicted  bool  // Whether health probes are failing
+}
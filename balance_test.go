@@ -0,0 +1,89 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// weightedProvider returns candidates with the given weights, named after
+// their DSNs.
+type weightedProvider map[string]int
+
+func (p weightedProvider) FetchDSN(string) (string, error) {
+	infos, _ := p.FetchDSNs(context.Background(), "")
+	return infos[0].DSN, nil
+}
+
+func (p weightedProvider) FetchDSNs(context.Context, string) ([]lazydsn.DSNInfo, error) {
+	var infos []lazydsn.DSNInfo
+
+	for _, dsn := range slices.Sorted(maps.Keys(p)) {
+		infos = append(infos, lazydsn.DSNInfo{DSN: dsn, Endpoint: dsn, Weight: p[dsn]})
+	}
+
+	return infos, nil
+}
+
+// TestWeighted checks that connections are spread in proportion to weights,
+// interleaved, and that candidates without a weight are only a fallback.
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights weightedProvider
+		want    []string
+	}{
+		{
+			name:    "interleaved",
+			weights: weightedProvider{"a": 3, "b": 1},
+			want:    []string{"a", "a", "b", "a", "a", "a", "b", "a"},
+		},
+		{
+			name:    "even",
+			weights: weightedProvider{"a": 1, "b": 1, "c": 1},
+			want:    []string{"a", "b", "c", "a", "b", "c"},
+		},
+		{
+			name:    "no weights",
+			weights: weightedProvider{"a": 0, "b": 0},
+			want:    []string{"a", "b", "a", "b"},
+		},
+		{
+			name:    "fallback",
+			weights: weightedProvider{"a": 1, "b": 0},
+			want:    []string{"a", "a", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := lazydsntest.NewDriver()
+			connector, err := lazydsn.New(inner, tt.weights,
+				lazydsn.WithBalancing(lazydsn.Weighted),
+			).OpenConnector("master")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(connector)
+			db.SetMaxIdleConns(0)
+			defer db.Close()
+
+			for range tt.want {
+				if err := db.Ping(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := inner.Attempts(); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package lazydsn
+
+import (
+	"context"
+	"sync"
+)
+
+// Endpoint names for blue/green deployments, used unless the provider sets
+// its own.
+const (
+	EndpointBlue  = "blue"
+	EndpointGreen = "green"
+)
+
+// BlueGreen describes a database migration in progress, from Blue (the
+// current database) to Green (the new one). GreenPercent is the share of new
+// connections, from 0 to 100, that should go to Green; Green is ignored
+// altogether while it's zero, and Blue while it's 100. Green may be left
+// empty when there's no migration going on.
+type BlueGreen struct {
+	Blue         DSNInfo
+	Green        DSNInfo
+	GreenPercent float64
+}
+
+// A BlueGreenProvider supports gradual migrations between databases, as with
+// blue/green deployments. The share of new connections going to the new
+// database is set by the provider, so it can be raised step by step while
+// things go well, or dropped to zero to roll back. New connections are split
+// deterministically, so that the share holds even for small pools. Should
+// connecting to green fail, blue is tried instead, but never the other way
+// around; it's up to the provider to cut over entirely. Connections already
+// open are not affected by changes; set a connection lifetime on the pool for
+// the share to apply to it as a whole over time.
+//
+// Blue/green providers take precedence over all other provider interfaces.
+// Balancing (see WithBalancing) doesn't apply to them.
+type BlueGreenProvider interface {
+	FetchBlueGreen(ctx context.Context, dsn string) (BlueGreen, error)
+}
+
+// blueGreenState keeps track of how new connections were split, by master
+// DSN.
+type blueGreenState struct {
+	mu     sync.Mutex
+	splits map[string]*split
+}
+
+// split counts the connections routed while a given share was in effect.
+type split struct {
+	percent float64
+	total   int64
+	green   int64
+}
+
+// resolveBlueGreen fetches both databases for dsn, returning them as
+// candidates (blue first) along with the share for green.
+func (d *Driver) resolveBlueGreen(ctx context.Context, dsn string) ([]DSNInfo, float64, error) {
+	bg, err := d.bgdsnp.FetchBlueGreen(ctx, dsn)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	infos := []DSNInfo{bg.Blue}
+
+	if bg.Green.DSN != "" && bg.GreenPercent > 0 {
+		infos = append(infos, bg.Green)
+	}
+
+	for i, name := range []string{EndpointBlue, EndpointGreen}[:len(infos)] {
+		if infos[i], err = d.process(dsn, infos[i]); err != nil {
+			return nil, 0, err
+		}
+
+		if infos[i].Endpoint == "" {
+			infos[i].Endpoint = name
+		}
+	}
+
+	if bg.GreenPercent >= 100 && len(infos) == 2 {
+		infos = infos[1:]
+	}
+
+	return infos, bg.GreenPercent, nil
+}
+
+// share sets the share for green, as last reported by the provider. The
+// split starts over whenever it changes.
+func (s *blueGreenState) share(key string, percent float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.splits == nil {
+		s.splits = make(map[string]*split)
+	}
+
+	if sp := s.splits[key]; sp == nil || sp.percent != percent {
+		s.splits[key] = &split{
+			percent: percent,
+		}
+	}
+}
+
+// route picks the database for a new connection, returning the candidates in
+// the order they should be tried: green then blue, or blue alone.
+func (s *blueGreenState) route(key string, candidates []DSNInfo) []DSNInfo {
+	if len(candidates) < 2 {
+		return candidates
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sp := s.splits[key]
+
+	if sp == nil {
+		return candidates[:1]
+	}
+
+	// Send this one to green if that keeps green at or below its share.
+	sp.total++
+
+	if float64(sp.green+1) <= sp.percent/100*float64(sp.total) {
+		sp.green++
+		return []DSNInfo{candidates[1], candidates[0]}
+	}
+
+	return candidates[:1]
+}
@@ -0,0 +1,105 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// blueGreenProvider migrates from "blue" to "green", sending percent of new
+// connections to green.
+type blueGreenProvider struct {
+	percent float64
+}
+
+func (p blueGreenProvider) FetchDSN(string) (string, error) {
+	return "blue", nil
+}
+
+func (p blueGreenProvider) FetchBlueGreen(context.Context, string) (lazydsn.BlueGreen, error) {
+	return lazydsn.BlueGreen{
+		Blue:         lazydsn.DSNInfo{DSN: "blue"},
+		Green:        lazydsn.DSNInfo{DSN: "green"},
+		GreenPercent: p.percent,
+	}, nil
+}
+
+// TestBlueGreenSplit checks that new connections are split as the share for
+// green says, deterministically, and that blue takes over when green fails.
+func TestBlueGreenSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent float64
+		fail    bool
+		want    []string
+	}{
+		{
+			name:    "none",
+			percent: 0,
+			want:    []string{"blue", "blue", "blue", "blue"},
+		},
+		{
+			name:    "quarter",
+			percent: 25,
+			want:    []string{"blue", "blue", "blue", "green", "blue", "blue", "blue", "green"},
+		},
+		{
+			name:    "half",
+			percent: 50,
+			want:    []string{"blue", "green", "blue", "green"},
+		},
+		{
+			name:    "all",
+			percent: 100,
+			want:    []string{"green", "green", "green", "green"},
+		},
+		{
+			name:    "green failing",
+			percent: 50,
+			fail:    true,
+			want:    []string{"blue", "green", "blue", "blue"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := lazydsntest.NewDriver()
+
+			if tt.fail {
+				inner.Fail("green", errors.New("connection refused"))
+			}
+
+			connector, err := lazydsn.New(inner, blueGreenProvider{tt.percent}).OpenConnector("master")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(connector)
+			db.SetMaxIdleConns(0)
+			defer db.Close()
+
+			// Connections that fail on green take two attempts.
+			pings := len(tt.want)
+
+			if tt.fail {
+				pings--
+			}
+
+			for range pings {
+				if err := db.Ping(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := inner.Attempts(); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package lazydsn
+
+import (
+	"context"
+	"time"
+)
+
+// fetchContext returns the context to fetch candidates with, while opening a
+// connection with ctx. If ctx has a deadline, the fetch only gets its share
+// of the time left; see WithFetchBudget. Deadlines are set by the wall clock,
+// so this doesn't go through the driver's Clock.
+func (d *Driver) fetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+
+	if !ok || d.fetchBudget <= 0 || d.fetchBudget >= 1 {
+		return ctx, func() {}
+	}
+
+	now := time.Now()
+	share := time.Duration(float64(deadline.Sub(now)) * d.fetchBudget)
+
+	return context.WithDeadline(ctx, now.Add(share))
+}
@@ -0,0 +1,112 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ErrorClass is a coarse category for failures observed by the driver. It's
+// meant to tell apart problems that need completely different runbooks: a
+// secrets backend that can't be reached is not the same as a database that
+// rejects the credentials it was given.
+type ErrorClass int
+
+// Error classes known to this package. ClassNone is used for events that
+// carry no error at all.
+const (
+	ClassNone ErrorClass = iota
+	ClassUnknown
+	ClassProvider
+	ClassAuth
+	ClassNetwork
+	ClassTimeout
+
+	numErrorClasses
+)
+
+var errorClassNames = [numErrorClasses]string{
+	ClassNone:     "none",
+	ClassUnknown:  "unknown",
+	ClassProvider: "provider",
+	ClassAuth:     "auth",
+	ClassNetwork:  "network",
+	ClassTimeout:  "timeout",
+}
+
+// String returns a short, lowercase name for the class, suitable for use as a
+// metric label.
+func (c ErrorClass) String() string {
+	if c < 0 || c >= numErrorClasses {
+		return errorClassNames[ClassUnknown]
+	}
+
+	return errorClassNames[c]
+}
+
+// MarshalText implements encoding.TextMarshaler, so that classes show up by
+// name when used as JSON keys or values.
+func (c ErrorClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// A Classifier maps errors returned by the inner driver into an ErrorClass.
+// Only the inner driver knows how to recognize its own authentication errors
+// (MySQL's 1045, PostgreSQL's 28P01, etc.), so applications are expected to
+// plug in a classifier for the driver they use. Classifiers should return
+// ClassUnknown for errors they don't recognize; this package then falls back
+// to detecting timeouts and network errors on its own.
+type Classifier interface {
+	Classify(error) ErrorClass
+}
+
+// ClassifierFunc allows using a plain function as a Classifier.
+type ClassifierFunc func(error) ErrorClass
+
+// Classify exercises the original function.
+func (f ClassifierFunc) Classify(err error) ErrorClass {
+	return f(err)
+}
+
+// classify determines the class of an error returned by the inner driver,
+// giving precedence to the configured classifier, if any.
+func (d *Driver) classify(err error) ErrorClass {
+	if err == nil {
+		return ClassNone
+	}
+
+	if d.classifier != nil {
+		if class := d.classifier.Classify(err); class != ClassUnknown && class != ClassNone {
+			return class
+		}
+	}
+
+	return classifyCommon(err)
+}
+
+// classifyCommon recognizes the driver independent error types found in the
+// standard library.
+func classifyCommon(err error) ErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return ClassTimeout
+	}
+
+	var netErr net.Error
+
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ClassTimeout
+		}
+
+		return ClassNetwork
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return ClassNetwork
+	}
+
+	return ClassUnknown
+}
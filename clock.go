@@ -0,0 +1,31 @@
+package lazydsn
+
+import (
+	"time"
+)
+
+// A Clock tells the time to the driver. Every time dependent feature (e.g.,
+// cool-down periods, credential age, health probes) goes through the clock, so
+// that tests can control time instead of waiting for it to pass; see
+// WithClock, and lazydsntest.Clock. Timeouts for network operations still
+// use real time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+// Now returns the current time.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for d to elapse.
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// realClock implements the Clock interface.
+var _ Clock = realClock{}
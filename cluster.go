@@ -0,0 +1,245 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// PoolConfig describes a pool opened with OpenDB, or one of the pools in a
+// Cluster. DSN is the master DSN that Provider resolves. Options are added to
+// those common to the cluster, if any.
+// The lifetime and size fields, when not zero, are applied to the resulting
+// sql.DB with the corresponding setters.
+//
+// Warmup, when positive, is the number of connections opened as soon as the
+// pool is, so that a service facing traffic right away doesn't pay for every
+// handshake on its first requests. Once a first connection is open (and thus
+// the DSN resolved), the rest are opened concurrently, up to
+// WarmupParallelism at a time (4 if zero). Connections are then left idle in
+// the pool, so Warmup is capped by MaxIdleConns (database/sql keeps 2 by
+// default) and MaxOpenConns. Warm-up holds the pool's opening back for up to
+// WarmupTimeout (30s if zero), and is best effort: failures, or running out
+// of time, just leave the pool with fewer connections.
+type PoolConfig struct {
+	DSN      string
+	Provider DSNProvider
+	Options  []Option
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	Warmup            int
+	WarmupParallelism int
+	WarmupTimeout     time.Duration
+}
+
+// Warm-up defaults; see PoolConfig.
+const (
+	defaultWarmupParallelism = 4
+	defaultMaxIdleConns      = 2
+	defaultWarmupTimeout     = 30 * time.Second
+)
+
+// ClusterConfig describes a writer/reader pair of pools for the same
+// database, backed by the given inner driver. The reader is optional; leave
+// its DSN empty to send reads to the writer. If only the reader's provider is
+// missing, the writer's provider is used with the reader's DSN, which is
+// handy for providers that resolve both endpoints from different secret
+// paths. Options apply to both pools.
+type ClusterConfig struct {
+	Driver  driver.Driver
+	Writer  PoolConfig
+	Reader  PoolConfig
+	Options []Option
+}
+
+// Cluster manages a writer and a reader pool, each with credentials resolved
+// by its own provider. Credential rotation and replica endpoints usually
+// change together, so having both pools come from the same configuration
+// keeps them in sync.
+type Cluster struct {
+	writer *sql.DB
+	reader *sql.DB
+}
+
+// OpenCluster opens the pools described by cfg. As with OpenConnector, the
+// providers are exercised right away, so that configuration errors surface
+// here.
+func OpenCluster(cfg ClusterConfig) (*Cluster, error) {
+	if cfg.Writer.Provider == nil {
+		return nil, errors.New("lazydsn: cluster writer needs a provider")
+	}
+
+	writer, err := openPool(cfg.Driver, cfg.Writer, cfg.Options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		writer: writer,
+		reader: writer,
+	}
+
+	if cfg.Reader.DSN != "" {
+		if cfg.Reader.Provider == nil {
+			cfg.Reader.Provider = cfg.Writer.Provider
+		}
+
+		if c.reader, err = openPool(cfg.Driver, cfg.Reader, cfg.Options); err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// openPool opens a single pool.
+func openPool(d driver.Driver, cfg PoolConfig, common []Option) (*sql.DB, error) {
+	cfg.Options = append(append([]Option{}, common...), cfg.Options...)
+
+	return OpenDB(d, cfg)
+}
+
+// OpenDB opens the pool described by cfg, with a new Driver for the inner
+// driver d. As with OpenConnector, the provider is exercised right away, so
+// that configuration errors surface here. Use DriverOf to get at the Driver.
+func OpenDB(d driver.Driver, cfg PoolConfig) (*sql.DB, error) {
+	if cfg.Provider == nil {
+		return nil, errors.New("lazydsn: pool needs a provider")
+	}
+
+	connector, err := New(d, cfg.Provider, cfg.Options...).OpenConnector(cfg.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(connector)
+	configurePool(db, cfg)
+	warmUp(db, cfg)
+
+	return db, nil
+}
+
+// configurePool applies the pool settings in cfg to db.
+func configurePool(db *sql.DB, cfg PoolConfig) {
+	if cfg.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+
+	if cfg.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+
+	if cfg.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
+
+// warmUp opens the connections that cfg asks for in db, and leaves them idle.
+func warmUp(db *sql.DB, cfg PoolConfig) {
+	n := cfg.Warmup
+
+	switch idle := cfg.MaxIdleConns; {
+	case idle == 0:
+		n = min(n, defaultMaxIdleConns)
+	case idle > 0:
+		n = min(n, idle)
+	default:
+		return
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		n = min(n, cfg.MaxOpenConns)
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	timeout := cfg.WarmupTimeout
+
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The first connection resolves the DSN; there's no point in having
+	// the rest race to the provider.
+	first, err := db.Conn(ctx)
+
+	if err != nil {
+		return
+	}
+
+	parallelism := cfg.WarmupParallelism
+
+	if parallelism <= 0 {
+		parallelism = defaultWarmupParallelism
+	}
+
+	conns := make([]*sql.Conn, n)
+	conns[0] = first
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Failures are left for the pool to deal with later.
+			conns[i], _ = db.Conn(ctx)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// Returning the connections only once all are open keeps the pool from
+	// handing the same one out twice.
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+// Writer returns the pool for the writer.
+func (c *Cluster) Writer() *sql.DB {
+	return c.writer
+}
+
+// Reader returns the pool for the reader, which is the writer's if there's
+// no reader configured.
+func (c *Cluster) Reader() *sql.DB {
+	return c.reader
+}
+
+// Close closes both pools.
+func (c *Cluster) Close() error {
+	err := c.writer.Close()
+
+	if c.reader != c.writer {
+		err = errors.Join(err, c.reader.Close())
+	}
+
+	return err
+}
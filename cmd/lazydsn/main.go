@@ -0,0 +1,337 @@
+/*
+Command lazydsn resolves a master DSN the way an application using lazydsn
+would, to validate a rotation setup from a shell before deploying:
+
+	lazydsn [flags] <master DSN>
+
+The inner DSN is printed redacted, along with the metadata reported by the
+provider. With -connect, a test connection is opened too. Providers:
+
+	env     the master DSN names an environment variable holding the DSN
+	file    the master DSN is the path of a file holding the DSN
+	awssm   the master DSN is the ARN (or name) of an AWS Secrets Manager
+	        secret; see -format, and the AWS SDK for how credentials and
+	        region are picked up
+
+The doctor subcommand checks pool settings against the rotation interval
+instead, without resolving anything:
+
+	lazydsn doctor -lifetime 1h -max-age 20m
+
+The exit status is 0 if everything checked out, 1 if something failed (or the
+doctor found problems), and 2 for usage errors.
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/awssm"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// drivers are the inner drivers available for test connections.
+var drivers = map[string]driver.Driver{
+	"mysql":    &mysql.MySQLDriver{},
+	"postgres": &pq.Driver{},
+}
+
+// formats are the DSN formats for secrets in AWS Secrets Manager.
+var formats = map[string]awssm.Formatter{
+	"raw":      awssm.Raw,
+	"mysql":    awssm.MySQL,
+	"postgres": awssm.PostgreSQL,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is main, minus the process. It returns the exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "doctor" {
+		return doctor(args[1:], stdout, stderr)
+	}
+
+	flags := flag.NewFlagSet("lazydsn", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	var (
+		provider = flags.String("provider", "env", "provider to resolve the DSN with: env, file or awssm")
+		format   = flags.String("format", "raw", "DSN format for awssm secrets: raw, mysql or postgres")
+		version  = flags.String("version", "", "secret version to resolve, for providers that keep versions")
+		connect  = flags.String("connect", "", "open a test connection with the given driver: mysql or postgres")
+		maxAge   = flags.Duration("max-age", 0, "rotation interval to check the credential's age against")
+		timeout  = flags.Duration("timeout", 30*time.Second, "time limit for the whole check")
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: lazydsn [flags] <master DSN>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	p, err := newProvider(ctx, *provider, *format)
+
+	if err != nil {
+		fmt.Fprintln(stderr, "lazydsn:", err)
+		return 2
+	}
+
+	var inner driver.Driver
+
+	if *connect != "" {
+		if inner = drivers[*connect]; inner == nil {
+			fmt.Fprintf(stderr, "lazydsn: unknown driver %q\n", *connect)
+			return 2
+		}
+	}
+
+	master := flags.Arg(0)
+	info, err := resolve(ctx, p, master, *version)
+
+	if err != nil {
+		fmt.Fprintln(stderr, "lazydsn: resolving:", err)
+		return 1
+	}
+
+	report(stdout, info, *maxAge)
+
+	if inner == nil {
+		return 0
+	}
+
+	if err := ping(ctx, inner, p, master, *version); err != nil {
+		fmt.Fprintln(stderr, "lazydsn: connecting:", err)
+		return 1
+	}
+
+	fmt.Fprintln(stdout, "connect:  ok")
+
+	return 0
+}
+
+// doctor runs the doctor subcommand.
+func doctor(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lazydsn doctor", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	var (
+		lifetime = flags.Duration("lifetime", 0, "the pool's ConnMaxLifetime")
+		idleTime = flags.Duration("idle-time", 0, "the pool's ConnMaxIdleTime")
+		maxAge   = flags.Duration("max-age", 0, "rotation interval of the credentials")
+	)
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: lazydsn doctor [flags]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	findings := lazydsn.Diagnose(lazydsn.PoolConfig{
+		ConnMaxLifetime: *lifetime,
+		ConnMaxIdleTime: *idleTime,
+	}, lazydsn.RotationPolicy{
+		MaxAge: *maxAge,
+	})
+
+	if len(findings) == 0 {
+		fmt.Fprintln(stdout, "no problems found")
+		return 0
+	}
+
+	for _, f := range findings {
+		fmt.Fprintln(stdout, f)
+	}
+
+	return 1
+}
+
+// newProvider creates the provider with the given name.
+func newProvider(ctx context.Context, name, format string) (lazydsn.DSNProvider, error) {
+	switch name {
+	case "env":
+		return lazydsn.DSNProviderFunc(func(dsn string) (string, error) {
+			if v, ok := os.LookupEnv(dsn); ok {
+				return v, nil
+			}
+
+			return "", fmt.Errorf("environment variable %s not set", dsn)
+		}), nil
+	case "file":
+		return lazydsn.DSNProviderFunc(func(dsn string) (string, error) {
+			b, err := os.ReadFile(dsn)
+
+			if err != nil {
+				return "", err
+			}
+
+			return strings.TrimSpace(string(b)), nil
+		}), nil
+	case "awssm":
+		f, ok := formats[format]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown format %q", format)
+		}
+
+		cfg, err := config.LoadDefaultConfig(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return awssm.New(secretsmanager.NewFromConfig(cfg), f), nil
+	}
+
+	return nil, fmt.Errorf("unknown provider %q", name)
+}
+
+// resolve fetches the inner DSN for master through the richest interface
+// that p supports.
+func resolve(ctx context.Context, p lazydsn.DSNProvider, master, version string) (lazydsn.DSNInfo, error) {
+	if vp, ok := p.(lazydsn.VersionedDSNProvider); ok {
+		return vp.FetchDSNVersion(ctx, master, version)
+	}
+
+	if version != "" {
+		return lazydsn.DSNInfo{}, lazydsn.ErrNotVersioned
+	}
+
+	if ip, ok := p.(lazydsn.InfoDSNProvider); ok {
+		return ip.FetchDSNInfo(ctx, master)
+	}
+
+	var (
+		info lazydsn.DSNInfo
+		err  error
+	)
+
+	if fp, ok := p.(lazydsn.FullDSNProvider); ok {
+		info.DSN, err = fp.FetchDSNWithContext(ctx, master)
+	} else {
+		info.DSN, err = p.FetchDSN(master)
+	}
+
+	return info, err
+}
+
+// report prints what's known about info, without revealing any secrets.
+func report(w io.Writer, info lazydsn.DSNInfo, maxAge time.Duration) {
+	view := lazydsn.ParseDSN(info.DSN)
+	format := string(view.Format)
+
+	if format == "" {
+		format = "unknown"
+	}
+
+	fmt.Fprintln(w, "dsn:     ", view)
+	fmt.Fprintln(w, "format:  ", format)
+
+	if view.TLS != "" {
+		fmt.Fprintln(w, "tls:     ", view.TLS)
+	}
+
+	keys := make([]string, 0, len(view.Params))
+
+	for k := range view.Params {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "param:    %s=%s\n", k, view.Params[k])
+	}
+
+	if info.Version != "" {
+		fmt.Fprintln(w, "version: ", info.Version)
+	}
+
+	if len(info.Fallbacks) > 0 {
+		fmt.Fprintln(w, "fallback:", strings.Join(info.Fallbacks, ", "))
+	}
+
+	if len(info.Revoked) > 0 {
+		fmt.Fprintln(w, "revoked: ", strings.Join(info.Revoked, ", "))
+	}
+
+	if info.Issued.IsZero() {
+		return
+	}
+
+	age := time.Since(info.Issued).Round(time.Second)
+	fmt.Fprintf(w, "issued:   %s (%v ago)\n", info.Issued.Format(time.RFC3339), age)
+
+	if maxAge > 0 {
+		if left := maxAge - age; left > 0 {
+			fmt.Fprintf(w, "ttl:      %v left\n", left)
+		} else {
+			fmt.Fprintf(w, "ttl:      expired %v ago; rotation may be broken\n", -left)
+		}
+	}
+}
+
+// ping opens a connection through lazydsn, just like an application would,
+// and pings the database. Errors are already redacted by the driver.
+func ping(ctx context.Context, inner driver.Driver, p lazydsn.DSNProvider, master, version string) error {
+	d := lazydsn.New(inner, p)
+
+	if version != "" {
+		if err := d.Pin(version); err != nil {
+			return err
+		}
+	}
+
+	connector, err := d.OpenConnector(master)
+
+	if err != nil {
+		return err
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("timed out: %w", err)
+		}
+
+		return err
+	}
+
+	return nil
+}
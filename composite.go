@@ -0,0 +1,156 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Source provides the value for a placeholder in a CompositeProvider
+// template. Provider is given DSN (not the master DSN) to resolve it; e.g.,
+// the path of a secret, or the name of a service to discover. Values are
+// reused for Refresh, or fetched every time if zero, and go through Escape,
+// if set, before they're put in place; e.g., url.QueryEscape for values that
+// end up in a URL's query.
+type Source struct {
+	Provider DSNProvider
+	DSN      string
+	Refresh  time.Duration
+	Escape   func(string) string
+}
+
+// CompositeProvider assembles DSNs out of values from several providers, each
+// with its own refresh cadence; e.g., the password from a secrets manager,
+// every time, the host from DNS discovery, every minute, and parameters from
+// a configuration file, every hour. The master DSN is a template, where every
+// "{name}" is replaced by the value of the source with that name:
+//
+//	p := lazydsn.NewCompositeProvider(map[string]lazydsn.Source{
+//		"password": {
+//			Provider: vault,
+//			DSN:      "database/creds/app",
+//			Escape:   url.PathEscape,
+//		},
+//		"host": {
+//			Provider: discovery,
+//			DSN:      "_postgres._tcp.db",
+//			Refresh:  time.Minute,
+//		},
+//	})
+//
+//	db, err := sql.Open("lazydsn:pgx", "postgres://app:{password}@{host}/app")
+//
+// Only the sources named in the template are fetched, concurrently. Braces
+// not naming a source are left alone, as some DSN formats use them for
+// quoting. If any source fails, so does the whole DSN; values still fresh are
+// kept for next time, though.
+type CompositeProvider struct {
+	sources map[string]*compositeSource
+}
+
+// compositeSource is a source, along with its latest value.
+type compositeSource struct {
+	Source
+	placeholder string
+
+	mu      sync.Mutex
+	value   string
+	fetched time.Time
+}
+
+// NewCompositeProvider creates a provider that fills in templates with the
+// values from sources, keyed by name.
+func NewCompositeProvider(sources map[string]Source) *CompositeProvider {
+	p := &CompositeProvider{
+		sources: make(map[string]*compositeSource, len(sources)),
+	}
+
+	for name, src := range sources {
+		p.sources[name] = &compositeSource{
+			Source:      src,
+			placeholder: "{" + name + "}",
+		}
+	}
+
+	return p
+}
+
+// FetchDSN implements the DSNProvider interface.
+func (p *CompositeProvider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext fills in the template in dsn.
+func (p *CompositeProvider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	var used []*compositeSource
+
+	for _, src := range p.sources {
+		if strings.Contains(dsn, src.placeholder) {
+			used = append(used, src)
+		}
+	}
+
+	if len(used) == 0 {
+		return dsn, nil
+	}
+
+	pairs := make([]string, 2*len(used))
+	errs := make([]error, len(used))
+	var wg sync.WaitGroup
+
+	for i, src := range used {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			pairs[2*i] = src.placeholder
+			pairs[2*i+1], errs[i] = src.get(ctx)
+		}()
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+
+	return strings.NewReplacer(pairs...).Replace(dsn), nil
+}
+
+// get returns the value of the source, fetching it if it's not fresh enough.
+// Concurrent callers wait for the same fetch.
+func (s *compositeSource) get(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fetched.IsZero() && time.Since(s.fetched) < s.Refresh {
+		return s.value, nil
+	}
+
+	var value string
+	var err error
+
+	if full, ok := s.Provider.(FullDSNProvider); ok {
+		value, err = full.FetchDSNWithContext(ctx, s.DSN)
+	} else {
+		value, err = s.Provider.FetchDSN(s.DSN)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("lazydsn: fetching %s: %w", s.placeholder, err)
+	}
+
+	if s.Escape != nil {
+		value = s.Escape(value)
+	}
+
+	s.value, s.fetched = value, time.Now()
+
+	return value, nil
+}
+
+// CompositeProvider implements the FullDSNProvider interface.
+var _ FullDSNProvider = &CompositeProvider{}
@@ -0,0 +1,300 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+)
+
+// conn wraps connections from the inner driver, for features that need to
+// step into their lifecycle; see connHooks. It implements every optional
+// interface that database/sql looks for on connections, delegating to the
+// inner connection when it implements them too, and otherwise doing what
+// database/sql would have done itself. That way, wrapping never degrades the
+// inner driver into database/sql's slower (or less capable) paths. Statements
+// aren't wrapped, so whatever they implement (e.g., driver.ColumnConverter)
+// is seen by database/sql as is.
+type conn struct {
+	driver.Conn
+	hooks  []connHooks
+	closed atomic.Bool
+}
+
+// connHooks are the points where features can step into the lifecycle of a
+// connection. Every hook is optional.
+type connHooks struct {
+	// onClose is called once, before the inner connection is closed.
+	onClose func()
+
+	// valid is checked after the inner connection's IsValid, when
+	// database/sql is about to return the connection to the pool. Invalid
+	// connections are closed instead.
+	valid func() bool
+
+	// reset runs after the inner connection's ResetSession succeeds, when
+	// database/sql is about to reuse the connection. Returning
+	// driver.ErrBadConn makes database/sql discard it.
+	reset func(ctx context.Context) error
+
+	// use runs before every operation given a context (preparing,
+	// executing, querying, beginning transactions and pinging), with that
+	// context. Returning driver.ErrBadConn makes database/sql discard the
+	// connection, and retry the operation with another one.
+	use func(ctx context.Context) error
+}
+
+// wrapConn wraps c with hooks. Wrapping a connection that's wrapped already
+// adds the hooks to the existing wrapper, instead of piling up another layer;
+// hooks are run in the order they were added.
+func wrapConn(c driver.Conn, hooks connHooks) *conn {
+	if wc, ok := c.(*conn); ok {
+		wc.hooks = append(wc.hooks, hooks)
+		return wc
+	}
+
+	return &conn{
+		Conn:  c,
+		hooks: []connHooks{hooks},
+	}
+}
+
+// Unwrap returns the inner driver's connection behind driverConn, if it's one
+// wrapped by a Driver, or driverConn itself otherwise. That's meant for
+// sql.Conn.Raw, which hands out the connection as the driver returned it, for
+// applications that need to reach driver specific features:
+//
+//	err := conn.Raw(func(driverConn any) error {
+//		pgxConn := lazydsn.Unwrap(driverConn).(*stdlib.Conn).Conn()
+//		...
+//	})
+func Unwrap(driverConn any) any {
+	if c, ok := driverConn.(*conn); ok {
+		return c.Conn
+	}
+
+	return driverConn
+}
+
+// use runs the use hooks for an operation given ctx.
+func (c *conn) use(ctx context.Context) error {
+	for _, h := range c.hooks {
+		if h.use != nil {
+			if err := h.use(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close closes the inner connection, after running the onClose hooks.
+func (c *conn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		for _, h := range c.hooks {
+			if h.onClose != nil {
+				h.onClose()
+			}
+		}
+	}
+
+	return c.Conn.Close()
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return pc.PrepareContext(ctx, query)
+	}
+
+	stmt, err := c.Conn.Prepare(query)
+
+	if err == nil {
+		select {
+		case <-ctx.Done():
+			stmt.Close()
+			return nil, ctx.Err()
+		default:
+		}
+	}
+
+	return stmt, err
+}
+
+// BeginTx implements driver.ConnBeginTx.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	if bt, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return bt.BeginTx(ctx, opts)
+	}
+
+	// Same restrictions that database/sql applies to drivers that don't
+	// support options.
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return nil, errors.New("sql: driver does not support non-default isolation level")
+	}
+
+	if opts.ReadOnly {
+		return nil, errors.New("sql: driver does not support read-only transactions")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	//lint:ignore SA1019 fallback for drivers without BeginTx
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	if ec, ok := c.Conn.(driver.ExecerContext); ok {
+		return ec.ExecContext(ctx, query, args)
+	}
+
+	//lint:ignore SA1019 fallback for drivers without ExecContext
+	if e, ok := c.Conn.(driver.Execer); ok { //nolint:staticcheck
+		values, err := namedValuesToValues(args)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return e.Exec(query, values)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.use(ctx); err != nil {
+		return nil, err
+	}
+
+	if qc, ok := c.Conn.(driver.QueryerContext); ok {
+		return qc.QueryContext(ctx, query, args)
+	}
+
+	//lint:ignore SA1019 fallback for drivers without QueryContext
+	if q, ok := c.Conn.(driver.Queryer); ok { //nolint:staticcheck
+		values, err := namedValuesToValues(args)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return q.Query(query, values)
+	}
+
+	return nil, driver.ErrSkip
+}
+
+// Ping implements driver.Pinger.
+func (c *conn) Ping(ctx context.Context) error {
+	if err := c.use(ctx); err != nil {
+		return err
+	}
+
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter, running the reset hooks
+// once the inner connection is done.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		if err := sr.ResetSession(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range c.hooks {
+		if h.reset != nil {
+			if err := h.reset(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// IsValid implements driver.Validator, checking the valid hooks once the
+// inner connection is done.
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok && !v.IsValid() {
+		return false
+	}
+
+	for _, h := range c.hooks {
+		if h.valid != nil && !h.valid() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckNamedValue implements driver.NamedValueChecker. Returning
+// driver.ErrSkip makes database/sql go on with the statement's checks, or its
+// default ones, just as if the connection didn't implement this interface.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+// namedValuesToValues converts arguments for drivers that only support
+// positional ones.
+func namedValuesToValues(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errors.New("sql: driver does not support the use of Named Parameters")
+		}
+
+		values[i] = nv.Value
+	}
+
+	return values, nil
+}
+
+// conn implements all optional interfaces that database/sql checks for.
+var (
+	_ driver.ConnPrepareContext = &conn{}
+	_ driver.ConnBeginTx        = &conn{}
+	_ driver.ExecerContext      = &conn{}
+	_ driver.QueryerContext     = &conn{}
+	_ driver.Pinger             = &conn{}
+	_ driver.SessionResetter    = &conn{}
+	_ driver.Validator          = &conn{}
+	_ driver.NamedValueChecker  = &conn{}
+)
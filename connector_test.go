@@ -0,0 +1,181 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// contextDriver adds driver.DriverContext to a lazydsntest.Driver, so that
+// providers are exercised when connectors are opened. It records the DSNs of
+// the connectors closed.
+type contextDriver struct {
+	*lazydsntest.Driver
+
+	mu     sync.Mutex
+	closed []string
+}
+
+func newContextDriver() *contextDriver {
+	return &contextDriver{
+		Driver: lazydsntest.NewDriver(),
+	}
+}
+
+func (d *contextDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return dsnConnector{dsn, d}, nil
+}
+
+// closedDSNs returns the DSNs of the connectors closed, sorted.
+func (d *contextDriver) closedDSNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Sorted(slices.Values(d.closed))
+}
+
+type dsnConnector struct {
+	dsn string
+	d   *contextDriver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.d }
+
+func (c dsnConnector) Close() error {
+	c.d.mu.Lock()
+	c.d.closed = append(c.d.closed, c.dsn)
+	c.d.mu.Unlock()
+
+	return nil
+}
+
+// TestLazyConnectorClosed checks that connecting fails once the connector is
+// closed, whether it was ever used or not.
+func TestLazyConnectorClosed(t *testing.T) {
+	for _, used := range []bool{true, false} {
+		c := lazydsn.NewConnector(lazydsntest.NewDriver(), "master", lazydsntest.NewProvider("db"))
+
+		if used {
+			conn, err := c.Connect(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn.Close()
+		}
+
+		if err := c.(io.Closer).Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := c.Connect(context.Background()); !errors.Is(err, lazydsn.ErrConnectorClosed) {
+			t.Errorf("used %v: got %v, want %v", used, err, lazydsn.ErrConnectorClosed)
+		}
+	}
+}
+
+// TestLazyConnectorContext checks that connections waiting for the connector
+// to be opened give up once their context is done, and that the connector is
+// opened only once meanwhile, for later connections to use.
+func TestLazyConnectorContext(t *testing.T) {
+	p := &hungProvider{
+		release: make(chan struct{}),
+	}
+
+	c := lazydsn.NewConnector(newContextDriver(), "master", p)
+
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := c.Connect(ctx)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+
+	close(p.release)
+	conn, err := c.Connect(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	// One fetch opened the connector, and the other one the connection.
+	if n := p.fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+// TestLazyConnectorCloseOpening checks that a connector still being opened
+// when closed isn't used afterwards.
+func TestLazyConnectorCloseOpening(t *testing.T) {
+	p := &hungProvider{
+		release: make(chan struct{}),
+	}
+
+	c := lazydsn.NewConnector(newContextDriver(), "master", p)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Connect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	close(p.release)
+
+	if _, err := c.Connect(context.Background()); !errors.Is(err, lazydsn.ErrConnectorClosed) {
+		t.Errorf("got %v, want %v", err, lazydsn.ErrConnectorClosed)
+	}
+}
+
+// TestConnectorsClosed checks that the inner driver's connectors are closed
+// once their DSN is no longer resolved, and along with the database.
+func TestConnectorsClosed(t *testing.T) {
+	inner := newContextDriver()
+	p := lazydsntest.NewProvider("old")
+	c, err := lazydsn.New(inner, p).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dsn := range []string{"old", "new", "new"} {
+		p.Set(dsn)
+		conn, err := c.Connect(context.Background())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Close()
+	}
+
+	if got, want := inner.closedDSNs(), []string{"old"}; !slices.Equal(got, want) {
+		t.Errorf("got %v closed after rotating, want %v", got, want)
+	}
+
+	if err := c.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := inner.closedDSNs(), []string{"new", "old"}; !slices.Equal(got, want) {
+		t.Errorf("got %v closed along with the database, want %v", got, want)
+	}
+}
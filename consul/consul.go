@@ -0,0 +1,122 @@
+/*
+Package consul implements a lazydsn provider that discovers database instances
+in the Consul catalog, every time a connection is opened. Only instances whose
+health checks are all passing are considered. Credentials come from another
+provider (e.g., Consul-managed credentials served by Vault), whose DSN is
+pointed to the instances found:
+
+	client, err := api.NewClient(api.DefaultConfig())
+	...
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(),
+		consul.New(client.Health(), creds, "postgres", consul.WithTag("replica")),
+		lazydsn.WithBalancing(lazydsn.LeastOpen),
+	)
+
+Every instance becomes a candidate DSN (see lazydsn.DiscoveryProvider), in the
+order returned by Consul, so the driver can fail over or balance connections
+among them. Use WithQueryOptions to have Consul sort instances by round trip
+time (e.g., with Near set to "_agent"), or to query another datacenter.
+*/
+package consul
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/hashicorp/consul/api"
+)
+
+// Client is the subset of the Consul health API used by the locator.
+// *api.Health implements it.
+type Client interface {
+	Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
+// Locator finds healthy instances of a service in the Consul catalog. It
+// implements lazydsn.Locator.
+type Locator struct {
+	client  Client
+	service string
+	tag     string
+	query   api.QueryOptions
+}
+
+// An Option configures optional behavior for a Locator.
+type Option func(*Locator)
+
+// WithTag only considers instances that have the given tag.
+func WithTag(tag string) Option {
+	return func(l *Locator) {
+		l.tag = tag
+	}
+}
+
+// WithQueryOptions sets the options for every query to Consul. The context
+// in q, if any, is replaced by the one for each fetch.
+func WithQueryOptions(q api.QueryOptions) Option {
+	return func(l *Locator) {
+		l.query = q
+	}
+}
+
+// NewLocator creates a locator for the given service.
+func NewLocator(client Client, service string, opts ...Option) *Locator {
+	l := &Locator{
+		client:  client,
+		service: service,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// New creates a provider that finds instances of service with client, and
+// fetches credentials from creds. See lazydsn.NewDiscoveryProvider.
+func New(client Client, creds lazydsn.DSNProvider, service string, opts ...Option) *lazydsn.DiscoveryProvider {
+	return lazydsn.NewDiscoveryProvider(creds, NewLocator(client, service, opts...))
+}
+
+// Locate returns the addresses of the healthy instances of the service. The
+// service address is used when set, and the node's otherwise, as Consul
+// itself does.
+func (l *Locator) Locate(ctx context.Context) ([]string, error) {
+	entries, _, err := l.client.Service(l.service, l.tag, true, l.query.WithContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+
+		host := entry.Service.Address
+
+		if host == "" && entry.Node != nil {
+			host = entry.Node.Address
+		}
+
+		if host == "" {
+			continue
+		}
+
+		if entry.Service.Port == 0 {
+			addrs = append(addrs, host)
+		} else {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+		}
+	}
+
+	return addrs, nil
+}
+
+// Locator implements the lazydsn.Locator interface.
+var _ lazydsn.Locator = &Locator{}
@@ -0,0 +1,119 @@
+package consul_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/gkristic/lazydsn/consul"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeHealth answers service queries from fixed entries, recording the
+// arguments of the last one.
+type fakeHealth struct {
+	entries     []*api.ServiceEntry
+	err         error
+	service     string
+	tag         string
+	passingOnly bool
+	query       *api.QueryOptions
+}
+
+func (h *fakeHealth) Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	h.service, h.tag, h.passingOnly, h.query = service, tag, passingOnly, q
+
+	return h.entries, nil, h.err
+}
+
+// TestLocate checks the addresses found for catalog entries.
+func TestLocate(t *testing.T) {
+	refused := errors.New("refused")
+
+	tests := []struct {
+		name    string
+		entries []*api.ServiceEntry
+		err     error
+		want    []string
+	}{
+		{
+			name: "service address",
+			entries: []*api.ServiceEntry{
+				{Node: &api.Node{Address: "10.0.0.1"}, Service: &api.AgentService{Address: "10.0.1.1", Port: 5432}},
+			},
+			want: []string{"10.0.1.1:5432"},
+		},
+		{
+			name: "node address",
+			entries: []*api.ServiceEntry{
+				{Node: &api.Node{Address: "10.0.0.1"}, Service: &api.AgentService{Port: 5432}},
+			},
+			want: []string{"10.0.0.1:5432"},
+		},
+		{
+			name: "no port",
+			entries: []*api.ServiceEntry{
+				{Service: &api.AgentService{Address: "db.internal"}},
+			},
+			want: []string{"db.internal"},
+		},
+		{
+			name: "no address",
+			entries: []*api.ServiceEntry{
+				{Service: &api.AgentService{Port: 5432}},
+				{Node: &api.Node{Address: "10.0.0.2"}},
+			},
+			want: []string{},
+		},
+		{
+			name: "empty",
+			want: []string{},
+		},
+		{
+			name: "error",
+			err:  refused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeHealth{entries: tt.entries, err: tt.err}
+			got, err := consul.NewLocator(client, "postgres").Locate(context.Background())
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestQuery checks that only passing instances with the tag are queried, and
+// that the query options carry the context of the fetch.
+func TestQuery(t *testing.T) {
+	type key struct{}
+
+	client := &fakeHealth{}
+	l := consul.NewLocator(client, "postgres",
+		consul.WithTag("replica"),
+		consul.WithQueryOptions(api.QueryOptions{Datacenter: "dc2", Near: "_agent"}),
+	)
+
+	ctx := context.WithValue(context.Background(), key{}, "fetch")
+
+	if _, err := l.Locate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.service != "postgres" || client.tag != "replica" || !client.passingOnly {
+		t.Errorf("got service %q, tag %q and passing only %v", client.service, client.tag, client.passingOnly)
+	}
+
+	if q := client.query; q.Datacenter != "dc2" || q.Near != "_agent" || q.Context().Value(key{}) != "fetch" {
+		t.Errorf("got query options %+v, want those configured with the fetch context", q)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Package databricks implements a lazydsn provider for Databricks SQL
+warehouses, as reached with github.com/databricks/databricks-sql-go. Tokens
+for warehouses expire (OAuth tokens within the hour, personal access tokens
+whenever their lifetime is up, or they're rotated), and the driver only takes
+them in the DSN; this package keeps the DSN up to date with a fresh token,
+instead of having the pool restarted with a new one. The master DSN is the
+usual DSN without the token:
+
+	tokens := databricks.M2M("adb-1234.5.azuredatabricks.net", clientID, clientSecret)
+	lazydsn.Register("lazydsn:databricks", &dbsql.DatabricksDriver{},
+		databricks.New(tokens),
+		lazydsn.WithRevocationGrace(time.Minute),
+	)
+
+	db, err := sql.Open("lazydsn:databricks",
+		"adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123?catalog=main")
+
+Tokens come from an oauth2.TokenSource, and are reused until a few minutes
+before they expire (see WithEarlyRefresh). OAuth machine-to-machine tokens
+for a service principal are available with M2M. Personal access tokens kept
+in a secret store can be handed over by any other token source; those should
+report an expiry as well, even if only to have the store checked again.
+
+The Databricks driver sends the token with every request, so connections
+opened with a token stop working once it expires, and not just new ones.
+Combine the provider with lazydsn.WithRevocationGrace, with a grace period
+shorter than the early refresh, to have those connections replaced in time.
+*/
+package databricks
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// defaultEarlyRefresh is how long before they expire that tokens are
+// refreshed by default.
+const defaultEarlyRefresh = 5 * time.Minute
+
+// Provider injects tokens into Databricks DSNs. It implements
+// lazydsn.InfoDSNProvider.
+type Provider struct {
+	src   oauth2.TokenSource
+	early time.Duration
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithEarlyRefresh sets how long before they expire that tokens are
+// refreshed, instead of 5 minutes.
+func WithEarlyRefresh(d time.Duration) Option {
+	return func(p *Provider) {
+		p.early = d
+	}
+}
+
+// New creates a provider that takes tokens from src.
+func New(src oauth2.TokenSource, opts ...Option) *Provider {
+	p := &Provider{
+		early: defaultEarlyRefresh,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.src = oauth2.ReuseTokenSourceWithExpiry(nil, src, p.early)
+
+	return p
+}
+
+// M2M returns a token source for OAuth machine-to-machine authentication with
+// the workspace at host, as the service principal with the given client ID
+// and secret.
+func M2M(host, clientID, clientSecret string) oauth2.TokenSource {
+	cfg := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     (&url.URL{Scheme: "https", Host: host, Path: "/oidc/v1/token"}).String(),
+		Scopes:       []string{"all-apis"},
+	}
+
+	return cfg.TokenSource(context.Background())
+}
+
+// FetchDSN implements the lazydsn.DSNProvider interface.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the lazydsn.FullDSNProvider interface.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo returns dsn with the current token, replacing any token in dsn
+// already. Token sources take no context, so ctx is only checked before
+// getting the token.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	tok, err := p.src.Token()
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	if tok.AccessToken == "" {
+		return lazydsn.DSNInfo{}, errors.New("databricks: empty token")
+	}
+
+	// Credentials, if any, come before the host; i.e., before the first
+	// slash, which starts the HTTP path.
+	rest := dsn
+	end := strings.IndexByte(dsn, '/')
+
+	if end < 0 {
+		end = len(dsn)
+	}
+
+	if i := strings.LastIndexByte(dsn[:end], '@'); i >= 0 {
+		rest = dsn[i+1:]
+	}
+
+	return lazydsn.DSNInfo{
+		DSN: "token:" + tok.AccessToken + "@" + rest,
+	}, nil
+}
+
+// Provider implements the lazydsn.InfoDSNProvider and lazydsn.FullDSNProvider
+// interfaces.
+var (
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.FullDSNProvider = &Provider{}
+)
@@ -0,0 +1,126 @@
+package databricks_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn/databricks"
+	"golang.org/x/oauth2"
+)
+
+// tokenSource hands out numbered tokens, expiring after ttl.
+type tokenSource struct {
+	ttl    time.Duration
+	tokens int
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.tokens++
+
+	return &oauth2.Token{
+		AccessToken: "dapi" + strconv.Itoa(s.tokens),
+		Expiry:      time.Now().Add(s.ttl),
+	}, nil
+}
+
+// TestFetch checks that the token is injected into DSNs, replacing any there
+// already.
+func TestFetch(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{
+			"adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123?catalog=main",
+			"token:dapi1@adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123?catalog=main",
+		},
+		{
+			"token:stale@adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123",
+			"token:dapi1@adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123",
+		},
+		{
+			"adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123?user=a@b",
+			"token:dapi1@adb-1234.5.azuredatabricks.net:443/sql/1.0/warehouses/abc123?user=a@b",
+		},
+		{
+			"adb-1234.5.azuredatabricks.net",
+			"token:dapi1@adb-1234.5.azuredatabricks.net",
+		},
+	}
+
+	for _, tt := range tests {
+		p := databricks.New(&tokenSource{ttl: time.Hour})
+		got, err := p.FetchDSN(tt.dsn)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("got %q for %q, want %q", got, tt.dsn, tt.want)
+		}
+	}
+}
+
+// TestEarlyRefresh checks that tokens are reused until they're about to
+// expire.
+func TestEarlyRefresh(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		early time.Duration
+		want  int
+	}{
+		{"reused", time.Hour, time.Minute, 1},
+		{"about to expire", 2 * time.Minute, 5 * time.Minute, 3},
+		{"default early refresh", 4 * time.Minute, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &tokenSource{ttl: tt.ttl}
+			var opts []databricks.Option
+
+			if tt.early > 0 {
+				opts = append(opts, databricks.WithEarlyRefresh(tt.early))
+			}
+
+			p := databricks.New(src, opts...)
+
+			for range 3 {
+				if _, err := p.FetchDSN("adb-1234.5.azuredatabricks.net:443/sql"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if src.tokens != tt.want {
+				t.Errorf("got %d tokens, want %d", src.tokens, tt.want)
+			}
+		})
+	}
+}
+
+// TestFetchErrors checks that empty tokens and canceled fetches are errors.
+func TestFetchErrors(t *testing.T) {
+	empty := oauth2.StaticTokenSource(&oauth2.Token{})
+
+	if _, err := databricks.New(empty).FetchDSN("adb-1234.5.azuredatabricks.net"); err == nil {
+		t.Error("got no error for an empty token")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	src := &tokenSource{ttl: time.Hour}
+
+	if _, err := databricks.New(src).FetchDSNInfo(ctx, "adb-1234.5.azuredatabricks.net"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	if src.tokens != 0 {
+		t.Errorf("got %d tokens for a canceled fetch, want none", src.tokens)
+	}
+}
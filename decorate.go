@@ -0,0 +1,170 @@
+package lazydsn
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// A Decorator rewrites DSNs freshly returned by the provider, before they're
+// used; e.g., to add or enforce parameters such as timeouts, TLS modes or
+// character sets. It's meant for platform teams to have settings applied
+// uniformly, no matter what providers return, instead of relying on each of
+// them to remember. Decorators run in order, after the provider's response is
+// verified (see WithVerifier), and before the TLS configuration is applied
+// (see WithTLS) and the DSN policy is checked (see WithDSNPolicy), so policies
+// see decorated DSNs. An error fails the connection attempt, as a provider
+// error would.
+type Decorator interface {
+	Decorate(DSNInfo) (DSNInfo, error)
+}
+
+// DecoratorFunc allows using a plain function as a Decorator.
+type DecoratorFunc func(DSNInfo) (DSNInfo, error)
+
+// Decorate exercises the original function.
+func (f DecoratorFunc) Decorate(info DSNInfo) (DSNInfo, error) {
+	return f(info)
+}
+
+// EnforceParams returns a decorator that sets params in every DSN, replacing
+// the values that providers set, if any. The DSN formats supported are those
+// known to ParseDSN; see SetParams.
+func EnforceParams(params map[string]string) Decorator {
+	return paramsDecorator{params: params, override: true}
+}
+
+// DefaultParams returns a decorator that sets params in every DSN that
+// doesn't set them already. The DSN formats supported are those known to
+// ParseDSN; see SetParams.
+func DefaultParams(params map[string]string) Decorator {
+	return paramsDecorator{params: params}
+}
+
+// paramsDecorator sets parameters in DSNs.
+type paramsDecorator struct {
+	params   map[string]string
+	override bool
+}
+
+// Decorate implements the Decorator interface.
+func (p paramsDecorator) Decorate(info DSNInfo) (DSNInfo, error) {
+	var err error
+	info.DSN, err = SetParams(info.DSN, p.params, p.override)
+
+	return info, err
+}
+
+// SetParams returns dsn with params set, in place of those in dsn already if
+// override is true, and only where missing otherwise. Parameters go in the
+// query for URL and MySQL DSNs, and as keys for keyword/value and ODBC DSNs;
+// keys are compared case insensitively for the latter, as they're case
+// insensitive for most drivers. Everything else in dsn is preserved, although
+// keyword/value and ODBC DSNs are rebuilt, and thus normalized, in the
+// process.
+func SetParams(dsn string, params map[string]string, override bool) (string, error) {
+	if len(params) == 0 {
+		return dsn, nil
+	}
+
+	switch {
+	case urlScheme.MatchString(dsn):
+		return setQueryParams(dsn, params, override), nil
+	case kvKey.MatchString(dsn):
+		if isODBC(dsn) {
+			return joinODBC(setPairsParams(splitODBC(dsn), params, override)), nil
+		}
+
+		return joinKeyValue(setPairsParams(splitKeyValue(dsn), params, override)), nil
+	case strings.Contains(dsn, "/"):
+		return setQueryParams(dsn, params, override), nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+// setQueryParams sets params in the query of a URL or MySQL DSN. Only the
+// parameters set are encoded; the rest of the query is kept as given.
+func setQueryParams(dsn string, params map[string]string, override bool) string {
+	// The query starts after the path, which in MySQL DSNs comes after
+	// the last slash; in URLs, the first question mark after the path
+	// will do, since passwords would have it escaped.
+	isURL := urlScheme.MatchString(dsn)
+	start := strings.LastIndex(dsn, "/")
+
+	if isURL {
+		start = strings.Index(dsn, "://") + 3
+	}
+
+	rest, fragment := dsn[start:], ""
+
+	if i := strings.IndexByte(rest, '#'); i >= 0 && isURL {
+		rest, fragment = rest[:i], rest[i:]
+	}
+
+	path, query, _ := strings.Cut(rest, "?")
+
+	var parts []string
+
+	if query != "" {
+		parts = strings.Split(query, "&")
+	}
+
+	set := make(map[string]bool, len(params))
+
+	for i, part := range parts {
+		k, _, _ := strings.Cut(part, "=")
+
+		if key, err := url.QueryUnescape(k); err == nil {
+			k = key
+		}
+
+		if v, ok := params[k]; ok {
+			set[k] = true
+
+			if override {
+				parts[i] = url.QueryEscape(k) + "=" + url.QueryEscape(v)
+			}
+		}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(params)) {
+		if !set[k] {
+			parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(params[k]))
+		}
+	}
+
+	return dsn[:start] + path + "?" + strings.Join(parts, "&") + fragment
+}
+
+// setPairsParams sets params in key/value pairs.
+func setPairsParams(pairs [][2]string, params map[string]string, override bool) [][2]string {
+	for _, k := range slices.Sorted(maps.Keys(params)) {
+		i := slices.IndexFunc(pairs, func(pair [2]string) bool {
+			return strings.EqualFold(pair[0], k)
+		})
+
+		switch {
+		case i < 0:
+			pairs = append(pairs, [2]string{k, params[k]})
+		case override:
+			pairs[i][1] = params[k]
+		}
+	}
+
+	return pairs
+}
+
+// decorate runs the DSN in info through the decorators, if any.
+func (d *Driver) decorate(info DSNInfo) (DSNInfo, error) {
+	for _, dec := range d.decorators {
+		var err error
+
+		if info, err = dec.Decorate(info); err != nil {
+			return DSNInfo{}, err
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,128 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoAddresses is returned by a DiscoveryProvider when its locator finds no
+// addresses at all.
+var ErrNoAddresses = errors.New("lazydsn: no database addresses found")
+
+// A Locator finds the addresses (either hosts or host:port pairs) where a
+// database can be reached, in order of preference. Locators are meant to be
+// backed by a service discovery mechanism, like DNS or a service catalog; see
+// DiscoveryProvider.
+type Locator interface {
+	Locate(ctx context.Context) ([]string, error)
+}
+
+// LocatorFunc lets an inline function literal be used as a Locator.
+type LocatorFunc func(context.Context) ([]string, error)
+
+// Locate exercises the original function.
+func (f LocatorFunc) Locate(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// DiscoveryProvider resolves DSNs for environments where the database
+// endpoint moves, not just the credentials. Credentials come from another
+// provider, and addresses come from a Locator; both are evaluated with every
+// new connection. The DSN is pointed to every address found (see ReplaceHost),
+// and each becomes a candidate; i.e., DiscoveryProvider implements
+// MultiDSNProvider, so the driver can fail over or balance connections among
+// them (see WithBalancing). As a FullDSNProvider, it resolves to the first
+// address only.
+type DiscoveryProvider struct {
+	creds   FullDSNProvider
+	info    InfoDSNProvider
+	locator Locator
+}
+
+// NewDiscoveryProvider creates a provider that combines the addresses found
+// by locator with the DSN returned by creds. The master DSN is given to creds
+// as is, and the resulting DSN must be in one of the formats supported by
+// ReplaceHost. If creds implements InfoDSNProvider, the details it reports
+// (like the CA bundle, or when the credential was issued) are carried over to
+// every candidate.
+func NewDiscoveryProvider(creds DSNProvider, locator Locator) *DiscoveryProvider {
+	p := &DiscoveryProvider{
+		locator: locator,
+	}
+
+	p.info, _ = creds.(InfoDSNProvider)
+
+	if full, ok := creds.(FullDSNProvider); ok {
+		p.creds = full
+	} else {
+		p.creds = fullProvider{
+			DSNProvider: creds,
+		}
+	}
+
+	return p
+}
+
+// FetchDSN resolves the DSN for the first address found.
+func (p *DiscoveryProvider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves the DSN for the first address found.
+func (p *DiscoveryProvider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	infos, err := p.FetchDSNs(ctx, dsn)
+
+	if err != nil {
+		return "", err
+	}
+
+	return infos[0].DSN, nil
+}
+
+// FetchDSNs resolves a DSN for every address found, in order of preference.
+// Candidates are identified by address; see DSNInfo.Endpoint.
+func (p *DiscoveryProvider) FetchDSNs(ctx context.Context, dsn string) ([]DSNInfo, error) {
+	addrs, err := p.locator.Locate(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, ErrNoAddresses
+	}
+
+	var base DSNInfo
+
+	if p.info != nil {
+		base, err = p.info.FetchDSNInfo(ctx, dsn)
+	} else {
+		base.DSN, err = p.creds.FetchDSNWithContext(ctx, dsn)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]DSNInfo, len(addrs))
+
+	for i, addr := range addrs {
+		info := base
+		info.Endpoint = addr
+
+		if info.DSN, err = ReplaceHost(base.DSN, addr); err != nil {
+			return nil, err
+		}
+
+		infos[i] = info
+	}
+
+	return infos, nil
+}
+
+// DiscoveryProvider implements the FullDSNProvider and MultiDSNProvider
+// interfaces.
+var (
+	_ FullDSNProvider  = &DiscoveryProvider{}
+	_ MultiDSNProvider = &DiscoveryProvider{}
+)
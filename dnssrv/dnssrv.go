@@ -0,0 +1,105 @@
+/*
+Package dnssrv implements a lazydsn provider that discovers database endpoints
+with DNS, every time a connection is opened. That's useful where the endpoint
+itself moves, as with Consul DNS or headless Kubernetes services, and not just
+the credentials. Credentials come from another provider, whose DSN is pointed
+to the addresses found:
+
+	creds := awssm.New(client, awssm.PostgreSQL)
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(),
+		dnssrv.New(creds, "_postgres._tcp.db.service.consul"),
+		lazydsn.WithBalancing(lazydsn.RoundRobin),
+	)
+
+	db, err := sql.Open("lazydsn:pgx", "arn:aws:secretsmanager:...")
+
+Names starting with an underscore are looked up as SRV records, that provide
+both hosts and ports; candidates are sorted by priority and weight, as
+described in RFC 2782. Any other name is looked up as A/AAAA records, keeping
+the port in the credentials DSN. Either way, every address found becomes a
+candidate DSN (see lazydsn.DiscoveryProvider), so the driver can fail over or
+balance connections among them.
+*/
+package dnssrv
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// Resolver is the subset of the DNS API used by the locator. *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Locator finds database addresses in DNS. It implements lazydsn.Locator.
+type Locator struct {
+	name     string
+	resolver Resolver
+}
+
+// An Option configures optional behavior for a Locator.
+type Option func(*Locator)
+
+// WithResolver sets the resolver used for lookups, instead of
+// net.DefaultResolver.
+func WithResolver(r Resolver) Option {
+	return func(l *Locator) {
+		l.resolver = r
+	}
+}
+
+// NewLocator creates a locator that looks up name in DNS.
+func NewLocator(name string, opts ...Option) *Locator {
+	l := &Locator{
+		name:     name,
+		resolver: net.DefaultResolver,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// New creates a provider that looks up name in DNS, and fetches credentials
+// from creds. See lazydsn.NewDiscoveryProvider.
+func New(creds lazydsn.DSNProvider, name string, opts ...Option) *lazydsn.DiscoveryProvider {
+	return lazydsn.NewDiscoveryProvider(creds, NewLocator(name, opts...))
+}
+
+// Locate returns the addresses that the name resolves to. Those coming from
+// SRV records include a port.
+func (l *Locator) Locate(ctx context.Context) ([]string, error) {
+	if !strings.HasPrefix(l.name, "_") {
+		return l.resolver.LookupHost(ctx, l.name)
+	}
+
+	_, records, err := l.resolver.LookupSRV(ctx, "", "", l.name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(records))
+
+	for _, srv := range records {
+		// A single dot means the service is decidedly not available at
+		// this domain.
+		if target := strings.TrimSuffix(srv.Target, "."); target != "" {
+			addrs = append(addrs, net.JoinHostPort(target, strconv.Itoa(int(srv.Port))))
+		}
+	}
+
+	return addrs, nil
+}
+
+// Locator implements the lazydsn.Locator interface.
+var _ lazydsn.Locator = &Locator{}
@@ -0,0 +1,128 @@
+package dnssrv_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/dnssrv"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// fakeResolver answers SRV and host lookups from fixed records.
+type fakeResolver struct {
+	srv   []*net.SRV
+	hosts []string
+	err   error
+}
+
+func (r fakeResolver) LookupSRV(context.Context, string, string, string) (string, []*net.SRV, error) {
+	return "", r.srv, r.err
+}
+
+func (r fakeResolver) LookupHost(context.Context, string) ([]string, error) {
+	return r.hosts, r.err
+}
+
+// TestLocate checks the addresses found for SRV and host records.
+func TestLocate(t *testing.T) {
+	refused := errors.New("refused")
+
+	tests := []struct {
+		name     string
+		lookup   string
+		resolver fakeResolver
+		want     []string
+		err      error
+	}{
+		{
+			name:   "srv",
+			lookup: "_postgres._tcp.db",
+			resolver: fakeResolver{srv: []*net.SRV{
+				{Target: "a.db.", Port: 5432},
+				{Target: "b.db.", Port: 5433},
+			}},
+			want: []string{"a.db:5432", "b.db:5433"},
+		},
+		{
+			name:   "srv not available",
+			lookup: "_postgres._tcp.db",
+			resolver: fakeResolver{srv: []*net.SRV{
+				{Target: ".", Port: 0},
+			}},
+			want: []string{},
+		},
+		{
+			name:     "srv empty",
+			lookup:   "_postgres._tcp.db",
+			resolver: fakeResolver{},
+			want:     []string{},
+		},
+		{
+			name:     "hosts",
+			lookup:   "db.internal",
+			resolver: fakeResolver{hosts: []string{"10.0.0.1", "10.0.0.2"}},
+			want:     []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:     "error",
+			lookup:   "_postgres._tcp.db",
+			resolver: fakeResolver{err: refused},
+			err:      refused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := dnssrv.NewLocator(tt.lookup, dnssrv.WithResolver(tt.resolver))
+			got, err := l.Locate(context.Background())
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFetchDSNs checks that the credentials DSN is pointed to every address
+// found, and that finding none is an error.
+func TestFetchDSNs(t *testing.T) {
+	creds := lazydsntest.NewProvider("postgres://u:p@placeholder:5432/app")
+	p := dnssrv.New(creds, "_postgres._tcp.db", dnssrv.WithResolver(fakeResolver{srv: []*net.SRV{
+		{Target: "a.db.", Port: 5432},
+		{Target: "b.db.", Port: 6432},
+	}}))
+
+	infos, err := p.FetchDSNs(context.Background(), "master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"postgres://u:p@a.db:5432/app", "postgres://u:p@b.db:6432/app"}
+
+	var got []string
+
+	for _, info := range infos {
+		got = append(got, info.DSN)
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	p = dnssrv.New(creds, "_postgres._tcp.db", dnssrv.WithResolver(fakeResolver{srv: []*net.SRV{
+		{Target: "."},
+	}}))
+
+	if _, err := p.FetchDSNs(context.Background(), "master"); !errors.Is(err, lazydsn.ErrNoAddresses) {
+		t.Errorf("got %v, want %v", err, lazydsn.ErrNoAddresses)
+	}
+}
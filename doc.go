@@ -61,5 +61,13 @@ fully suported, but completely transparent.
 If the type that you provide also implements FullDSNProvider, then a
 cancellation context will be provided when available. Again, for convenience,
 you can use a DSNProviderWCFunc to give your context-enabled function inline.
+
+Failures are counted and reported by class (see ErrorClass), so that a secrets
+backend that can't be reached is told apart from a database rejecting a freshly
+rotated password. The driver can recognize timeouts and network errors on its
+own, but only the inner driver knows what its authentication errors look like;
+give a Classifier with WithClassifier to fill that gap. Counters are available
+through Driver.Stats, and every fetch and connect attempt can be followed with
+an Observer set with WithObserver.
 */
 package lazydsn
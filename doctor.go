@@ -0,0 +1,93 @@
+package lazydsn
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotLazyDSN is returned by Doctor for pools that are not backed by a
+// Driver.
+var ErrNotLazyDSN = errors.New("lazydsn: pool is not backed by a lazydsn driver")
+
+// A Finding is a configuration problem found by Doctor or Diagnose.
+type Finding struct {
+	Problem string // What's wrong, e.g., "connections can outlive credentials by 40m0s"
+	Advice  string // What to do about it
+}
+
+// String returns the problem and the advice, in a single line.
+func (f Finding) String() string {
+	return f.Problem + "; " + f.Advice
+}
+
+// Diagnose checks the pool settings in cfg (only ConnMaxLifetime and
+// ConnMaxIdleTime are looked at) against the rotation policy, whose MaxAge is
+// taken as the time that credentials stay valid. The most common mistake with
+// this driver is letting connections live longer than the credentials they
+// were opened with: they keep working until the database drops them, but
+// they hide a broken rotation until it's too late, and some databases do drop
+// sessions when their credentials are revoked. Nothing is reported if the
+// configuration looks fine.
+func Diagnose(cfg PoolConfig, policy RotationPolicy) []Finding {
+	var findings []Finding
+
+	if policy.MaxAge <= 0 {
+		return append(findings, Finding{
+			Problem: "credential lifetime unknown",
+			Advice:  "set a rotation policy with the rotation interval as MaxAge, so that it can be checked against",
+		})
+	}
+
+	switch lifetime := cfg.ConnMaxLifetime; {
+	case lifetime <= 0:
+		problem := "connections never expire, and can outlive credentials indefinitely"
+
+		if cfg.ConnMaxIdleTime > 0 {
+			problem = "connections in use never expire, and can outlive credentials indefinitely"
+		}
+
+		findings = append(findings, Finding{
+			Problem: problem,
+			Advice:  fmt.Sprintf("set ConnMaxLifetime to %v or less", policy.MaxAge),
+		})
+	case lifetime > policy.MaxAge:
+		findings = append(findings, Finding{
+			Problem: fmt.Sprintf("connections can outlive credentials by %v", lifetime-policy.MaxAge),
+			Advice:  fmt.Sprintf("lower ConnMaxLifetime to %v or less", policy.MaxAge),
+		})
+	}
+
+	return findings
+}
+
+// Doctor checks the settings of the pool in db, given in cfg, against the
+// rotation policy of d (see WithRotationPolicy), and the credential currently
+// in use, and reports any misconfigurations found; see Diagnose. database/sql
+// doesn't expose pool settings, so they must be the same ones that db was
+// configured with (e.g., the PoolConfig given to OpenDB). d can be nil when db
+// is backed by a Driver that DriverOf can find; it's needed when the driver is
+// wrapped (e.g., for instrumentation) in a way that hides it, in which case
+// ErrNotLazyDSN is returned otherwise.
+func Doctor(db *sql.DB, d *Driver, cfg PoolConfig) ([]Finding, error) {
+	if d == nil {
+		var ok bool
+
+		if d, ok = DriverOf(db); !ok {
+			return nil, ErrNotLazyDSN
+		}
+	}
+
+	findings := Diagnose(cfg, d.policy)
+
+	if s := d.Stats(); d.policy.MaxAge > 0 && s.CredentialAge > d.policy.MaxAge {
+		findings = append(findings, Finding{
+			Problem: fmt.Sprintf("the credential in use was first seen %v ago, past the rotation interval",
+				s.CredentialAge.Round(time.Second)),
+			Advice: "check that rotation is actually happening",
+		})
+	}
+
+	return findings, nil
+}
@@ -0,0 +1,58 @@
+package lazydsn_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestDoctor checks the pool settings given against the rotation policy.
+func TestDoctor(t *testing.T) {
+	policy := lazydsn.RotationPolicy{MaxAge: time.Hour}
+	d := lazydsn.New(lazydsntest.NewDriver(), lazydsntest.NewProvider("db"), lazydsn.WithRotationPolicy(policy))
+	connector, err := d.OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	tests := []struct {
+		name     string
+		cfg      lazydsn.PoolConfig
+		findings int
+	}{
+		{"fine", lazydsn.PoolConfig{ConnMaxLifetime: 30 * time.Minute}, 0},
+		{"at the limit", lazydsn.PoolConfig{ConnMaxLifetime: time.Hour}, 0},
+		{"too long", lazydsn.PoolConfig{ConnMaxLifetime: 2 * time.Hour}, 1},
+		{"never expire", lazydsn.PoolConfig{}, 1},
+		{"idle only", lazydsn.PoolConfig{ConnMaxIdleTime: time.Minute}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := lazydsn.Doctor(db, nil, tt.cfg)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(findings) != tt.findings {
+				t.Errorf("got findings %v, want %d", findings, tt.findings)
+			}
+		})
+	}
+
+	plain := sql.OpenDB(dsnConnector{"db", newContextDriver()})
+	defer plain.Close()
+
+	if _, err := lazydsn.Doctor(plain, nil, lazydsn.PoolConfig{}); !errors.Is(err, lazydsn.ErrNotLazyDSN) {
+		t.Errorf("got %v for a plain pool, want %v", err, lazydsn.ErrNotLazyDSN)
+	}
+}
@@ -2,8 +2,14 @@ package lazydsn
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Driver is not a database driver by itself, but rather a wrapper on top of
@@ -14,7 +20,72 @@ import (
 // database was sql.Open'ed.
 type Driver struct {
 	driver.Driver
-	dsnp FullDSNProvider
+	dsnp    FullDSNProvider
+	idsnp   InfoDSNProvider
+	vdsnp   VersionedDSNProvider
+	sdsnp   StagedDSNProvider
+	mdsnp   MultiDSNProvider
+	bgdsnp  BlueGreenProvider
+	revoker Revoker
+	starter Starter
+	closer  io.Closer
+
+	classifier       Classifier
+	observer         Observer
+	auditor          Auditor
+	verifier         Verifier
+	decorators       []Decorator
+	attributes       map[string]string
+	tls              *tlsState
+	policy           RotationPolicy
+	identity         *IdentityGuard
+	dsnPolicy        DSNPolicy
+	cooldown         time.Duration
+	balancing        Balancing
+	probe            *HealthProbe
+	clock            Clock
+	warmConns        int
+	warmTTL          time.Duration
+	refresh          time.Duration
+	fetchBudget      float64
+	prefetchLifetime time.Duration
+	prefetchLead     time.Duration
+	validateTimeout  time.Duration
+	serverIdentity   ServerIdentity
+	defaultRole      string
+	dryRunInterval   time.Duration
+	dryRunTimeout    time.Duration
+	revocationGrace  time.Duration
+	revokeTimeout    time.Duration
+	retireConns      bool
+	roles            bool
+	hardened         bool
+	recoverPanics    bool
+
+	stats       driverStats
+	rotations   rotationTracker
+	versions    versionState
+	identities  identityState
+	endpoints   endpointState
+	blueGreen   blueGreenState
+	probers     proberState
+	dryRuns     proberState
+	rebuilds    rebuildSlots
+	warm        warmState
+	resolutions flights[[]DSNInfo]
+	validations validationState
+	servers     serverState
+	retirements retirementState
+	lifecycle   lifecycleState
+	masters     masterState
+	shared      *SharedProvider
+	alias       string
+	salt        [16]byte
+	hashes      sync.Pool
+
+	// openOne is d.open, kept as a func value so that connecting doesn't
+	// allocate a new one every time.
+	openOne openFunc
 }
 
 // New creates a new driver with the given inner driver d and DSN provider.
@@ -22,8 +93,22 @@ type Driver struct {
 // This does NOT register the driver with database/sql. See Register. This
 // function is provided so that other packages are able to create a properly
 // initialized driver, in case they want to extend it (just like we're doing
-// here with other drivers!)
-func New(d driver.Driver, dsnp DSNProvider) *Driver {
+// here with other drivers!) Options, if any, are applied in order.
+//
+// The inner driver can be wrapped already, e.g., for instrumentation with
+// otelsql, ocsql or sqlmw; the Driver only sees the wrapper, and keeps using
+// connectors as long as the wrapper implements driver.DriverContext. Beware
+// that wrappers see inner DSNs, credentials included. Wrapping a Driver (or
+// its connectors) works too, and keeps credentials away from instrumentation;
+// see DriverOf to get at the Driver from the database then.
+func New(d driver.Driver, dsnp DSNProvider, opts ...Option) *Driver {
+	// Shared providers stand for the provider they wrap; see Share.
+	shared, _ := dsnp.(*SharedProvider)
+
+	if shared != nil {
+		dsnp = shared.inner
+	}
+
 	fdsnp, ok := dsnp.(FullDSNProvider)
 
 	if !ok {
@@ -32,10 +117,74 @@ func New(d driver.Driver, dsnp DSNProvider) *Driver {
 		}
 	}
 
-	return &Driver{
-		Driver: d,
-		dsnp:   fdsnp,
+	idsnp, _ := dsnp.(InfoDSNProvider)
+	vdsnp, _ := dsnp.(VersionedDSNProvider)
+	sdsnp, _ := dsnp.(StagedDSNProvider)
+	mdsnp, _ := dsnp.(MultiDSNProvider)
+	bgdsnp, _ := dsnp.(BlueGreenProvider)
+	revoker, _ := dsnp.(Revoker)
+	starter, _ := dsnp.(Starter)
+	closer, _ := dsnp.(io.Closer)
+
+	drv := &Driver{
+		Driver:  d,
+		dsnp:    fdsnp,
+		idsnp:   idsnp,
+		vdsnp:   vdsnp,
+		sdsnp:   sdsnp,
+		mdsnp:   mdsnp,
+		bgdsnp:  bgdsnp,
+		revoker: revoker,
+		starter: starter,
+		closer:  closer,
+
+		cooldown: defaultCooldown,
+		clock:    realClock{},
+	}
+
+	for _, opt := range opts {
+		opt(drv)
+	}
+
+	if shared != nil {
+		shared.bind(drv)
+	}
+
+	// The salt only has to be unpredictable; a failure here leaves it
+	// zeroed, which still keeps plaintext out of memory.
+	_, _ = rand.Read(drv.salt[:])
+	drv.openOne = drv.open
+
+	return drv
+}
+
+// Unwrap returns the inner driver. Along with DriverOf, this lets drivers be
+// stacked with instrumentation wrappers in either order; see New.
+func (d *Driver) Unwrap() driver.Driver {
+	return d.Driver
+}
+
+// DriverOf returns the Driver that backs db, if any. Drivers wrapping a
+// Driver (e.g., for instrumentation) are seen through as long as they have an
+// Unwrap method returning the driver they wrap, as Driver does.
+func DriverOf(db *sql.DB) (*Driver, bool) {
+	drv := db.Driver()
+
+	for drv != nil {
+		if d, ok := drv.(*Driver); ok {
+			return d, true
+		}
+
+		w, ok := drv.(interface{ Unwrap() driver.Driver })
+
+		if !ok {
+			break
+		}
+
+		drv = w.Unwrap()
 	}
+
+	return nil, false
 }
 
 // Register creates and registers the driver under the provided alias, with the
@@ -46,8 +195,10 @@ func New(d driver.Driver, dsnp DSNProvider) *Driver {
 // meaningful at all. It's a good practice to register this as close to the
 // most basic packages in your application as possible, to separate business
 // code from the intricacies of dealing with database drivers.
-func Register(alias string, d driver.Driver, dsnp DSNProvider) {
-	sql.Register(alias, New(d, dsnp))
+func Register(alias string, d driver.Driver, dsnp DSNProvider, opts ...Option) {
+	drv := New(d, dsnp, opts...)
+	drv.alias = alias
+	sql.Register(alias, drv)
 }
 
 // Open opens a database connection and returns the latter as a driver.Conn
@@ -58,26 +209,235 @@ func Register(alias string, d driver.Driver, dsnp DSNProvider) {
 // function and the one needed by the inner driver is entirely done by the
 // DSNProvider assigned to this driver.
 func (d *Driver) Open(dsn string) (driver.Conn, error) {
-	innerDSN, err := d.dsnp.FetchDSN(dsn)
+	ctx := context.Background()
+
+	if d.roles {
+		// There's no context to take the role from.
+		dsn = strings.ReplaceAll(dsn, "{role}", d.defaultRole)
+	}
+
+	candidates, err := d.fetch(ctx, dsn)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return d.Driver.Open(innerDSN)
+	return d.dialOpen(ctx, dsn, candidates)
+}
+
+// dialOpen opens a connection to one of candidates with the inner driver's
+// Open method.
+func (d *Driver) dialOpen(ctx context.Context, dsn string, candidates []DSNInfo) (driver.Conn, error) {
+	return d.dial(ctx, dsn, candidates, d.openOne)
+}
+
+// open opens a connection to a single candidate with the inner driver's Open
+// method. It's the openFunc for drivers without connectors; see openOne.
+func (d *Driver) open(ctx context.Context, info DSNInfo) (driver.Conn, error) {
+	conn, err := d.innerOpen(ctx, info)
+
+	return d.opened(ctx, info, conn, err)
+}
+
+// opened accounts for the outcome of opening conn to info with the inner
+// driver, successful or not, and validates conn if it was opened.
+func (d *Driver) opened(ctx context.Context, info DSNInfo, conn driver.Conn, err error) (driver.Conn, error) {
+	err = d.connectErr(err, info.DSN)
+	d.audit(info, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if conn, err = d.validate(ctx, info, conn); err != nil {
+		return nil, err
+	}
+
+	return d.guardRetired(info, conn), nil
+}
+
+// openFunc opens a connection with the inner driver, using the DSN in info.
+type openFunc func(ctx context.Context, info DSNInfo) (driver.Conn, error)
+
+// connectErr accounts for the outcome of an operation with the inner driver
+// involving innerDSN, successful or not, and returns the error that should be
+// given back to database/sql. Inner drivers are free to include the DSN, or
+// parts of it, in their errors; we scrub anything that looks like a secret.
+// Every error coming from the inner driver must go through here.
+func (d *Driver) connectErr(err error, innerDSN string) error {
+	err = redact(err, innerDSN)
+	d.emit(EventConnect, d.classify(err), err)
+
+	return err
+}
+
+// fetch resolves the candidate inner DSNs through the provider, accounting
+// for the outcome in stats and events. Candidates that are not admitted (see
+// admit) are dropped; it's an error if none is left.
+func (d *Driver) fetch(ctx context.Context, dsn string) ([]DSNInfo, error) {
+	var (
+		infos   []DSNInfo
+		percent float64
+		err     error
+	)
+
+	if d.bgdsnp != nil {
+		infos, percent, err = d.resolveBlueGreen(ctx, dsn)
+	} else {
+		infos, err = d.resolveAll(ctx, dsn, d.versions.pin())
+	}
+
+	if err != nil {
+		d.emit(EventFetch, ClassProvider, err)
+		return nil, err
+	}
+
+	d.emit(EventFetch, ClassNone, nil)
+
+	candidates := infos[:0]
+
+	for _, info := range infos {
+		if aerr := d.admit(dsn, info); aerr != nil {
+			err = aerr
+			continue
+		}
+
+		candidates = append(candidates, info)
+	}
+
+	if len(candidates) == 0 {
+		return nil, err
+	}
+
+	if d.serverIdentity != nil {
+		fp := d.fingerprint(dsn)
+
+		for i := range candidates {
+			candidates[i].master = fp
+		}
+	}
+
+	// Candidates refused by the policy must leave no trace; e.g., they'd be
+	// taken for a rotation otherwise, and retire the ones in use.
+	if err := d.checkPolicy(dsn, candidates); err != nil && d.policy.Enforce {
+		return nil, err
+	}
+
+	d.startProbes(dsn)
+	d.startDryRuns(dsn)
+	d.track(dsn, candidates)
+	d.retire(dsn, candidates)
+	d.versions.observe(candidates[0].Version)
+
+	if d.bgdsnp != nil {
+		d.blueGreen.share(d.fingerprint(dsn), percent)
+	}
+
+	return candidates, nil
 }
 
 // dsnConnector is a basic connector for an inner driver that does not
 // implement the driver.DriverContext interface, meaning that its Open method
-// must be called every time that a new connection is required.
+// must be called every time that a new connection is required. Resolved DSNs
+// can still be reused; see WithRefreshInterval. Candidates may still come
+// with connectors of their own (see DSNInfo.Connector); those are kept by
+// fingerprint, as nativeConnector does, for as long as they're candidates.
 type dsnConnector struct {
 	masterDSN string
 	driver    *Driver
+	snapshots snapshots
+	use       providerUse
+
+	mu         sync.Mutex
+	connectors map[string]driver.Connector
+
+	// openOne is c.open, kept as a func value for the same reason as the
+	// driver's.
+	openOne openFunc
+}
+
+// Connect opens a new connection with the inner driver's Open method.
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := c.driver.start(ctx, &c.use); err != nil {
+		return nil, err
+	}
+
+	return c.snapshots.connect(ctx, c.dial)
+}
+
+// dial opens a connection to one of the candidates in snap.
+func (c *dsnConnector) dial(ctx context.Context, snap *snapshot) (driver.Conn, error) {
+	return c.driver.dial(ctx, c.masterDSN, snap.candidates, c.openOne)
+}
+
+// open opens a connection to a single candidate, with the connector that the
+// provider supplies for it, if any, or with the inner driver's Open method.
+func (c *dsnConnector) open(ctx context.Context, info DSNInfo) (driver.Conn, error) {
+	if info.Connector == nil {
+		return c.driver.open(ctx, info)
+	}
+
+	connector, err := c.connector(info)
+
+	if err != nil {
+		return nil, c.driver.connectErr(err, info.DSN)
+	}
+
+	conn, err := c.driver.innerConnect(ctx, connector, info.DSN)
+
+	return c.driver.opened(ctx, info, conn, err)
 }
 
-// Connect opens a new connection by calling the Open method in this driver.
-func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
-	return c.driver.Open(c.masterDSN)
+// connector returns the connector for info, creating it if needed.
+func (c *dsnConnector) connector(info DSNInfo) (driver.Connector, error) {
+	var sum digest
+	c.driver.digest(info.DSN, &sum)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if connector, ok := c.connectors[string(sum[:])]; ok {
+		return connector, nil
+	}
+
+	connector, err := c.driver.innerConnector(info)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.connectors == nil {
+		c.connectors = make(map[string]driver.Connector)
+	}
+
+	c.connectors[string(sum[:])] = connector
+
+	return connector, nil
+}
+
+// prepare closes the connectors for DSNs that are no longer among the
+// candidates of a new snapshot; i.e., after a rotation. Connections already
+// open are left alone.
+func (c *dsnConnector) prepare(snap *snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.connectors) == 0 {
+		return
+	}
+
+	keep := make(map[string]bool, len(snap.candidates))
+
+	for _, info := range snap.candidates {
+		keep[c.driver.fingerprint(info.DSN)] = true
+	}
+
+	for fp, connector := range c.connectors {
+		if !keep[fp] {
+			closeConnector(connector)
+			delete(c.connectors, fp)
+		}
+	}
 }
 
 // Driver returns the driver for the connector.
@@ -85,44 +445,174 @@ func (c *dsnConnector) Driver() driver.Driver {
 	return c.driver
 }
 
-// dsnConnector implements the driver.Connector interface.
-var _ driver.Connector = &dsnConnector{}
+// Close is called by database/sql when the database is closed. Connectors
+// supplied by the provider are closed too, if they support that. See also
+// Revoker and Starter.
+func (c *dsnConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+
+	for _, connector := range c.connectors {
+		errs = append(errs, closeConnector(connector))
+	}
+
+	c.connectors = nil
+	errs = append(errs, c.driver.close(c.masterDSN, &c.use))
+
+	return errors.Join(errs...)
+}
+
+// dsnConnector implements the driver.Connector and io.Closer interfaces.
+var (
+	_ driver.Connector = &dsnConnector{}
+	_ io.Closer        = &dsnConnector{}
+)
 
 // nativeConnector is a connector for inner drivers that implement the
 // driver.DriverContext interface. We keep both a master DSN (as given to
-// Driver) and the last known inner DSN, as returned from the DSN provider.
-// That helps us renew the inner driver's connector only when the inner DSN
-// changes.
+// Driver) and the inner driver's connectors for the last known inner DSNs, as
+// returned from the DSN provider. That helps us renew the inner driver's
+// connectors only when inner DSNs change. Connectors are indexed by
+// fingerprint, which is only a digest of the DSN. The connectors for the
+// candidates are also part of each snapshot, so that connecting doesn't need
+// the index in the steady state.
 type nativeConnector struct {
 	masterDSN string
-	innerDSN  string
-	connector driver.Connector
 	driver    *Driver
+	snapshots snapshots
+
+	mu         sync.Mutex
+	connectors map[string]driver.Connector
+	last       []driver.Connector
+	use        providerUse
 }
 
 // Connect opens a new connection by using the inner driver's connector type.
-// The inner DSN is always fetched and check against the one that the connector
-// was created for. A new connector is created every time a change is detected.
+// The inner DSNs are fetched (or taken from the last snapshot; see
+// WithRefreshInterval) and checked against the ones that the connectors were
+// created for. A new connector is created every time a change is detected.
 func (c *nativeConnector) Connect(ctx context.Context) (driver.Conn, error) {
-	innerDSN, err := c.driver.dsnp.FetchDSNWithContext(ctx, c.masterDSN)
+	return c.snapshots.connect(ctx, c.dial)
+}
+
+// dial opens a connection to one of the candidates in snap.
+func (c *nativeConnector) dial(ctx context.Context, snap *snapshot) (driver.Conn, error) {
+	return c.driver.dial(ctx, c.masterDSN, snap.candidates, snap.open)
+}
+
+// open opens a connection to a single candidate in snap. See prepare.
+func (c *nativeConnector) open(ctx context.Context, snap *snapshot, info DSNInfo) (driver.Conn, error) {
+	connector := snap.connector(info.DSN)
+
+	if connector == nil {
+		// A fallback version, or a connector that couldn't be created
+		// when the snapshot was taken.
+		var err error
+
+		if connector, err = c.connector(info); err != nil {
+			return nil, c.driver.connectErr(err, info.DSN)
+		}
+	}
+
+	conn, err := c.driver.innerConnect(ctx, connector, info.DSN)
+
+	return c.driver.opened(ctx, info, conn, err)
+}
+
+// connector returns the inner driver's connector for info, creating it if
+// needed.
+func (c *nativeConnector) connector(info DSNInfo) (driver.Connector, error) {
+	var sum digest
+	c.driver.digest(info.DSN, &sum)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if connector, ok := c.connectors[string(sum[:])]; ok {
+		return connector, nil
+	}
+
+	// Configuration changed; we need a new connector.
+	connector, err := c.driver.innerConnector(info)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if innerDSN != c.innerDSN {
-		// Configuration changed; we need a new connector.
-		conn, err := c.driver.Driver.(driver.DriverContext).OpenConnector(innerDSN)
+	if c.connectors == nil {
+		c.connectors = make(map[string]driver.Connector)
+	}
 
-		if err != nil {
-			return nil, err
+	c.connectors[string(sum[:])] = connector
+
+	return connector, nil
+}
+
+// prepare sets up a new snapshot with the connectors for its candidates, in
+// order, creating those that are missing, and the openFunc that dials them.
+// Connectors for DSNs that are no longer among the candidates are closed;
+// connections already open are not affected. Entries for connectors that
+// can't be created are left nil, so that the error comes up when connecting.
+func (c *nativeConnector) prepare(snap *snapshot) {
+	snap.open = func(ctx context.Context, info DSNInfo) (driver.Conn, error) {
+		return c.open(ctx, snap, info)
+	}
+
+	candidates := snap.candidates
+
+	var dropped []driver.Connector
+
+	defer func() {
+		for _, connector := range dropped {
+			closeConnector(connector)
+		}
+	}()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(candidates) == 1 && len(c.last) == 1 && len(c.connectors) == 1 {
+		var sum digest
+		c.driver.digest(candidates[0].DSN, &sum)
+
+		if connector, ok := c.connectors[string(sum[:])]; ok && connector == c.last[0] {
+			// The usual case; nothing changed. Snapshots never
+			// modify their connectors, so they can share them.
+			snap.connectors = c.last
+			return
 		}
+	}
+
+	connectors := make([]driver.Connector, len(candidates))
+	keep := make(map[string]driver.Connector, len(candidates))
+
+	for i, info := range candidates {
+		fp := c.driver.fingerprint(info.DSN)
+		connector, ok := c.connectors[fp]
+
+		if !ok {
+			var err error
+
+			if connector, err = c.driver.innerConnector(info); err != nil {
+				continue
+			}
+		}
+
+		keep[fp] = connector
+		connectors[i] = connector
+	}
 
-		c.connector = conn
-		c.innerDSN = innerDSN
+	for fp, connector := range c.connectors {
+		if _, ok := keep[fp]; !ok {
+			dropped = append(dropped, connector)
+		}
 	}
 
-	return c.connector.Connect(ctx)
+	c.connectors = keep
+	c.last = connectors
+	snap.connectors = connectors
 }
 
 // Driver returns the driver for the connector.
@@ -130,38 +620,233 @@ func (c *nativeConnector) Driver() driver.Driver {
 	return c.driver
 }
 
-// nativeConnector implements the driver.Connector interface.
-var _ driver.Connector = &nativeConnector{}
+// Close is called by database/sql when the database is closed. The inner
+// driver's connectors are closed too, if they support that. See also Revoker
+// and Starter.
+func (c *nativeConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+
+	for _, connector := range c.connectors {
+		errs = append(errs, closeConnector(connector))
+	}
+
+	c.connectors = nil
+	errs = append(errs, c.driver.close(c.masterDSN, &c.use))
+
+	return errors.Join(errs...)
+}
+
+// nativeConnector implements the driver.Connector and io.Closer interfaces.
+var (
+	_ driver.Connector = &nativeConnector{}
+	_ io.Closer        = &nativeConnector{}
+)
 
 // OpenConnector returns a driver.Connector that can be used to open
 // connections to the database without having the inner driver parsing the DSN
 // repeatedly. That's, of course, as long as the inner driver implements the
 // driver.DriverContext interface. If not, the resulting connector will simply
-// be wrapping the Open method.
+// be wrapping the Open method. With roles, connectors for each role are only
+// opened when first requested; see WithRoles.
 func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
-	if driverCtx, ok := d.Driver.(driver.DriverContext); ok {
-		innerDSN, err := d.dsnp.FetchDSN(dsn)
+	if d.roles {
+		return &roleConnector{
+			masterDSN:  dsn,
+			driver:     d,
+			connectors: make(map[string]driver.Connector),
+		}, nil
+	}
 
-		if err != nil {
+	return d.openConnector(dsn)
+}
+
+// openConnector returns a connector for dsn. See OpenConnector.
+func (d *Driver) openConnector(dsn string) (driver.Connector, error) {
+	if _, ok := d.Driver.(driver.DriverContext); ok {
+		c := &nativeConnector{
+			masterDSN: dsn,
+			driver:    d,
+		}
+
+		if err := d.start(context.Background(), &c.use); err != nil {
 			return nil, err
 		}
 
-		connector, err := driverCtx.OpenConnector(innerDSN)
+		generation := d.versions.generation.Load()
+		candidates, err := d.fetch(context.Background(), dsn)
 
 		if err != nil {
-			return nil, err
+			return nil, errors.Join(err, d.stop(&c.use))
 		}
 
-		return &nativeConnector{
+		c.snapshots = snapshots{
 			masterDSN: dsn,
-			innerDSN:  innerDSN,
-			connector: connector,
 			driver:    d,
-		}, nil
+			prepare:   c.prepare,
+		}
+
+		if snap := c.snapshots.publish(candidates, d.clock.Now(), generation); snap.connectors[0] == nil {
+			// Let the inner driver tell what's wrong.
+			if _, err := c.connector(candidates[0]); err != nil {
+				return nil, errors.Join(d.connectErr(err, candidates[0].DSN), d.stop(&c.use))
+			}
+		}
+
+		d.acquire(dsn)
+
+		return c, nil
+	}
+
+	c := &dsnConnector{
+		masterDSN: dsn,
+		driver:    d,
 	}
 
-	return &dsnConnector{
+	c.snapshots = snapshots{
 		masterDSN: dsn,
 		driver:    d,
-	}, nil
+		prepare:   c.prepare,
+	}
+
+	c.openOne = c.open
+	d.acquire(dsn)
+
+	return c, nil
+}
+
+// NewConnector returns a connector for dsn, with a new Driver for the given
+// inner driver and provider (see New), to be used with sql.OpenDB. That
+// spares libraries and tests from registering drivers globally, and from
+// coming up with aliases that don't clash. Unlike OpenConnector, this doesn't
+// exercise the provider right away; errors come up with the first
+// connection, and the provider is tried again with the next one, until it
+// works.
+func NewConnector(d driver.Driver, dsn string, dsnp DSNProvider, opts ...Option) driver.Connector {
+	return &lazyConnector{
+		masterDSN: dsn,
+		driver:    New(d, dsnp, opts...),
+	}
+}
+
+// lazyConnector defers OpenConnector until a connection is first needed.
+type lazyConnector struct {
+	masterDSN string
+	driver    *Driver
+
+	mu        sync.Mutex
+	connector driver.Connector
+	opening   *opening
+	closed    bool
+}
+
+// opening is an attempt at opening the Driver's connector; done is closed
+// once the outcome is in.
+type opening struct {
+	done      chan struct{}
+	connector driver.Connector
+	err       error
+}
+
+// Connect opens a new connection with the Driver's connector, opening the
+// latter first if needed. Connections waiting for the connector give up once
+// ctx is done, while the connector keeps opening for the ones to come.
+func (c *lazyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrConnectorClosed
+	}
+
+	connector, o := c.connector, c.opening
+
+	if connector == nil && o == nil {
+		o = &opening{
+			done: make(chan struct{}),
+		}
+
+		c.opening = o
+		go c.open(o)
+	}
+
+	c.mu.Unlock()
+
+	if connector == nil {
+		select {
+		case <-o.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if o.err != nil {
+			return nil, o.err
+		}
+
+		connector = o.connector
+	}
+
+	return connector.Connect(ctx)
 }
+
+// open opens the Driver's connector for o. Errors are reported to those
+// waiting, and the next connection tries again. A connector opened after
+// Close is closed right away.
+func (c *lazyConnector) open(o *opening) {
+	defer close(o.done)
+
+	connector, err := c.driver.OpenConnector(c.masterDSN)
+
+	c.mu.Lock()
+	c.opening = nil
+	closed := c.closed
+
+	if err == nil && !closed {
+		c.connector = connector
+	}
+
+	c.mu.Unlock()
+
+	switch {
+	case err != nil:
+		o.err = err
+	case closed:
+		o.err = ErrConnectorClosed
+		connector.(io.Closer).Close()
+	default:
+		o.connector = connector
+	}
+}
+
+// Driver returns the driver for the connector.
+func (c *lazyConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close closes the Driver's connector, if it was ever opened, or as soon as
+// it is if it's being opened. Connecting fails with ErrConnectorClosed from
+// then on.
+func (c *lazyConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+
+	if c.connector == nil {
+		return nil
+	}
+
+	return c.connector.(io.Closer).Close()
+}
+
+// lazyConnector implements the driver.Connector and io.Closer interfaces.
+var (
+	_ driver.Connector = &lazyConnector{}
+	_ io.Closer        = &lazyConnector{}
+)
@@ -0,0 +1,95 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNothingStaged is returned by a StagedDSNProvider when there are no
+// credentials staged for the next rotation.
+var ErrNothingStaged = errors.New("lazydsn: no credentials staged")
+
+// A StagedDSNProvider is a provider that can resolve credentials staged for
+// the next rotation, before they take effect; e.g., a secret version that's
+// pending, or the next of a set of scheduled credentials. Implementing this
+// interface lets the driver try them ahead of time; see WithDryRun. Staged
+// credentials that are already current, or none at all, are reported with
+// ErrNothingStaged.
+type StagedDSNProvider interface {
+	FetchStagedDSN(ctx context.Context, dsn string) (DSNInfo, error)
+}
+
+// startDryRuns starts trying the credentials staged for dsn, unless that's
+// already going on.
+func (d *Driver) startDryRuns(dsn string) {
+	if d.dryRunInterval <= 0 || d.sdsnp == nil {
+		return
+	}
+
+	if stop, ok := d.dryRuns.start(d.fingerprint(dsn)); ok {
+		go d.dryRunLoop(dsn, stop)
+	}
+}
+
+// stopDryRuns stops trying the credentials staged for dsn.
+func (d *Driver) stopDryRuns(dsn string) {
+	d.dryRuns.stop(d.fingerprint(dsn))
+}
+
+// dryRunLoop tries the credentials staged for dsn every interval, until
+// stopped.
+func (d *Driver) dryRunLoop(dsn string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.clock.After(d.dryRunInterval):
+		}
+
+		d.dryRun(dsn)
+	}
+}
+
+// dryRun tries the credentials staged for dsn once, if any. They go through
+// the same checks as current credentials would, but violations are only
+// reported as a failed dry run.
+func (d *Driver) dryRun(dsn string) {
+	timeout := d.dryRunTimeout
+
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	info, err := d.sdsnp.FetchStagedDSN(ctx, dsn)
+
+	if errors.Is(err, ErrNothingStaged) {
+		return
+	}
+
+	if err == nil {
+		info, err = d.process(dsn, info)
+	}
+
+	if err != nil {
+		d.emit(EventDryRun, ClassProvider, err)
+		return
+	}
+
+	if d.dsnPolicy != nil {
+		if reason := d.dsnPolicy.Check(ParseDSN(info.DSN)); reason != nil {
+			d.emit(EventDryRun, ClassProvider, fmt.Errorf("%w: %w", ErrDSNRejected, reason))
+			return
+		}
+	}
+
+	if err := redact(d.probeOne(ctx, info), info.DSN); err != nil {
+		d.emit(EventDryRun, d.classify(err), err)
+		return
+	}
+
+	d.emit(EventDryRun, ClassNone, nil)
+}
@@ -2,6 +2,8 @@ package lazydsn
 
 import (
 	"context"
+	"database/sql/driver"
+	"time"
 )
 
 // A DSNProvider allows converting a DSN, as provided to this driver via
@@ -57,3 +59,91 @@ func (f DSNProviderWCFunc) FetchDSN(dsn string) (string, error) {
 func (f DSNProviderWCFunc) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
 	return f(ctx, dsn)
 }
+
+// DSNInfo is the result of resolving a DSN with a provider that is able to
+// tell more than just the inner DSN. See InfoDSNProvider and
+// VersionedDSNProvider.
+type DSNInfo struct {
+	// DSN is the inner DSN, as it should be given to the inner driver.
+	DSN string
+
+	// Version identifies the version of the secret that the DSN was built
+	// from. Identifiers are opaque to this package and entirely up to the
+	// provider and its backend; e.g., some backends use labels for stages
+	// as well as unique version identifiers.
+	Version string
+
+	// Fallbacks lists other versions of the secret that are worth trying,
+	// in order, when the database rejects the credentials in DSN. That's
+	// typically the case while a rotation is in progress, and only one of
+	// the versions involved works. Fallbacks are only used if errors from
+	// the inner driver can be classified as ClassAuth; see Classifier.
+	Fallbacks []string
+
+	// CABundle optionally holds the PEM encoded certificates of the CAs
+	// that the server's certificate should be verified against. The
+	// driver rebuilds its TLS configuration whenever the bundle changes;
+	// see WithTLS.
+	CABundle []byte
+
+	// Issued optionally tells when the credential in DSN was created. It's
+	// used to check the credential's age against the rotation policy; see
+	// RotationPolicy.
+	Issued time.Time
+
+	// Connector optionally builds the connector used to open connections
+	// with DSN, instead of the inner driver's OpenConnector (or Open). See
+	// ConnectorFunc.
+	Connector ConnectorFunc
+
+	// Endpoint identifies the database endpoint that DSN points to, when
+	// the provider returns several candidates; see MultiDSNProvider. It's
+	// used to keep track of each endpoint's health across rotations. The
+	// driver derives it from the host in DSN when empty.
+	Endpoint string
+
+	// Weight sets the share of new connections for this candidate, when
+	// balancing with Weighted. See Balancing.
+	Weight int
+
+	// Revoked optionally lists versions of the secret that the backend
+	// revoked already (e.g., once a rotation finished), so that
+	// connections still open with them are replaced right away, instead of
+	// waiting for the database to kill them. Only versions superseded
+	// while the driver was watching are recognized. See
+	// WithRevocationGrace.
+	Revoked []string
+
+	// Attributes optionally describe connections opened with this DSN,
+	// to attribute sessions on the database side; e.g., the service or
+	// the credential owner. They're added to the ones set with
+	// WithConnAttributes, taking precedence. See SetConnAttributes.
+	Attributes map[string]string
+
+	// master is the fingerprint of the master DSN that this was resolved
+	// for. It's only kept when verifying server identities; see
+	// WithServerIdentity.
+	master string
+
+	// sealed holds the DSN instead, when it's kept for reuse under memory
+	// hardening; see seal.
+	sealed *secret
+}
+
+// An InfoDSNProvider is a provider that is able to report more than just the
+// inner DSN; see DSNInfo.
+type InfoDSNProvider interface {
+	FetchDSNInfo(ctx context.Context, dsn string) (DSNInfo, error)
+}
+
+// A ConnectorFunc builds a connector for the inner driver out of the inner
+// DSN. Providers return these when credentials can't be expressed as strings,
+// like client keys living in an HSM or a PKCS#11 token, that never leave the
+// hardware. The inner driver must be configured through its native API in
+// those cases; e.g., with mysql.NewConnector and a TLS client certificate
+// whose private key is a crypto.Signer backed by the token. The DSN should
+// then carry no secrets at all.
+//
+// Connectors are built again whenever the DSN changes. The one in use when
+// the database is closed is closed as well, if it implements io.Closer.
+type ConnectorFunc func(innerDSN string) (driver.Connector, error)
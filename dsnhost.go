@@ -0,0 +1,253 @@
+package lazydsn
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ErrUnknownFormat is returned by ReplaceHost and ReplacePassword for DSNs
+// whose format can't be recognized.
+var ErrUnknownFormat = errors.New("lazydsn: unknown DSN format")
+
+// ReplaceHost returns dsn pointing to the given address instead, which is
+// either a host or a host:port pair. If no port is given, the one in dsn (if
+// any) is kept. Everything else in dsn, including credentials, is preserved.
+// This is meant for providers that discover database endpoints on their own
+// (e.g., with DNS or a service catalog), and combine them with credentials
+// obtained elsewhere. The DSN formats supported are those known to ParseDSN;
+// keyword/value and ODBC DSNs are rebuilt, and thus normalized, in the
+// process.
+func ReplaceHost(dsn, addr string) (string, error) {
+	switch {
+	case urlScheme.MatchString(dsn):
+		return replaceURLHost(dsn, addr), nil
+	case kvKey.MatchString(dsn):
+		if isODBC(dsn) {
+			return joinODBC(replacePairsHost(splitODBC(dsn), addr)), nil
+		}
+
+		return joinKeyValue(replacePairsHost(splitKeyValue(dsn), addr)), nil
+	case strings.Contains(dsn, "/"):
+		return replaceMySQLHost(dsn, addr), nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+// ReplacePassword returns dsn with the given password, in place of the one in
+// dsn, if any. Everything else is preserved. This is meant for providers that
+// generate short lived passwords, like authentication tokens, for a DSN
+// configured elsewhere. The password is escaped as the format requires, if
+// at all; DSNs as understood by github.com/go-sql-driver/mysql take it as is.
+// The formats supported are those known to ParseDSN; keyword/value and ODBC
+// DSNs are rebuilt, and thus normalized, in the process.
+func ReplacePassword(dsn, password string) (string, error) {
+	switch {
+	case urlScheme.MatchString(dsn):
+		return replaceURLPassword(dsn, password), nil
+	case kvKey.MatchString(dsn):
+		if isODBC(dsn) {
+			return joinODBC(replacePairsPassword(splitODBC(dsn), "PWD", password)), nil
+		}
+
+		return joinKeyValue(replacePairsPassword(splitKeyValue(dsn), "password", password)), nil
+	case strings.Contains(dsn, "/"):
+		return replaceMySQLPassword(dsn, password), nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+// withPort adds the port in old to addr, unless addr has one already.
+func withPort(addr, old string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+
+	if _, port, err := net.SplitHostPort(old); err == nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+
+	return addr
+}
+
+// replaceURLHost replaces the host in a URL style DSN. We don't go through
+// url.URL, which would re-encode parts of the DSN that drivers may be picky
+// about.
+func replaceURLHost(dsn, addr string) string {
+	i := strings.Index(dsn, "://") + 3
+	end := len(dsn)
+
+	if j := strings.IndexAny(dsn[i:], "/?#"); j >= 0 {
+		end = i + j
+	}
+
+	start := i
+
+	if j := strings.LastIndex(dsn[i:end], "@"); j >= 0 {
+		start = i + j + 1
+	}
+
+	addr = withPort(addr, dsn[start:end])
+
+	if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+		// A bare IPv6 address.
+		addr = "[" + addr + "]"
+	}
+
+	return dsn[:start] + addr + dsn[end:]
+}
+
+// replaceMySQLHost replaces the address in a DSN as understood by
+// github.com/go-sql-driver/mysql. DSNs without an address get a TCP one.
+func replaceMySQLHost(dsn, addr string) string {
+	i := strings.LastIndex(dsn, "/")
+	start := strings.LastIndex(dsn[:i], "@") + 1
+	netAddr := dsn[start:i]
+
+	if k := strings.Index(netAddr, "("); k >= 0 && strings.HasSuffix(netAddr, ")") {
+		old := netAddr[k+1 : len(netAddr)-1]
+		netAddr = netAddr[:k+1] + withPort(addr, old) + ")"
+	} else {
+		netAddr = "tcp(" + addr + ")"
+	}
+
+	return dsn[:start] + netAddr + dsn[i:]
+}
+
+// replaceURLPassword replaces the password in a URL style DSN, keeping the
+// user as given.
+func replaceURLPassword(dsn, password string) string {
+	i := strings.Index(dsn, "://") + 3
+	end := len(dsn)
+
+	if j := strings.IndexAny(dsn[i:], "/?#"); j >= 0 {
+		end = i + j
+	}
+
+	// url.UserPassword escapes the password as userinfo requires, and the
+	// user is empty, so we're left with a colon and the password.
+	pass := url.UserPassword("", password).String()
+
+	if j := strings.LastIndex(dsn[i:end], "@"); j >= 0 {
+		user, _, _ := strings.Cut(dsn[i:i+j], ":")
+		return dsn[:i] + user + pass + dsn[i+j:]
+	}
+
+	return dsn[:i] + pass + "@" + dsn[i:]
+}
+
+// replaceMySQLPassword replaces the password in a DSN as understood by
+// github.com/go-sql-driver/mysql.
+func replaceMySQLPassword(dsn, password string) string {
+	i := strings.LastIndex(dsn, "/")
+	j := strings.LastIndex(dsn[:i], "@")
+
+	if j < 0 {
+		return ":" + password + "@" + dsn
+	}
+
+	user, _, _ := strings.Cut(dsn[:j], ":")
+
+	return user + ":" + password + dsn[j:]
+}
+
+// replacePairsPassword replaces the password in key/value pairs, adding the
+// given key if there's none yet.
+func replacePairsPassword(pairs [][2]string, key, password string) [][2]string {
+	for i, pair := range pairs {
+		switch strings.ToLower(pair[0]) {
+		case "password", "pwd":
+			pairs[i][1] = password
+			return pairs
+		}
+	}
+
+	return append(pairs, [2]string{key, password})
+}
+
+// replacePairsHost replaces the host and port in key/value pairs. The port
+// goes in the port key if there's one, or along with the host if that's how
+// the DSN had it; a port key is added otherwise.
+func replacePairsHost(pairs [][2]string, addr string) [][2]string {
+	hostAt, portAt := -1, -1
+
+	for i, pair := range pairs {
+		switch strings.ToLower(pair[0]) {
+		case "host", "hostname", "server", "data source", "addr", "address":
+			hostAt = i
+		case "port":
+			portAt = i
+		}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+
+	if err != nil {
+		host, port = addr, ""
+	}
+
+	switch {
+	case hostAt < 0:
+		pairs = append(pairs, [2]string{"host", host})
+	case port == "":
+		pairs[hostAt][1] = withPort(addr, pairs[hostAt][1])
+		return pairs
+	case portAt < 0:
+		if _, _, err := net.SplitHostPort(pairs[hostAt][1]); err == nil {
+			// Keep the host:port style.
+			pairs[hostAt][1] = addr
+			return pairs
+		}
+
+		pairs[hostAt][1] = host
+	default:
+		pairs[hostAt][1] = host
+	}
+
+	if port != "" {
+		if portAt < 0 {
+			pairs = append(pairs, [2]string{"port", port})
+		} else {
+			pairs[portAt][1] = port
+		}
+	}
+
+	return pairs
+}
+
+// joinKeyValue builds a PostgreSQL keyword/value DSN out of pairs.
+func joinKeyValue(pairs [][2]string) string {
+	parts := make([]string, len(pairs))
+
+	for i, pair := range pairs {
+		val := pair[1]
+
+		if val == "" || strings.ContainsAny(val, " \t\r\n'\\") {
+			val = "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(val) + "'"
+		}
+
+		parts[i] = pair[0] + "=" + val
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// joinODBC builds an ODBC connection string out of pairs.
+func joinODBC(pairs [][2]string) string {
+	var b strings.Builder
+
+	for _, pair := range pairs {
+		val := pair[1]
+
+		if strings.ContainsAny(val, ";{}") || strings.TrimSpace(val) != val {
+			val = "{" + strings.ReplaceAll(val, "}", "}}") + "}"
+		}
+
+		b.WriteString(pair[0] + "=" + val + ";")
+	}
+
+	return b.String()
+}
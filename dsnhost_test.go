@@ -0,0 +1,141 @@
+package lazydsn
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// hostile are seeds for passwords that DSN formats have a hard time with.
+var hostile = []string{
+	planted,
+	"",
+	" ",
+	"p'a\"s\\s",
+	"{brace}};",
+	"a b\tc\nd",
+	"@@::@/",
+	"%41%zz",
+	"=;='",
+	"ünïcødé",
+}
+
+// seed adds the hostile passwords to f.
+func seed(f *testing.F) {
+	for _, password := range hostile {
+		f.Add(password)
+	}
+}
+
+// passwordOf returns the value of the password key in pairs.
+func passwordOf(pairs [][2]string) (string, bool) {
+	for _, pair := range pairs {
+		switch strings.ToLower(pair[0]) {
+		case "password", "pwd":
+			return pair[1], true
+		}
+	}
+
+	return "", false
+}
+
+func FuzzReplaceHostURL(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, password string) {
+		escaped := url.QueryEscape(password)
+		dsn := "postgres://app:" + escaped + "@db:5432/app?sslmode=require"
+		got, err := ReplaceHost(dsn, "10.0.0.1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "postgres://app:" + escaped + "@10.0.0.1:5432/app?sslmode=require"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func FuzzReplaceHostMySQL(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, password string) {
+		if strings.Contains(password, "/") {
+			// Not representable; the driver itself would split there.
+			t.Skip()
+		}
+
+		dsn := "app:" + password + "@tcp(db:3306)/app?tls=true"
+		got, err := ReplaceHost(dsn, "10.0.0.1")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "app:" + password + "@tcp(10.0.0.1:3306)/app?tls=true"; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func FuzzReplaceHostKeyValue(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, password string) {
+		dsn := joinKeyValue([][2]string{{"host", "db"}, {"user", "app"}, {"password", password}, {"dbname", "app"}})
+		testReplacePairs(t, dsn, password, splitKeyValue)
+	})
+}
+
+func FuzzReplaceHostODBC(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, password string) {
+		dsn := joinODBC([][2]string{{"Driver", "ODBC Driver 18"}, {"Server", "db"}, {"UID", "app"}, {"PWD", password}})
+		testReplacePairs(t, dsn, password, splitODBC)
+	})
+}
+
+// testReplacePairs checks that replacing the host in dsn, a keyword/value or
+// ODBC DSN that split parses, leaves password intact.
+func testReplacePairs(t *testing.T, dsn, password string, split func(string) [][2]string) {
+	if got, ok := passwordOf(split(dsn)); !ok || got != password {
+		// Not ReplaceHost's fault; the DSN doesn't round trip to begin
+		// with.
+		t.Fatalf("DSN %q has password %q, want %q", dsn, got, password)
+	}
+
+	out, err := ReplaceHost(dsn, "10.0.0.1:5432")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs := split(out)
+
+	if got, ok := passwordOf(pairs); !ok || got != password {
+		t.Fatalf("DSN %q has password %q after replacing its host, want %q", out, got, password)
+	}
+
+	if len(pairs) != len(split(dsn))+1 || !strings.Contains(out, "=10.0.0.1") {
+		t.Fatalf("DSN %q was mangled replacing its host", out)
+	}
+}
+
+func FuzzReplacePasswordURL(f *testing.F) {
+	seed(f)
+	f.Fuzz(func(t *testing.T, password string) {
+		got, err := ReplacePassword("postgres://app:old@db:5432/app?sslmode=require", password)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := url.Parse(got)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p, _ := u.User.Password(); u.User.Username() != "app" || p != password || u.Host != "db:5432" {
+			t.Fatalf("DSN %q has user %q and password %q, want %q and %q", got, u.User.Username(), p, "app", password)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package lazydsn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDSNRejected is returned, wrapped along with the reason, when a resolved
+// DSN is rejected by the DSN policy.
+var ErrDSNRejected = errors.New("lazydsn: DSN rejected by policy")
+
+// A DSNPolicy decides whether a resolved DSN may be used, looking at its
+// redacted view. It's meant for platform teams to enforce rules centrally,
+// like "only *.rds.amazonaws.com hosts, TLS required", no matter what
+// providers return. Check is called for every DSN resolved, after the TLS
+// configuration has been applied (see WithTLS), and returns the reason for
+// rejecting it, if it does.
+type DSNPolicy interface {
+	Check(DSNView) error
+}
+
+// DSNPolicyFunc allows using a plain function as a DSNPolicy.
+type DSNPolicyFunc func(DSNView) error
+
+// Check exercises the original function.
+func (f DSNPolicyFunc) Check(v DSNView) error {
+	return f(v)
+}
+
+// checkDSN runs the DSN policy, if any, against the DSN in info.
+func (d *Driver) checkDSN(info DSNInfo) error {
+	if d.dsnPolicy == nil {
+		return nil
+	}
+
+	if reason := d.dsnPolicy.Check(ParseDSN(info.DSN)); reason != nil {
+		err := fmt.Errorf("%w: %w", ErrDSNRejected, reason)
+		d.emitFor(info, EventPolicy, ClassProvider, err)
+
+		return err
+	}
+
+	return nil
+}
+
+// admit decides whether a DSN freshly resolved for dsn may be used, running
+// all checks that apply.
+func (d *Driver) admit(dsn string, info DSNInfo) error {
+	if err := d.checkDSN(info); err != nil {
+		return err
+	}
+
+	return d.checkIdentity(dsn, info)
+}
@@ -0,0 +1,430 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCooldown is how long a failed endpoint is avoided, unless configured
+// otherwise with WithCooldown.
+const defaultCooldown = 30 * time.Second
+
+// errNoCandidates is returned when a MultiDSNProvider returns no DSNs at all.
+var errNoCandidates = errors.New("lazydsn: provider returned no DSNs")
+
+// A MultiDSNProvider resolves a master DSN into several candidate DSNs, in
+// order of preference; e.g., the active and standby members of a database
+// pair. New connections go to the first candidate that works, unless a
+// different strategy is set with WithBalancing (e.g., to spread connections
+// over a fleet of replicas). Candidates that fail to connect are avoided for a
+// while (see WithCooldown), unless no other candidate is available. Multi
+// providers take precedence over all other provider interfaces; in particular,
+// secret versions and fallbacks don't apply to them.
+type MultiDSNProvider interface {
+	FetchDSNs(ctx context.Context, dsn string) ([]DSNInfo, error)
+}
+
+// endpoint holds what the driver knows about a single endpoint. Endpoints
+// are only kept around while there's something to remember; i.e., while they
+// have open connections or failed recently.
+type endpoint struct {
+	name     string
+	open     int64
+	failures int64
+	until    time.Time
+
+	probeFailures int
+	evicted       bool
+}
+
+// endpointState keeps track of endpoint health and load. Endpoints are keyed
+// by master DSN fingerprint and name; see dial.
+type endpointState struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+	turns     map[string]uint64
+	weights   map[string]map[string]int
+}
+
+// resolveAll fetches all candidate DSNs for dsn. Providers that don't
+// implement MultiDSNProvider get a single candidate.
+func (d *Driver) resolveAll(ctx context.Context, dsn, version string) ([]DSNInfo, error) {
+	if d.mdsnp == nil {
+		info, err := d.resolve(ctx, dsn, version)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []DSNInfo{info}, nil
+	}
+
+	infos, err := d.mdsnp.FetchDSNs(ctx, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(infos) == 0 {
+		return nil, errNoCandidates
+	}
+
+	for i := range infos {
+		if infos[i], err = d.process(dsn, infos[i]); err != nil {
+			return nil, err
+		}
+
+		if infos[i].Endpoint == "" {
+			// Fall back to the position in the list if there's no
+			// host in sight.
+			if infos[i].Endpoint = ParseDSN(infos[i].DSN).Host; infos[i].Endpoint == "" {
+				infos[i].Endpoint = "#" + strconv.Itoa(i)
+			}
+		}
+	}
+
+	return infos, nil
+}
+
+// joinDSNs returns a string that changes whenever any of the candidate DSNs
+// does, for the purpose of detecting rotations.
+func joinDSNs(infos []DSNInfo) string {
+	if len(infos) == 1 {
+		return infos[0].DSN
+	}
+
+	dsns := make([]string, len(infos))
+
+	for i, info := range infos {
+		dsns[i] = info.DSN
+	}
+
+	return strings.Join(dsns, "\x00")
+}
+
+// dial opens a connection to one of the candidates with open, picking them
+// as set by WithBalancing. Those in their cool-down period are only tried as a
+// last resort. The error for the first candidate tried is returned if all of
+// them fail.
+func (d *Driver) dial(ctx context.Context, dsn string, candidates []DSNInfo,
+	open openFunc) (driver.Conn, error) {
+	if len(candidates) == 1 {
+		return d.connectWithFallbacks(ctx, dsn, candidates[0], open)
+	}
+
+	fp := d.fingerprint(dsn)
+	prefix := fp + "\x00"
+
+	// Under Failover, connecting anywhere but to the provider's first
+	// choice means failing over; when balancing, or splitting traffic
+	// between blue and green, it's only so if the first choice failed.
+	var ordered []DSNInfo
+	preferred := candidates[0].Endpoint
+
+	if d.bgdsnp != nil {
+		ordered = d.blueGreen.route(fp, candidates)
+		preferred = ordered[0].Endpoint
+	} else {
+		ordered = d.endpoints.order(prefix, candidates, d.balancing, d.clock.Now())
+
+		if d.balancing != Failover {
+			preferred = ordered[0].Endpoint
+		}
+	}
+
+	var firstErr error
+
+	for _, info := range ordered {
+		conn := d.warm.take(prefix, info, d.fingerprint(info.DSN), d.clock.Now())
+		var err error
+
+		if conn == nil {
+			conn, err = d.connectWithFallbacks(ctx, dsn, info, open)
+		}
+
+		if err == nil {
+			key := prefix + info.Endpoint
+			d.endpoints.reset(key)
+
+			if info.Endpoint != preferred {
+				d.emit(EventFailover, ClassNone, nil)
+			}
+
+			// Pre-warming only makes sense when there's a single
+			// active endpoint at a time.
+			if d.balancing == Failover && d.bgdsnp == nil && d.warm.activate(prefix, info.Endpoint, open) {
+				d.prewarm(prefix, info, open)
+			}
+
+			if d.balancing == LeastOpen {
+				d.endpoints.opened(key, info.Endpoint)
+				conn = wrapConn(conn, connHooks{
+					onClose: func() {
+						d.endpoints.closed(key)
+					},
+				})
+			}
+
+			return conn, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about the
+			// endpoint.
+			break
+		}
+
+		d.endpoints.fail(prefix+info.Endpoint, info.Endpoint, d.clock.Now().Add(d.cooldown))
+	}
+
+	return nil, firstErr
+}
+
+// order returns the candidates in the order they should be tried: those
+// available first, then those cooling down or evicted. Available ones are
+// arranged as the balancing strategy says; the provider's order is preserved
+// otherwise.
+func (s *endpointState) order(prefix string, candidates []DSNInfo, b Balancing, now time.Time) []DSNInfo {
+	ordered := make([]DSNInfo, 0, len(candidates))
+	var cooling []DSNInfo
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := 0
+
+	if b == RoundRobin {
+		if s.turns == nil {
+			s.turns = make(map[string]uint64)
+		}
+
+		start = int(s.turns[prefix] % uint64(len(candidates)))
+		s.turns[prefix]++
+	}
+
+	for i := range candidates {
+		info := candidates[(start+i)%len(candidates)]
+
+		if e := s.endpoints[prefix+info.Endpoint]; e != nil && (e.evicted || now.Before(e.until)) {
+			cooling = append(cooling, info)
+		} else {
+			ordered = append(ordered, info)
+		}
+	}
+
+	switch b {
+	case LeastOpen:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return s.open(prefix+ordered[i].Endpoint) < s.open(prefix+ordered[j].Endpoint)
+		})
+	case Weighted:
+		ordered = s.weigh(prefix, ordered)
+	}
+
+	return append(ordered, cooling...)
+}
+
+// weigh picks the candidate to try first with smooth weighted round robin,
+// as used by nginx: every candidate's current weight grows by its weight, and
+// the one with the highest current weight is picked and set back by the total.
+// The rest follow in order of weight. The caller must hold the lock.
+func (s *endpointState) weigh(prefix string, candidates []DSNInfo) []DSNInfo {
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	weight := func(info DSNInfo) int {
+		return max(info.Weight, 0)
+	}
+
+	total := 0
+
+	for _, info := range candidates {
+		total += weight(info)
+	}
+
+	if total == 0 {
+		weight = func(DSNInfo) int {
+			return 1
+		}
+		total = len(candidates)
+	}
+
+	if s.weights == nil {
+		s.weights = make(map[string]map[string]int)
+	}
+
+	// Only keep track of the current candidates.
+	old := s.weights[prefix]
+	current := make(map[string]int, len(candidates))
+	best := -1
+
+	for i, info := range candidates {
+		if weight(info) == 0 {
+			continue
+		}
+
+		current[info.Endpoint] = old[info.Endpoint] + weight(info)
+
+		if best < 0 || current[info.Endpoint] > current[candidates[best].Endpoint] {
+			best = i
+		}
+	}
+
+	current[candidates[best].Endpoint] -= total
+	s.weights[prefix] = current
+
+	ordered := make([]DSNInfo, 0, len(candidates))
+	ordered = append(ordered, candidates[best])
+	ordered = append(ordered, candidates[:best]...)
+	ordered = append(ordered, candidates[best+1:]...)
+
+	sort.SliceStable(ordered[1:], func(i, j int) bool {
+		return weight(ordered[1+i]) > weight(ordered[1+j])
+	})
+
+	return ordered
+}
+
+// open returns the number of open connections to an endpoint. The caller
+// must hold the lock.
+func (s *endpointState) open(key string) int64 {
+	if e := s.endpoints[key]; e != nil {
+		return e.open
+	}
+
+	return 0
+}
+
+// get returns the endpoint for key, creating it if needed. The caller must
+// hold the lock.
+func (s *endpointState) get(key, name string) *endpoint {
+	e := s.endpoints[key]
+
+	if e == nil {
+		if s.endpoints == nil {
+			s.endpoints = make(map[string]*endpoint)
+		}
+
+		e = &endpoint{
+			name: name,
+		}
+		s.endpoints[key] = e
+	}
+
+	return e
+}
+
+// fail starts the cool-down period for an endpoint, until the given time.
+func (s *endpointState) fail(key, name string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.get(key, name)
+	e.failures++
+	e.until = until
+}
+
+// reset marks an endpoint as healthy again, ending its cool-down period.
+func (s *endpointState) reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.endpoints[key]; e != nil {
+		e.failures = 0
+		e.until = time.Time{}
+		s.forget(key, e)
+	}
+}
+
+// opened accounts for a new connection to an endpoint.
+func (s *endpointState) opened(key, name string) {
+	s.mu.Lock()
+	s.get(key, name).open++
+	s.mu.Unlock()
+}
+
+// closed accounts for a connection to an endpoint being closed.
+func (s *endpointState) closed(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e := s.endpoints[key]; e != nil {
+		e.open--
+		s.forget(key, e)
+	}
+}
+
+// forget drops an endpoint once there's nothing left to remember about it.
+// The caller must hold the lock.
+func (s *endpointState) forget(key string, e *endpoint) {
+	if e.open <= 0 && e.failures == 0 && e.probeFailures == 0 && !e.evicted {
+		delete(s.endpoints, key)
+	}
+}
+
+// probed accounts for the outcome of a health probe, returning EventEvict or
+// EventRecover if the endpoint was evicted or recovered, respectively, and -1
+// otherwise.
+func (s *endpointState) probed(key, name string, err error, threshold int) EventKind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if threshold <= 0 {
+		threshold = 1
+	}
+
+	e := s.get(key, name)
+
+	if err == nil {
+		e.probeFailures = 0
+		recovered := e.evicted
+		e.evicted = false
+		s.forget(key, e)
+
+		if recovered {
+			return EventRecover
+		}
+
+		return -1
+	}
+
+	e.probeFailures++
+
+	if !e.evicted && e.probeFailures >= threshold {
+		e.evicted = true
+		return EventEvict
+	}
+
+	return -1
+}
+
+// stats returns the health of every endpoint known, by name. Endpoints with
+// the same name under different master DSNs are added up.
+func (s *endpointState) stats(now time.Time) map[string]EndpointStats {
+	stats := make(map[string]EndpointStats)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.endpoints {
+		es := stats[e.name]
+		es.Open += e.open
+		es.Failures += e.failures
+		es.Cooling = es.Cooling || now.Before(e.until)
+		es.Evicted = es.Evicted || e.evicted
+		stats[e.name] = es
+	}
+
+	return stats
+}
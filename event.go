@@ -0,0 +1,186 @@
+package lazydsn
+
+import (
+	"slices"
+	"time"
+)
+
+// EventKind identifies the operation that an Event refers to.
+type EventKind int
+
+// Kinds of events emitted by the driver.
+const (
+	// EventFetch is emitted after every attempt to resolve the inner DSN
+	// through the provider.
+	EventFetch EventKind = iota
+
+	// EventConnect is emitted after every attempt to open a connection
+	// with the inner driver.
+	EventConnect
+
+	// EventRotate is emitted when the provider resolves a master DSN into
+	// an inner DSN that differs from the one previously resolved.
+	EventRotate
+
+	// EventFallback is emitted when a connection could only be opened with
+	// one of the fallback versions of the secret. See DSNInfo.
+	EventFallback
+
+	// EventPolicy is emitted when a credential violates the rotation
+	// policy, or a DSN is rejected. See RotationPolicy and DSNPolicy.
+	EventPolicy
+
+	// EventIdentity is emitted when the identity that the driver connects
+	// as changes unexpectedly, or connections reach a different server.
+	// See IdentityGuard and WithServerIdentity.
+	EventIdentity
+
+	// EventFailover is emitted when a connection is opened to a candidate
+	// other than the preferred one. See MultiDSNProvider.
+	EventFailover
+
+	// EventEvict is emitted when health probes for a candidate fail, and
+	// it's evicted. Err holds the error from the last probe. See
+	// HealthProbe.
+	EventEvict
+
+	// EventRecover is emitted when a candidate that was evicted passes a
+	// health probe again.
+	EventRecover
+
+	// EventValidate is emitted after pinging the first connection opened
+	// with new credentials. See WithRotationPing.
+	EventValidate
+
+	// EventRetire is emitted when a connection is discarded because its
+	// credentials are past their revocation point. See
+	// WithRevocationGrace.
+	EventRetire
+
+	// EventDryRun is emitted after trying credentials staged for the next
+	// rotation. See WithDryRun.
+	EventDryRun
+)
+
+// String returns a short, lowercase name for the kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventFetch:
+		return "fetch"
+	case EventConnect:
+		return "connect"
+	case EventRotate:
+		return "rotate"
+	case EventFallback:
+		return "fallback"
+	case EventPolicy:
+		return "policy"
+	case EventIdentity:
+		return "identity"
+	case EventFailover:
+		return "failover"
+	case EventEvict:
+		return "evict"
+	case EventRecover:
+		return "recover"
+	case EventValidate:
+		return "validate"
+	case EventRetire:
+		return "retire"
+	case EventDryRun:
+		return "dryrun"
+	}
+
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (k EventKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// An Event describes something that happened inside the driver. Events are
+// delivered to the Observer configured with WithObserver, if any. Err is nil
+// and Class is ClassNone for successful operations. Alias is the name that the
+// driver was registered with, if any (see Register).
+//
+// Events about a given credential (rotations, fallbacks, policy violations,
+// identity changes, evictions and recoveries) also describe it, with a
+// redacted view of the inner DSN and the secret version, if known; they're
+// left empty otherwise.
+type Event struct {
+	Kind    EventKind
+	Class   ErrorClass
+	Err     error
+	Time    time.Time
+	Alias   string
+	DSN     DSNView
+	Version string
+}
+
+// An Observer receives events from the driver. Observers are called
+// synchronously from the goroutine opening the connection, so they should
+// return quickly and must be safe for concurrent use.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc allows using a plain function as an Observer.
+type ObserverFunc func(Event)
+
+// Observe exercises the original function.
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observers returns an Observer that hands every event to each of observers,
+// in order, for drivers to feed several of them; e.g., metrics and webhooks.
+// Nil observers are skipped.
+func Observers(observers ...Observer) Observer {
+	return multiObserver(slices.DeleteFunc(slices.Clone(observers), func(o Observer) bool {
+		return o == nil
+	}))
+}
+
+// multiObserver is the Observer returned by Observers.
+type multiObserver []Observer
+
+// Observe hands e to every observer.
+func (m multiObserver) Observe(e Event) {
+	for _, o := range m {
+		o.Observe(e)
+	}
+}
+
+// emit records the outcome of an operation in the driver's counters and
+// forwards it to the observer, if one is configured.
+func (d *Driver) emit(kind EventKind, class ErrorClass, err error) {
+	d.stats.record(kind, class)
+
+	if d.observer != nil {
+		d.observer.Observe(Event{
+			Kind:  kind,
+			Class: class,
+			Err:   err,
+			Time:  d.clock.Now(),
+			Alias: d.alias,
+		})
+	}
+}
+
+// emitFor is like emit, for events about the credential in info.
+func (d *Driver) emitFor(info DSNInfo, kind EventKind, class ErrorClass, err error) {
+	d.stats.record(kind, class)
+
+	if d.observer != nil {
+		d.observer.Observe(Event{
+			Kind:    kind,
+			Class:   class,
+			Err:     err,
+			Time:    d.clock.Now(),
+			Alias:   d.alias,
+			DSN:     ParseDSN(info.DSN),
+			Version: info.Version,
+		})
+	}
+}
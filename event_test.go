@@ -0,0 +1,33 @@
+package lazydsn_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestObservers checks that every observer combined gets every event.
+func TestObservers(t *testing.T) {
+	var first, second []lazydsn.EventKind
+
+	observer := lazydsn.Observers(
+		lazydsn.ObserverFunc(func(e lazydsn.Event) { first = append(first, e.Kind) }),
+		nil,
+		lazydsn.ObserverFunc(func(e lazydsn.Event) { second = append(second, e.Kind) }),
+	)
+
+	db := sql.OpenDB(lazydsn.NewConnector(lazydsntest.NewDriver(), "master", lazydsntest.NewProvider("db"),
+		lazydsn.WithObserver(observer),
+	))
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first) == 0 || len(first) != len(second) {
+		t.Errorf("got events %v and %v, want the same ones", first, second)
+	}
+}
@@ -0,0 +1,131 @@
+package lazydsn
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultFetchTimeout bounds fetches shared by several callers, unless the
+// one that starts them can wait for longer; see flights.
+const defaultFetchTimeout = 30 * time.Second
+
+// flights coalesces concurrent fetches by key, and keeps their outcomes for
+// reuse. A fetch is shared by everyone asking for the same key meanwhile, so
+// it can't be cut short by whoever happened to start it; it runs on its own,
+// for as long as the deadline of the one that started it, or 30s, whichever
+// is longer. Callers only wait for as long as their own contexts allow.
+// Providers may ignore deadlines, so fetches still going on past theirs are
+// given up on, and a new one is started by the next caller.
+type flights[T any] struct {
+	mu      sync.Mutex
+	entries map[string]*flight[T]
+}
+
+// flight is a fetch, and its outcome; done is closed once it's available.
+type flight[T any] struct {
+	done     chan struct{}
+	value    T
+	err      error
+	fetched  time.Time
+	deadline time.Time
+}
+
+// do returns the outcome of fetch for key, unless there's one younger than
+// maxAge already, or on its way. Outcomes are kept for retain, as told by
+// clock, and dropped as soon as they're available if retain isn't positive;
+// errors are never kept. Expired outcomes, for any key, are dropped on every
+// call.
+func (f *flights[T]) do(ctx context.Context, clock Clock, key string, maxAge, retain time.Duration,
+	fetch func(context.Context) (T, error)) (*flight[T], error) {
+	f.mu.Lock()
+	f.sweep(clock.Now(), retain)
+	e := f.entries[key]
+
+	if e != nil {
+		select {
+		case <-e.done:
+			if e.err != nil || clock.Now().Sub(e.fetched) >= maxAge {
+				e = nil
+			}
+		default:
+			// Deadlines are set by the wall clock; see fetchContext.
+			if time.Now().After(e.deadline) {
+				e = nil
+			}
+		}
+	}
+
+	if e == nil {
+		e = f.start(ctx, clock, key, retain, fetch)
+	}
+
+	f.mu.Unlock()
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return e, nil
+}
+
+// start starts fetching key. The caller must hold the lock.
+func (f *flights[T]) start(ctx context.Context, clock Clock, key string, retain time.Duration,
+	fetch func(context.Context) (T, error)) *flight[T] {
+	timeout := defaultFetchTimeout
+
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(timeout, time.Until(deadline))
+	}
+
+	fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	e := &flight[T]{
+		done:     make(chan struct{}),
+		deadline: time.Now().Add(timeout),
+	}
+
+	if f.entries == nil {
+		f.entries = make(map[string]*flight[T])
+	}
+
+	f.entries[key] = e
+
+	go func() {
+		defer cancel()
+
+		e.value, e.err = fetch(fctx)
+		e.fetched = clock.Now()
+		close(e.done)
+
+		if e.err != nil || retain <= 0 {
+			f.mu.Lock()
+
+			if f.entries[key] == e {
+				delete(f.entries, key)
+			}
+
+			f.mu.Unlock()
+		}
+	}()
+
+	return e
+}
+
+// sweep drops the outcomes older than retain. The caller must hold the lock.
+func (f *flights[T]) sweep(now time.Time, retain time.Duration) {
+	for key, e := range f.entries {
+		select {
+		case <-e.done:
+			if now.Sub(e.fetched) >= retain {
+				delete(f.entries, key)
+			}
+		default:
+		}
+	}
+}
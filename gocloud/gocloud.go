@@ -0,0 +1,190 @@
+/*
+Package gocloud implements a lazydsn provider backed by gocloud.dev runtime
+variables, so that any backend with a runtimevar driver (AWS Secrets Manager
+and Parameter Store, GCP Secret Manager and Runtime Configurator, etcd, local
+files, or a constant, among others) works through a single adapter. The
+master DSN is the variable's URL, and its value is the inner DSN:
+
+	import (
+		_ "gocloud.dev/runtimevar/awssecretsmanager"
+		_ "gocloud.dev/runtimevar/filevar"
+	)
+
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(), gocloud.New())
+
+	db, err := sql.Open("lazydsn:pgx", "awssecretsmanager://app-db?region=us-east-1&decoder=string")
+
+Variables are opened the first time each master DSN is fetched, and watched
+in the background from then on, as runtimevar does, so that fetches are
+served from memory and see changes as soon as the backend pushes them (or as
+soon as they're polled, for backends that can't push). That's why there's no
+point in reusing resolutions with lazydsn.WithRefreshInterval here.
+Variables are closed along with the last database using the provider; see
+lazydsn.Starter.
+
+Values must be decoded as strings or bytes (e.g., with "decoder=string" in the
+URL), unless a format is given with WithFormat. Decoders that decrypt values
+with gocloud.dev/secrets keepers work as usual.
+*/
+package gocloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gkristic/lazydsn"
+	"gocloud.dev/runtimevar"
+)
+
+// A Format builds the inner DSN out of the value of the variable for the
+// master DSN; e.g., to assemble it from the fields of a JSON decoded secret.
+type Format func(masterDSN string, value any) (string, error)
+
+// An OpenFunc opens the variable at a URL. runtimevar.OpenVariable is used by
+// default.
+type OpenFunc func(ctx context.Context, url string) (*runtimevar.Variable, error)
+
+// Provider resolves DSNs from runtime variables. It implements
+// lazydsn.InfoDSNProvider, and io.Closer.
+type Provider struct {
+	open   OpenFunc
+	format Format
+
+	mu   sync.Mutex
+	vars map[string]*runtimevar.Variable
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithFormat sets how inner DSNs are built out of variable values, instead of
+// taking string (or bytes) values as they are.
+func WithFormat(f Format) Option {
+	return func(p *Provider) {
+		p.format = f
+	}
+}
+
+// WithOpener sets how variables are opened; e.g., with a URL mux other than
+// the default one, or with a constructor from one of the runtimevar drivers.
+func WithOpener(open OpenFunc) Option {
+	return func(p *Provider) {
+		p.open = open
+	}
+}
+
+// New creates a provider.
+func New(opts ...Option) *Provider {
+	p := &Provider{
+		open:   runtimevar.OpenVariable,
+		format: raw,
+		vars:   make(map[string]*runtimevar.Variable),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// FetchDSN implements the lazydsn.DSNProvider interface.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the lazydsn.FullDSNProvider interface.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo returns the latest value of the variable for dsn, as the
+// inner DSN. The time that the value was last updated is reported as issued,
+// so that its age can be checked against the rotation policy; see
+// lazydsn.RotationPolicy. The first fetch for every variable waits until it
+// gets a value, or ctx is done.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	v, err := p.variable(ctx, dsn)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	snap, err := v.Latest(ctx)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	inner, err := p.format(dsn, snap.Value)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	return lazydsn.DSNInfo{
+		DSN:    inner,
+		Issued: snap.UpdateTime,
+	}, nil
+}
+
+// variable returns the variable for dsn, opening it if needed.
+func (p *Provider) variable(ctx context.Context, dsn string) (*runtimevar.Variable, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, ok := p.vars[dsn]; ok {
+		return v, nil
+	}
+
+	v, err := p.open(ctx, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.vars[dsn] = v
+
+	return v, nil
+}
+
+// Close closes every variable opened. Those needed afterwards, if any, are
+// opened again.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+
+	for dsn, v := range p.vars {
+		errs = append(errs, v.Close())
+		delete(p.vars, dsn)
+	}
+
+	return errors.Join(errs...)
+}
+
+// raw takes string and bytes values as they are.
+func raw(_ string, value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+
+	return "", fmt.Errorf("gocloud: can't use a value of type %T as a DSN; decode it as a string, or set a format", value)
+}
+
+// Provider implements the lazydsn.InfoDSNProvider, lazydsn.FullDSNProvider
+// and io.Closer interfaces.
+var (
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.FullDSNProvider = &Provider{}
+	_ io.Closer               = &Provider{}
+)
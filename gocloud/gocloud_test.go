@@ -0,0 +1,160 @@
+package gocloud_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gkristic/lazydsn/gocloud"
+	"gocloud.dev/runtimevar"
+	"gocloud.dev/runtimevar/constantvar"
+)
+
+// opener opens constant variables holding the values given, by URL, counting
+// how many times each was opened.
+type opener struct {
+	values map[string]any
+
+	mu    sync.Mutex
+	opens map[string]int
+}
+
+func (o *opener) open(_ context.Context, url string) (*runtimevar.Variable, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.opens == nil {
+		o.opens = make(map[string]int)
+	}
+
+	o.opens[url]++
+
+	value, ok := o.values[url]
+
+	if !ok {
+		return nil, errors.New("no such variable")
+	}
+
+	return constantvar.New(value), nil
+}
+
+// TestFetch checks that values are taken as inner DSNs, as strings or bytes,
+// or through a format.
+func TestFetch(t *testing.T) {
+	type creds struct {
+		User     string
+		Password string
+	}
+
+	format := func(_ string, value any) (string, error) {
+		c := value.(creds)
+
+		return "postgres://" + c.User + ":" + c.Password + "@db:5432/app", nil
+	}
+
+	tests := []struct {
+		name  string
+		url   string
+		opts  []gocloud.Option
+		want  string
+		fails bool
+	}{
+		{
+			name: "default opener",
+			url:  "constant://?val=postgres://u:p@db:5432/app&decoder=string",
+			want: "postgres://u:p@db:5432/app",
+		},
+		{
+			name: "string",
+			url:  "string",
+			want: "postgres://u:p@db:5432/app",
+		},
+		{
+			name: "bytes",
+			url:  "bytes",
+			want: "postgres://u:p@db:5432/app",
+		},
+		{
+			name:  "not a string",
+			url:   "struct",
+			fails: true,
+		},
+		{
+			name: "format",
+			url:  "struct",
+			opts: []gocloud.Option{gocloud.WithFormat(format)},
+			want: "postgres://app:s3cr3t@db:5432/app",
+		},
+		{
+			name:  "missing",
+			url:   "missing",
+			fails: true,
+		},
+	}
+
+	o := &opener{values: map[string]any{
+		"string": "postgres://u:p@db:5432/app",
+		"bytes":  []byte("postgres://u:p@db:5432/app"),
+		"struct": creds{User: "app", Password: "s3cr3t"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+
+			if tt.name != "default opener" {
+				opts = append(opts, gocloud.WithOpener(o.open))
+			}
+
+			p := gocloud.New(opts...)
+			defer p.Close()
+
+			info, err := p.FetchDSNInfo(context.Background(), tt.url)
+
+			if (err != nil) != tt.fails {
+				t.Fatalf("got error %v, want failure %v", err, tt.fails)
+			}
+
+			if info.DSN != tt.want {
+				t.Errorf("got %q, want %q", info.DSN, tt.want)
+			}
+
+			if !tt.fails && info.Issued.IsZero() {
+				t.Error("got no issue time")
+			}
+		})
+	}
+}
+
+// TestReopen checks that variables are opened once, and again after Close.
+func TestReopen(t *testing.T) {
+	o := &opener{values: map[string]any{"string": "postgres://u:p@db:5432/app"}}
+	p := gocloud.New(gocloud.WithOpener(o.open))
+
+	for range 3 {
+		if _, err := p.FetchDSN("string"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dsn, err := p.FetchDSN("string")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer p.Close()
+
+	if dsn != "postgres://u:p@db:5432/app" {
+		t.Errorf("got %q after Close, want the value", dsn)
+	}
+
+	if n := o.opens["string"]; n != 2 {
+		t.Errorf("got %d opens, want 2", n)
+	}
+}
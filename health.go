@@ -0,0 +1,216 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultProbeTimeout bounds every health probe, unless configured otherwise.
+const defaultProbeTimeout = 2 * time.Second
+
+// HealthProbe configures background health checks for the candidates returned
+// by a MultiDSNProvider. Every Interval, a connection is opened to each
+// candidate and pinged, within Timeout (2s if zero). Endpoints that fail
+// Threshold probes in a row (one if zero) are evicted; i.e., they're only
+// tried when no other candidate is left, just like those in their cool-down
+// period. A single successful probe brings them back. Eviction and recovery
+// are reported with EventEvict and EventRecover.
+//
+// Probes run for as long as a database opened with the driver is open,
+// starting with the first connection. They go for the same candidates that
+// connections do: those resolved last, while they can be reused (see
+// WithRefreshInterval), and fetched again otherwise, within the fetch budget
+// and subject to the same checks.
+type HealthProbe struct {
+	Interval  time.Duration
+	Timeout   time.Duration
+	Threshold int
+}
+
+// proberState keeps track of background goroutines, like those probing
+// candidates, by master DSN.
+type proberState struct {
+	mu    sync.Mutex
+	stops map[string]chan struct{}
+}
+
+// startProbes starts probing the candidates for dsn, unless that's already
+// going on.
+func (d *Driver) startProbes(dsn string) {
+	if d.probe == nil || d.probe.Interval <= 0 || d.mdsnp == nil {
+		return
+	}
+
+	fp := d.fingerprint(dsn)
+
+	if stop, ok := d.probers.start(fp); ok {
+		go d.probeLoop(dsn, fp+"\x00", stop)
+	}
+}
+
+// stopProbes stops probing the candidates for dsn.
+func (d *Driver) stopProbes(dsn string) {
+	d.probers.stop(d.fingerprint(dsn))
+}
+
+// start registers a goroutine for the master DSN with fingerprint fp,
+// returning the channel that stops it. It returns false if there's one
+// already.
+func (s *proberState) start(fp string) (chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.stops[fp]; ok {
+		return nil, false
+	}
+
+	if s.stops == nil {
+		s.stops = make(map[string]chan struct{})
+	}
+
+	stop := make(chan struct{})
+	s.stops[fp] = stop
+
+	return stop, true
+}
+
+// stop stops the goroutine for the master DSN with fingerprint fp, if any.
+func (s *proberState) stop(fp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stop, ok := s.stops[fp]; ok {
+		close(stop)
+		delete(s.stops, fp)
+	}
+}
+
+// probeLoop probes the candidates for dsn every interval, until stopped.
+func (d *Driver) probeLoop(dsn, prefix string, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-d.clock.After(d.probe.Interval):
+		}
+
+		d.probeRound(dsn, prefix)
+	}
+}
+
+// probeRound probes every candidate for dsn once, concurrently.
+func (d *Driver) probeRound(dsn, prefix string) {
+	timeout := d.probe.Timeout
+
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fctx, fcancel := d.fetchContext(ctx)
+	infos, err := d.probeCandidates(fctx, dsn)
+	fcancel()
+
+	if err != nil {
+		// The provider's health is none of our business here; it's
+		// accounted for when connecting.
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, info := range infos {
+		wg.Add(1)
+
+		go func(info DSNInfo) {
+			defer wg.Done()
+
+			err := redact(d.probeOne(ctx, info), info.DSN)
+
+			switch d.endpoints.probed(prefix+info.Endpoint, info.Endpoint, err, d.probe.Threshold) {
+			case EventEvict:
+				d.emitFor(info, EventEvict, d.classify(err), err)
+				d.prewarmEvicted(prefix, info, infos)
+			case EventRecover:
+				d.emitFor(info, EventRecover, ClassNone, nil)
+			}
+		}(info)
+	}
+
+	wg.Wait()
+}
+
+// probeCandidates returns the candidates to probe for dsn. That's the
+// resolution shared by the driver's connectors, if it can still be used, or a
+// new one otherwise, shared from then on. Either way, candidates went through
+// admit and the rotation policy, like those connected to.
+func (d *Driver) probeCandidates(ctx context.Context, dsn string) ([]DSNInfo, error) {
+	if d.refresh <= 0 {
+		return d.fetch(ctx, dsn)
+	}
+
+	res, err := d.resolution(ctx, dsn, d.versions.generation.Load(), d.refresh)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return unseal(res.value), nil
+}
+
+// probeOne opens a connection to info and pings it, if the inner driver
+// supports that. Inner drivers don't always honor contexts, so we stop
+// waiting when ctx is done, and leave the connection to be closed whenever
+// it's open.
+func (d *Driver) probeOne(ctx context.Context, info DSNInfo) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- d.guard(info.DSN, func() error {
+			var (
+				conn driver.Conn
+				err  error
+			)
+
+			if _, ok := d.Driver.(driver.DriverContext); ok || info.Connector != nil {
+				var connector driver.Connector
+
+				if connector, err = d.innerConnector(info); err != nil {
+					return err
+				}
+
+				if closer, ok := connector.(io.Closer); ok {
+					defer closer.Close()
+				}
+
+				conn, err = connector.Connect(ctx)
+			} else {
+				conn, err = d.Driver.Open(info.DSN)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			defer conn.Close()
+
+			if pinger, ok := conn.(driver.Pinger); ok {
+				return pinger.Ping(ctx)
+			}
+
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,91 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// multiProvider always returns the same candidates, counting fetches.
+type multiProvider struct {
+	dsns    []string
+	fetches atomic.Int32
+}
+
+func (p *multiProvider) FetchDSN(string) (string, error) {
+	return p.dsns[0], nil
+}
+
+func (p *multiProvider) FetchDSNs(context.Context, string) ([]lazydsn.DSNInfo, error) {
+	p.fetches.Add(1)
+	infos := make([]lazydsn.DSNInfo, len(p.dsns))
+
+	for i, dsn := range p.dsns {
+		infos[i].DSN = dsn
+	}
+
+	return infos, nil
+}
+
+// TestProbeCandidates checks that health probes reuse the candidates that
+// connections resolved, rather than querying the provider on every round,
+// and that candidates refused by the DSN policy are never probed.
+func TestProbeCandidates(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := &multiProvider{
+		dsns: []string{"postgres://u:p@a:5432/app", "postgres://u:p@b:5432/app", "postgres://u:p@evil:5432/app"},
+	}
+
+	inner := lazydsntest.NewDriver()
+	connector, err := lazydsn.New(inner, p,
+		lazydsn.WithClock(clock),
+		lazydsn.WithRefreshInterval(time.Minute),
+		lazydsn.WithHealthProbe(lazydsn.HealthProbe{Interval: time.Second}),
+		lazydsn.WithDSNPolicy(lazydsn.DSNPolicyFunc(func(v lazydsn.DSNView) error {
+			if v.Host == "evil:5432" {
+				return errors.New("not on the list")
+			}
+
+			return nil
+		})),
+	).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	for round := 1; round <= 3; round++ {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		clock.Advance(time.Second)
+
+		// One connection, and two probes per round.
+		for len(inner.Attempts()) < 1+2*round {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if slices.Contains(inner.Attempts(), p.dsns[2]) {
+		t.Errorf("got a candidate refused by the policy probed: %v", inner.Attempts())
+	}
+
+	if n := p.fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
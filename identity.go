@@ -0,0 +1,78 @@
+package lazydsn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrIdentityChanged is returned, wrapped with details, when the identity
+// guard blocks a switch to a different identity.
+var ErrIdentityChanged = errors.New("lazydsn: resolved identity changed")
+
+// IdentityGuard watches for changes in the identity that the driver connects
+// as, across rotations. An identity is the user, host and database found in
+// the inner DSN, as returned by DSNView.String (user@host/database).
+// Switching to a user with different privileges, or to a different database
+// altogether, is most likely a mistake (see the package documentation). Every
+// unexpected change is reported with an EventIdentity event.
+//
+// Changes to identities in Allow are expected, and not reported. If Block is
+// set, switching to any other identity is refused, and new connections fail
+// until the provider goes back to an acceptable one. That gives three modes:
+// report every change (the zero value), report unexpected changes only (just
+// Allow), or require an allow list (both Allow and Block).
+//
+// When the provider returns several candidate DSNs (see MultiDSNProvider),
+// identities are tracked per endpoint.
+type IdentityGuard struct {
+	Allow []string
+	Block bool
+}
+
+// identityState keeps the accepted identity for every master DSN.
+type identityState struct {
+	mu       sync.Mutex
+	accepted map[string]string
+}
+
+// checkIdentity compares the identity in info against the one accepted so
+// far for dsn. The error returned, if any, means that the change was blocked.
+func (d *Driver) checkIdentity(dsn string, info DSNInfo) error {
+	if d.identity == nil {
+		return nil
+	}
+
+	s := &d.identities
+	key, identity := d.fingerprint(dsn)+"\x00"+info.Endpoint, ParseDSN(info.DSN).String()
+	s.mu.Lock()
+
+	if s.accepted == nil {
+		s.accepted = make(map[string]string)
+	}
+
+	prev, seen := s.accepted[key]
+
+	if !seen || prev == identity || slices.Contains(d.identity.Allow, identity) {
+		s.accepted[key] = identity
+		s.mu.Unlock()
+
+		return nil
+	}
+
+	if !d.identity.Block {
+		s.accepted[key] = identity
+	}
+
+	s.mu.Unlock()
+
+	err := fmt.Errorf("%w from %s to %s", ErrIdentityChanged, prev, identity)
+	d.emitFor(info, EventIdentity, ClassProvider, err)
+
+	if d.identity.Block {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+//go:build integration
+
+// Package integration_test checks the core promise of lazydsn against real
+// databases: a pool keeps serving queries while the password it connects with
+// is rotated under its feet. Databases run in Docker containers, so these
+// tests are opt-in:
+//
+//	go test -tags integration ./integration
+package integration_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/ory/dockertest/v3"
+)
+
+// engine describes how to run a database and rotate passwords in it.
+type engine struct {
+	repository string
+	tag        string
+	env        []string
+	port       string
+	driver     driver.Driver
+
+	// dsn builds the DSN for user at the given host:port address.
+	dsn func(addr, user, password string) string
+
+	admin    string
+	password string
+
+	// Statements run as the administrator, where {user} and {password}
+	// are replaced for the application's user and its new password.
+	setup  []string
+	rotate string
+}
+
+var (
+	mysqlEngine = engine{
+		repository: "mysql",
+		tag:        "8.0",
+		env:        []string{"MYSQL_ROOT_PASSWORD=secret", "MYSQL_DATABASE=test"},
+		port:       "3306/tcp",
+		driver:     &mysql.MySQLDriver{},
+		dsn: func(addr, user, password string) string {
+			return fmt.Sprintf("%s:%s@tcp(%s)/test", user, password, addr)
+		},
+		admin:    "root",
+		password: "secret",
+		setup: []string{
+			"CREATE USER '{user}'@'%' IDENTIFIED BY '{password}'",
+			"GRANT ALL ON test.* TO '{user}'@'%'",
+		},
+		rotate: "ALTER USER '{user}'@'%' IDENTIFIED BY '{password}'",
+	}
+
+	postgresEngine = engine{
+		repository: "postgres",
+		tag:        "16",
+		env:        []string{"POSTGRES_PASSWORD=secret", "POSTGRES_DB=test"},
+		port:       "5432/tcp",
+		driver:     &pq.Driver{},
+		dsn: func(addr, user, password string) string {
+			return fmt.Sprintf("postgres://%s:%s@%s/test?sslmode=disable", user, password, addr)
+		},
+		admin:    "postgres",
+		password: "secret",
+		setup: []string{
+			"CREATE ROLE {user} LOGIN PASSWORD '{password}'",
+		},
+		rotate: "ALTER ROLE {user} PASSWORD '{password}'",
+	}
+)
+
+func TestMySQLRotation(t *testing.T) {
+	testRotation(t, mysqlEngine)
+}
+
+func TestPostgresRotation(t *testing.T) {
+	testRotation(t, postgresEngine)
+}
+
+// users are the application's users. Passwords are rotated with the
+// alternating users strategy: the one not in use gets a new password, and
+// then the secret is updated to point to it. Connections still open with the
+// other one are unaffected, and there's no window where the secret and the
+// database disagree.
+var users = []string{"app_a", "app_b"}
+
+// testRotation starts a container for e, has a pool query it non-stop through
+// lazydsn, and rotates the application's password a few times meanwhile. Not
+// a single query may fail.
+func testRotation(t *testing.T, e engine) {
+	addr := start(t, e)
+	admin := sql.OpenDB(connector(t, e.driver, lazydsntest.NewProvider(e.dsn(addr, e.admin, e.password))))
+	defer admin.Close()
+
+	for _, user := range users {
+		for _, stmt := range e.setup {
+			exec(t, admin, stmt, user, "pass-0")
+		}
+	}
+
+	provider := lazydsntest.NewProvider(e.dsn(addr, users[0], "pass-0"))
+	db := sql.OpenDB(connector(t, e.driver, provider))
+	defer db.Close()
+
+	// Connections must be renewed often for the test to mean anything.
+	db.SetConnMaxLifetime(500 * time.Millisecond)
+	db.SetMaxOpenConns(4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		queries atomic.Int64
+		failed  atomic.Int64
+	)
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				var one int
+
+				if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil && ctx.Err() == nil {
+					failed.Add(1)
+					t.Errorf("query failed: %v", err)
+				}
+
+				queries.Add(1)
+			}
+		}()
+	}
+
+	for i := 1; i <= 3; i++ {
+		time.Sleep(time.Second)
+
+		user, password := users[i%len(users)], fmt.Sprintf("pass-%d", i)
+		exec(t, admin, e.rotate, user, password)
+		provider.Set(e.dsn(addr, user, password))
+	}
+
+	time.Sleep(time.Second)
+	cancel()
+	wg.Wait()
+
+	t.Logf("%d queries, %d failed", queries.Load(), failed.Load())
+
+	if queries.Load() == 0 {
+		t.Fatal("no queries were run")
+	}
+}
+
+// start runs a container for e and returns the address it listens at, once
+// the database accepts connections. The container is purged when the test
+// ends.
+func start(t *testing.T, e engine) string {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+
+	if err != nil {
+		t.Skipf("docker not available: %v", err)
+	}
+
+	if err := pool.Client.Ping(); err != nil {
+		t.Skipf("docker not available: %v", err)
+	}
+
+	pool.MaxWait = 2 * time.Minute
+	resource, err := pool.Run(e.repository, e.tag, e.env)
+
+	if err != nil {
+		t.Fatalf("could not start %s: %v", e.repository, err)
+	}
+
+	t.Cleanup(func() {
+		if err := pool.Purge(resource); err != nil {
+			t.Logf("could not purge %s: %v", e.repository, err)
+		}
+	})
+
+	addr := resource.GetHostPort(e.port)
+	err = pool.Retry(func() error {
+		db := sql.OpenDB(connector(t, e.driver, lazydsntest.NewProvider(e.dsn(addr, e.admin, e.password))))
+		defer db.Close()
+
+		return db.Ping()
+	})
+
+	if err != nil {
+		t.Fatalf("%s didn't come up: %v", e.repository, err)
+	}
+
+	return addr
+}
+
+// connector creates a lazydsn connector for inner, resolving through p.
+func connector(t *testing.T, inner driver.Driver, p lazydsn.DSNProvider) driver.Connector {
+	t.Helper()
+
+	c, err := lazydsn.New(inner, p).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// exec runs the administrative statement stmt, for the given user and
+// password.
+func exec(t *testing.T, db *sql.DB, stmt, user, password string) {
+	t.Helper()
+
+	stmt = strings.NewReplacer("{user}", user, "{password}", password).Replace(stmt)
+
+	if _, err := db.Exec(stmt); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,138 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+// instrumented wraps a driver the way instrumentation libraries do: it counts
+// connections, and only implements driver.DriverContext if the wrapped driver
+// does. Its connectors forward io.Closer, and it can be unwrapped.
+type instrumented struct {
+	driver.Driver
+	connects *atomic.Int64
+}
+
+type instrumentedContext struct {
+	instrumented
+}
+
+func instrument(d driver.Driver, connects *atomic.Int64) driver.Driver {
+	w := instrumented{d, connects}
+
+	if _, ok := d.(driver.DriverContext); ok {
+		return instrumentedContext{w}
+	}
+
+	return w
+}
+
+func (w instrumented) Open(dsn string) (driver.Conn, error) {
+	w.connects.Add(1)
+	return w.Driver.Open(dsn)
+}
+
+func (w instrumented) Unwrap() driver.Driver {
+	return w.Driver
+}
+
+func (w instrumentedContext) OpenConnector(dsn string) (driver.Connector, error) {
+	c, err := w.Driver.(driver.DriverContext).OpenConnector(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return instrumentedConnector{c, w}, nil
+}
+
+type instrumentedConnector struct {
+	driver.Connector
+	w instrumentedContext
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.w.connects.Add(1)
+	return c.Connector.Connect(ctx)
+}
+
+func (c instrumentedConnector) Driver() driver.Driver {
+	return c.w
+}
+
+func (c instrumentedConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// openConnector tells whether the inner driver was asked for connectors.
+type openConnector struct {
+	nopContextDriver
+	calls *atomic.Int64
+}
+
+func (d openConnector) OpenConnector(dsn string) (driver.Connector, error) {
+	d.calls.Add(1)
+	return d.nopContextDriver.OpenConnector(dsn)
+}
+
+// TestInstrumentationInterop stacks a Driver and an instrumentation wrapper
+// in either order, checking that connectors are used all the way down and
+// that the Driver can still be found from the database.
+func TestInstrumentationInterop(t *testing.T) {
+	p := DSNProviderFunc(func(string) (string, error) {
+		return innerDSN, nil
+	})
+
+	for _, inside := range []bool{true, false} {
+		var connects, openConnectors atomic.Int64
+		inner := driver.Driver(openConnector{calls: &openConnectors})
+		var top driver.Driver
+
+		if inside {
+			top = New(instrument(inner, &connects), p)
+		} else {
+			top = instrument(New(inner, p), &connects)
+		}
+
+		connector, err := top.(driver.DriverContext).OpenConnector("master")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(connector)
+		db.SetMaxIdleConns(0)
+
+		for range 3 {
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if n := connects.Load(); n != 3 {
+			t.Errorf("inside=%v: instrumentation saw %d connections, want 3", inside, n)
+		}
+
+		if n := openConnectors.Load(); n != 1 {
+			t.Errorf("inside=%v: inner driver built %d connectors, want 1", inside, n)
+		}
+
+		if _, ok := DriverOf(db); !ok {
+			t.Errorf("inside=%v: driver not found", inside)
+		}
+
+		if _, ok := PoolStatsOf(db); !ok {
+			t.Errorf("inside=%v: stats not found", inside)
+		}
+
+		db.Close()
+	}
+}
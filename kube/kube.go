@@ -0,0 +1,185 @@
+/*
+Package kube implements a lazydsn provider that discovers database pods
+through the EndpointSlices of a Kubernetes Service. That's useful for in-cluster
+databases whose endpoints move between pods, as with PostgreSQL operators that
+promote a different pod to primary on failover, and keep a Service pointing at
+whichever pod is primary at the time. Credentials come from another provider
+(e.g., one reading a Kubernetes Secret), whose DSN is pointed to the ready pods:
+
+	client, err := kubernetes.NewForConfig(cfg)
+	...
+	locator := kube.NewLocator(client, "db", "cluster-rw", kube.WithPort("postgres"))
+	defer locator.Stop()
+
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(),
+		lazydsn.NewDiscoveryProvider(creds, locator),
+	)
+
+Slices are watched in the background, starting with the first fetch, so that
+resolving addresses doesn't take a round trip to the API server for every
+connection. Every ready pod becomes a candidate DSN (see
+lazydsn.DiscoveryProvider).
+*/
+package kube
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// errNotSynced is returned when endpoint slices can't be listed before the
+// context for a fetch is done.
+var errNotSynced = errors.New("kube: endpoint slices not synced yet")
+
+// Locator finds the ready pods behind a Service, by watching its
+// EndpointSlices. It implements lazydsn.Locator.
+type Locator struct {
+	client    kubernetes.Interface
+	namespace string
+	service   string
+	port      string
+	resync    time.Duration
+
+	once     sync.Once
+	stopOnce sync.Once
+	stop     chan struct{}
+	lister   discoverylisters.EndpointSliceNamespaceLister
+	synced   cache.InformerSynced
+}
+
+// An Option configures optional behavior for a Locator.
+type Option func(*Locator)
+
+// WithPort selects the Service port, by name, that DSNs should point to. It's
+// only required if the Service has more than one port.
+func WithPort(name string) Option {
+	return func(l *Locator) {
+		l.port = name
+	}
+}
+
+// WithResync sets how often the watched slices are fully listed again. Zero,
+// the default, disables resyncs.
+func WithResync(d time.Duration) Option {
+	return func(l *Locator) {
+		l.resync = d
+	}
+}
+
+// NewLocator creates a locator for the given Service.
+func NewLocator(client kubernetes.Interface, namespace, service string, opts ...Option) *Locator {
+	l := &Locator{
+		client:    client,
+		namespace: namespace,
+		service:   service,
+		stop:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// New creates a provider that finds the pods behind the given Service, and
+// fetches credentials from creds. Note that there's no way to stop watching
+// the Service this way; use NewLocator and lazydsn.NewDiscoveryProvider if you
+// need that.
+func New(client kubernetes.Interface, creds lazydsn.DSNProvider, namespace, service string,
+	opts ...Option) *lazydsn.DiscoveryProvider {
+	return lazydsn.NewDiscoveryProvider(creds, NewLocator(client, namespace, service, opts...))
+}
+
+// start starts watching the slices for the Service.
+func (l *Locator) start() {
+	factory := informers.NewSharedInformerFactoryWithOptions(l.client, l.resync,
+		informers.WithNamespace(l.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = discoveryv1.LabelServiceName + "=" + l.service
+		}),
+	)
+
+	informer := factory.Discovery().V1().EndpointSlices()
+	l.lister = informer.Lister().EndpointSlices(l.namespace)
+	l.synced = informer.Informer().HasSynced
+	factory.Start(l.stop)
+}
+
+// Locate returns the addresses of the ready pods behind the Service, sorted.
+// The first call starts watching the Service, and waits until slices are
+// listed or ctx is done.
+func (l *Locator) Locate(ctx context.Context) ([]string, error) {
+	l.once.Do(l.start)
+
+	if !l.synced() && !cache.WaitForCacheSync(ctx.Done(), l.synced) {
+		return nil, errNotSynced
+	}
+
+	slices, err := l.lister.List(labels.Everything())
+
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+
+	for _, slice := range slices {
+		port, ok := l.portOf(slice)
+
+		if !ok {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if len(ep.Addresses) == 0 || (ep.Conditions.Ready != nil && !*ep.Conditions.Ready) {
+				continue
+			}
+
+			addrs = append(addrs, net.JoinHostPort(ep.Addresses[0], port))
+		}
+	}
+
+	sort.Strings(addrs)
+
+	return addrs, nil
+}
+
+// portOf returns the port in slice that DSNs should point to.
+func (l *Locator) portOf(slice *discoveryv1.EndpointSlice) (string, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+
+		if (p.Name != nil && *p.Name == l.port) || (l.port == "" && len(slice.Ports) == 1) {
+			return strconv.Itoa(int(*p.Port)), true
+		}
+	}
+
+	return "", false
+}
+
+// Stop stops watching the Service. The locator can't be used after that.
+func (l *Locator) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+// Locator implements the lazydsn.Locator interface.
+var _ lazydsn.Locator = &Locator{}
@@ -0,0 +1,157 @@
+package kube_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn/kube"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// endpointSlice returns a slice for service in namespace "db", with the given
+// ports and endpoints.
+func endpointSlice(name, service string, ports []discoveryv1.EndpointPort, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "db",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       ports,
+		Endpoints:   endpoints,
+	}
+}
+
+// port returns a slice port with the given name.
+func port(name string, number int32) discoveryv1.EndpointPort {
+	return discoveryv1.EndpointPort{Name: &name, Port: &number}
+}
+
+// endpoint returns an endpoint at addr, ready or not.
+func endpoint(addr string, ready bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{addr},
+		Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+	}
+}
+
+// TestLocate checks the addresses found in the slices for a Service.
+func TestLocate(t *testing.T) {
+	single := []discoveryv1.EndpointPort{port("", 5432)}
+	multi := []discoveryv1.EndpointPort{port("postgres", 5432), port("metrics", 9187)}
+
+	tests := []struct {
+		name   string
+		port   string
+		slices []runtime.Object
+		want   []string
+	}{
+		{
+			name: "single port",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.2", true), endpoint("10.0.0.1", true)),
+			},
+			want: []string{"10.0.0.1:5432", "10.0.0.2:5432"},
+		},
+		{
+			name: "not ready",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.1", false), endpoint("10.0.0.2", true)),
+			},
+			want: []string{"10.0.0.2:5432"},
+		},
+		{
+			name: "named port",
+			port: "postgres",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", multi, endpoint("10.0.0.1", true)),
+			},
+			want: []string{"10.0.0.1:5432"},
+		},
+		{
+			name: "ambiguous port",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", multi, endpoint("10.0.0.1", true)),
+			},
+		},
+		{
+			name: "other services",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.1", true)),
+				endpointSlice("ro-1", "cluster-ro", single, endpoint("10.0.0.9", true)),
+			},
+			want: []string{"10.0.0.1:5432"},
+		},
+		{
+			name: "several slices",
+			slices: []runtime.Object{
+				endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.1", true)),
+				endpointSlice("rw-2", "cluster-rw", single, endpoint("10.0.1.1", true)),
+			},
+			want: []string{"10.0.0.1:5432", "10.0.1.1:5432"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := kube.NewLocator(fake.NewClientset(tt.slices...), "db", "cluster-rw", kube.WithPort(tt.port))
+			defer l.Stop()
+
+			got, err := l.Locate(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatch checks that changes to the slices are picked up without waiting
+// for a resync.
+func TestWatch(t *testing.T) {
+	single := []discoveryv1.EndpointPort{port("", 5432)}
+	client := fake.NewClientset(endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.1", true)))
+	l := kube.NewLocator(client, "db", "cluster-rw")
+	defer l.Stop()
+
+	if _, err := l.Locate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failover promotes another pod.
+	promoted := endpointSlice("rw-1", "cluster-rw", single, endpoint("10.0.0.2", true))
+
+	if _, err := client.DiscoveryV1().EndpointSlices("db").Update(context.Background(), promoted, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		got, err := l.Locate(context.Background())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if slices.Equal(got, []string{"10.0.0.2:5432"}) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, want the promoted pod", got)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
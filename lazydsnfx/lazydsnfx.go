@@ -0,0 +1,77 @@
+/*
+Package lazydsnfx wires lazydsn into applications built with Uber's fx. Module
+provides a *sql.DB opened with lazydsn.OpenDB, and the *lazydsn.Driver behind
+it, out of an inner driver and a lazydsn.PoolConfig supplied by the
+application; the database is closed when the application stops:
+
+	fx.New(
+		fx.Supply(
+			fx.Annotate(&mysql.MySQLDriver{}, fx.As(new(driver.Driver))),
+			lazydsn.PoolConfig{
+				DSN:             "arn:...",
+				Provider:        provider,
+				ConnMaxLifetime: time.Hour,
+			},
+		),
+		lazydsnfx.Module,
+		fx.Invoke(func(db *sql.DB) { ... }),
+	)
+
+Applications with several databases can give each its own named instance of
+the inputs and outputs, with fx.Annotate and New.
+*/
+package lazydsnfx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/gkristic/lazydsn"
+	"go.uber.org/fx"
+)
+
+// Module provides a *sql.DB, and the *lazydsn.Driver behind it. See New.
+var Module = fx.Module("lazydsn", fx.Provide(New))
+
+// Params are the inputs to New.
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Driver    driver.Driver
+	Config    lazydsn.PoolConfig
+}
+
+// Result holds the outputs of New.
+type Result struct {
+	fx.Out
+
+	DB     *sql.DB
+	Driver *lazydsn.Driver
+}
+
+// New opens the pool described by p.Config with the inner driver p.Driver,
+// and has it closed when the application stops. Connections aren't opened
+// before the application starts, unless the configuration asks for a warm-up;
+// see lazydsn.PoolConfig.
+func New(p Params) (Result, error) {
+	db, err := lazydsn.OpenDB(p.Driver, p.Config)
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	d, _ := lazydsn.DriverOf(db)
+
+	p.Lifecycle.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return db.Close()
+		},
+	})
+
+	return Result{
+		DB:     db,
+		Driver: d,
+	}, nil
+}
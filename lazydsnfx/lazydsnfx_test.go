@@ -0,0 +1,70 @@
+package lazydsnfx_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsnfx"
+	"github.com/gkristic/lazydsn/lazydsntest"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+// TestModule checks that the database and driver are provided, and that the
+// database is closed when the application stops.
+func TestModule(t *testing.T) {
+	inner := lazydsntest.NewDriver()
+
+	var (
+		db *sql.DB
+		d  *lazydsn.Driver
+	)
+
+	app := fxtest.New(t,
+		fx.Supply(
+			fx.Annotate(inner, fx.As(new(driver.Driver))),
+			lazydsn.PoolConfig{
+				DSN:      "master",
+				Provider: lazydsntest.NewProvider("db"),
+			},
+		),
+		lazydsnfx.Module,
+		fx.Populate(&db, &d),
+	)
+
+	app.RequireStart()
+
+	if d == nil {
+		t.Fatal("got no driver")
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	app.RequireStop()
+
+	if err := db.Ping(); err == nil {
+		t.Error("got the database open after stopping, want it closed")
+	}
+}
+
+// TestModuleError checks that configuration errors stop the application from
+// starting.
+func TestModuleError(t *testing.T) {
+	app := fx.New(
+		fx.NopLogger,
+		fx.Supply(
+			fx.Annotate(lazydsntest.NewDriver(), fx.As(new(driver.Driver))),
+			lazydsn.PoolConfig{DSN: "master"},
+		),
+		lazydsnfx.Module,
+		fx.Invoke(func(*sql.DB) {}),
+	)
+
+	if app.Err() == nil {
+		t.Error("got no error for a pool without a provider")
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Package lazydsnmock lets lazydsn wrap go-sqlmock's driver, so that application
+tests can verify their provider logic end to end, through database/sql. Each
+inner DSN that the provider may resolve to gets its own mock, with its own
+expectations:
+
+	m := lazydsnmock.New()
+	defer m.Close()
+
+	mock, err := m.Expect("user:v1@tcp(db)/app")
+	mock.ExpectExec("UPDATE t").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	connector, err := lazydsn.New(m.Driver(), provider).OpenConnector("master")
+	db := sql.OpenDB(connector)
+	...
+	m.AssertDSNs(t, "user:v1@tcp(db)/app", "user:v2@tcp(db)/app")
+
+Connection attempts for DSNs without a mock fail. Note that go-sqlmock hands
+out the same connection every time a DSN is opened, so expectations for a DSN
+are shared by every connection in the pool that uses it.
+*/
+package lazydsnmock
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// instances makes mocks from different Mocks unique, as go-sqlmock registers
+// them globally, by DSN.
+var instances atomic.Int64
+
+// Mock holds a go-sqlmock mock for every inner DSN that is expected, and the
+// inner driver that lazydsn should wrap to use them. It's safe for
+// concurrent use.
+type Mock struct {
+	prefix    string
+	matcher   sqlmock.QueryMatcher
+	converter driver.ValueConverter
+	inner     driver.Driver
+
+	mu    sync.Mutex
+	mocks map[string]sqlmock.Sqlmock
+	dbs   []*sql.DB
+	dsns  []string
+}
+
+// An Option configures the mocks created by a Mock.
+type Option func(*Mock)
+
+// WithQueryMatcher sets how mocks match queries against expectations; see
+// sqlmock.QueryMatcherOption.
+func WithQueryMatcher(qm sqlmock.QueryMatcher) Option {
+	return func(m *Mock) {
+		m.matcher = qm
+	}
+}
+
+// WithValueConverter sets how mocks convert arguments; see
+// sqlmock.ValueConverterOption.
+func WithValueConverter(c driver.ValueConverter) Option {
+	return func(m *Mock) {
+		m.converter = c
+	}
+}
+
+// New creates a Mock.
+func New(opts ...Option) *Mock {
+	m := &Mock{
+		prefix: fmt.Sprintf("lazydsnmock_%d:", instances.Add(1)),
+		mocks:  make(map[string]sqlmock.Sqlmock),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Expect returns the mock for innerDSN, creating it if needed. Expectations
+// set on it apply to connections opened with innerDSN.
+func (m *Mock) Expect(innerDSN string) (sqlmock.Sqlmock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mock, ok := m.mocks[innerDSN]; ok {
+		return mock, nil
+	}
+
+	// Options can't be kept around; go-sqlmock doesn't export their type.
+	matcher, converter := m.matcher, m.converter
+
+	if matcher == nil {
+		matcher = sqlmock.QueryMatcherRegexp
+	}
+
+	if converter == nil {
+		converter = driver.DefaultParameterConverter
+	}
+
+	db, mock, err := sqlmock.NewWithDSN(m.prefix+innerDSN,
+		sqlmock.QueryMatcherOption(matcher), sqlmock.ValueConverterOption(converter))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.inner == nil {
+		m.inner = db.Driver()
+	}
+
+	m.mocks[innerDSN] = mock
+	m.dbs = append(m.dbs, db)
+
+	return mock, nil
+}
+
+// Driver returns the inner driver to give lazydsn.New or lazydsn.Register.
+func (m *Mock) Driver() driver.Driver {
+	return mockDriver{m}
+}
+
+// DSNs returns the inner DSN used for every connection attempt so far, in
+// order.
+func (m *Mock) DSNs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]string(nil), m.dsns...)
+}
+
+// AssertDSNs checks that the inner DSNs used for connection attempts were,
+// in order, those in want. Consecutive attempts with the same DSN count once,
+// so that tests don't depend on how many connections the pool opened.
+func (m *Mock) AssertDSNs(t testing.TB, want ...string) {
+	t.Helper()
+
+	if got := slices.Compact(m.DSNs()); !slices.Equal(got, want) {
+		t.Errorf("inner DSNs used were %q, want %q", got, want)
+	}
+}
+
+// ExpectationsWereMet checks the expectations of every mock.
+func (m *Mock) ExpectationsWereMet() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+
+	for dsn, mock := range m.mocks {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dsn, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close releases every mock.
+func (m *Mock) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+
+	for _, db := range m.dbs {
+		errs = append(errs, db.Close())
+	}
+
+	m.dbs = nil
+	clear(m.mocks)
+
+	return errors.Join(errs...)
+}
+
+// errNoMock is returned for connection attempts with DSNs that have no mock.
+var errNoMock = errors.New("lazydsnmock: no mock for inner DSN")
+
+// mockDriver records the inner DSN for every connection attempt, and opens
+// its mock.
+type mockDriver struct {
+	m *Mock
+}
+
+// Open opens a connection to the mock for dsn.
+func (d mockDriver) Open(dsn string) (driver.Conn, error) {
+	d.m.mu.Lock()
+	d.m.dsns = append(d.m.dsns, dsn)
+	_, ok := d.m.mocks[dsn]
+	inner := d.m.inner
+	d.m.mu.Unlock()
+
+	if !ok {
+		return nil, errNoMock
+	}
+
+	return inner.Open(d.m.prefix + dsn)
+}
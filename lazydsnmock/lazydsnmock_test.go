@@ -0,0 +1,56 @@
+package lazydsnmock
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gkristic/lazydsn"
+)
+
+func TestRotation(t *testing.T) {
+	m := New()
+	defer m.Close()
+
+	for _, dsn := range []string{"v1", "v2"} {
+		mock, err := m.Expect(dsn)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mock.ExpectExec("UPDATE t").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	current := "v1"
+	provider := lazydsn.DSNProviderFunc(func(string) (string, error) {
+		return current, nil
+	})
+
+	connector, err := lazydsn.New(m.Driver(), provider).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	for _, dsn := range []string{"v1", "v2"} {
+		current = dsn
+
+		// Make the pool open a new connection, as it would once the
+		// previous one expired.
+		db.SetMaxIdleConns(0)
+
+		if _, err := db.Exec("UPDATE t SET x = 1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.AssertDSNs(t, "v1", "v2")
+
+	if err := m.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+package lazydsntest
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// ErrChaos is the error that Chaos providers inject, unless told otherwise.
+var ErrChaos = errors.New("lazydsntest: injected provider failure")
+
+// ChaosConfig tells a Chaos provider how to misbehave. Rates are
+// probabilities, from 0 (never) to 1 (every fetch); the zero value injects
+// nothing at all.
+type ChaosConfig struct {
+	// FailureRate is the share of fetches that fail with Err (ErrChaos, if
+	// nil), without reaching the wrapped provider.
+	FailureRate float64
+	Err         error
+
+	// SpikeRate is the share of fetches that take Spike longer than usual,
+	// or until their context is done.
+	SpikeRate float64
+	Spike     time.Duration
+
+	// StaleRate is the share of fetches answered with the last DSN handed
+	// out for the same master DSN, without asking the wrapped provider; as a
+	// backend serving from a cache that's lagging behind would.
+	StaleRate float64
+
+	// For SkewWindow after the wrapped provider starts answering with a
+	// different DSN, a SkewRate share of fetches get the previous one
+	// instead. That's what a secrets backend with replicas that haven't all
+	// caught up with a rotation looks like: old and new credentials
+	// alternate for a while.
+	SkewRate   float64
+	SkewWindow time.Duration
+
+	// Clock is used for latency spikes and skew windows; the system's, if
+	// nil. Give it a fake Clock to fast-forward through both.
+	Clock lazydsn.Clock
+
+	// Seed seeds the random source, so that failing runs can be reproduced.
+	Seed int64
+}
+
+// Chaos is a provider that wraps another one, injecting failures, latency
+// spikes, stale responses and rotation inconsistencies, as configured. It's
+// meant for checking, in CI, that pool settings and driver options survive a
+// misbehaving secrets backend. It's safe for concurrent use.
+type Chaos struct {
+	provider lazydsn.DSNProvider
+	config   ChaosConfig
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	masters map[string]*chaosState
+}
+
+// chaosState is what a Chaos provider remembers about a master DSN.
+type chaosState struct {
+	last     string
+	current  string
+	previous string
+	changed  time.Time
+}
+
+// NewChaos wraps p with a provider that misbehaves as set in config.
+func NewChaos(p lazydsn.DSNProvider, config ChaosConfig) *Chaos {
+	if config.Err == nil {
+		config.Err = ErrChaos
+	}
+
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	return &Chaos{
+		provider: p,
+		config:   config,
+		rand:     rand.New(rand.NewSource(config.Seed)),
+		masters:  make(map[string]*chaosState),
+	}
+}
+
+// FetchDSN resolves dsn through the wrapped provider, unless chaos gets in
+// the way.
+func (c *Chaos) FetchDSN(dsn string) (string, error) {
+	return c.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves dsn through the wrapped provider, unless chaos
+// gets in the way.
+func (c *Chaos) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	c.mu.Lock()
+	fail, spike, stale, skew := c.roll(c.config.FailureRate), c.roll(c.config.SpikeRate),
+		c.roll(c.config.StaleRate), c.roll(c.config.SkewRate)
+	state := c.state(dsn)
+	last := state.last
+	c.mu.Unlock()
+
+	if spike && c.config.Spike > 0 {
+		select {
+		case <-c.config.Clock.After(c.config.Spike):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	if fail {
+		return "", c.config.Err
+	}
+
+	if stale && last != "" {
+		return last, nil
+	}
+
+	inner, err := c.fetch(ctx, dsn)
+
+	if err != nil {
+		return "", err
+	}
+
+	now := c.config.Clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if inner != state.current {
+		if state.current != "" {
+			state.previous, state.changed = state.current, now
+		}
+
+		state.current = inner
+	}
+
+	if skew && state.previous != "" && now.Sub(state.changed) < c.config.SkewWindow {
+		inner = state.previous
+	}
+
+	state.last = inner
+
+	return inner, nil
+}
+
+// fetch calls the wrapped provider, with ctx if it supports that.
+func (c *Chaos) fetch(ctx context.Context, dsn string) (string, error) {
+	if p, ok := c.provider.(lazydsn.FullDSNProvider); ok {
+		return p.FetchDSNWithContext(ctx, dsn)
+	}
+
+	return c.provider.FetchDSN(dsn)
+}
+
+// roll tells whether an event with the given probability happens. It must be
+// called with the lock held.
+func (c *Chaos) roll(rate float64) bool {
+	return rate > 0 && c.rand.Float64() < rate
+}
+
+// state returns what's known about dsn. It must be called with the lock held.
+func (c *Chaos) state(dsn string) *chaosState {
+	state, ok := c.masters[dsn]
+
+	if !ok {
+		state = &chaosState{}
+		c.masters[dsn] = state
+	}
+
+	return state
+}
+
+// systemClock is the lazydsn.Clock backed by the time package.
+type systemClock struct{}
+
+// Now returns the current time.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for d to elapse.
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Chaos implements the lazydsn.FullDSNProvider interface.
+var _ lazydsn.FullDSNProvider = &Chaos{}
@@ -0,0 +1,100 @@
+package lazydsntest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// Clock is a fake clock, that only moves forward when told so. Give it to the
+// driver with lazydsn.WithClock, and call Advance to fast-forward through
+// cool-down periods, credential ages, health probe intervals and the like,
+// without actually waiting. It's safe for concurrent use.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+// waiter is a pending call to After.
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewClock creates a fake clock set at start.
+func NewClock(start time.Time) *Clock {
+	return &Clock{
+		now: start,
+	}
+}
+
+// Now returns the time the clock is currently set at.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock is advanced
+// by d or more. A non-positive d fires right away.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, waiter{
+		until: c.now.Add(d),
+		ch:    ch,
+	})
+
+	return ch
+}
+
+// Advance moves the clock forward by d, firing every After whose time has
+// come, earliest first.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].until.Before(c.waiters[j].until)
+	})
+
+	pending := c.waiters[:0]
+
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+
+		w.ch <- c.now
+	}
+
+	c.waiters = pending
+}
+
+// Waiters returns the number of After calls that haven't fired yet. Tests can
+// use it to wait for a background goroutine to go to sleep before advancing
+// the clock.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+// Clock implements the lazydsn.Clock interface.
+var _ lazydsn.Clock = &Clock{}
@@ -0,0 +1,304 @@
+/*
+Package lazydsntest provides a fake inner driver and a controllable provider,
+so that applications can unit test how they cope with rotating credentials,
+without a real database:
+
+	inner := lazydsntest.NewDriver()
+	provider := lazydsntest.NewProvider("user:v1@fake/db")
+	connector, err := lazydsn.New(inner, provider).OpenConnector("master")
+	...
+	db := sql.OpenDB(connector)
+
+	provider.Set("user:v2@fake/db")
+	inner.Revoke("user:v1@fake/db")
+	// Connections with v1 are now broken; the pool should move over to v2,
+	// as inner.OpenDSNs shows.
+
+Connections from the fake driver accept every statement, and return no rows.
+For credentials that expire on their own, on a schedule, see RotatingDriver.
+
+Time dependent behavior of the driver can be driven with a fake Clock:
+
+	clock := lazydsntest.NewClock(time.Now())
+	drv := lazydsn.New(inner, provider, lazydsn.WithClock(clock))
+	...
+	clock.Advance(time.Hour)
+
+Real providers can be put to the test as well: Chaos makes them misbehave,
+and Recorder captures what they return, so that the sequence can be replayed
+later with Replay.
+*/
+package lazydsntest
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// errEmpty is returned by providers with nothing queued.
+var errEmpty = errors.New("lazydsntest: no DSNs queued")
+
+// ErrAuth is the error that the fake driver returns for revoked DSNs. It's
+// classified as lazydsn.ClassAuth by Classifier.
+var ErrAuth = errors.New("lazydsntest: authentication failed")
+
+// Classifier classifies ErrAuth as lazydsn.ClassAuth.
+var Classifier = lazydsn.ClassifierFunc(func(err error) lazydsn.ErrorClass {
+	if errors.Is(err, ErrAuth) {
+		return lazydsn.ClassAuth
+	}
+
+	return lazydsn.ClassUnknown
+})
+
+// Driver is a fake inner driver. It records the DSN used for every
+// connection attempt, and can be told to fail. It's safe for concurrent use.
+type Driver struct {
+	mu       sync.Mutex
+	attempts []string
+	rejected []string
+	conns    []*Conn
+	next     []error
+	failing  map[string]error
+}
+
+// NewDriver creates a fake driver.
+func NewDriver() *Driver {
+	return &Driver{
+		failing: make(map[string]error),
+	}
+}
+
+// Open opens a fake connection, unless told to fail.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.attempts = append(d.attempts, dsn)
+
+	if len(d.next) > 0 {
+		err := d.next[0]
+		d.next = d.next[1:]
+
+		if err != nil {
+			d.rejected = append(d.rejected, dsn)
+			return nil, err
+		}
+	}
+
+	if err := d.failing[dsn]; err != nil {
+		d.rejected = append(d.rejected, dsn)
+		return nil, err
+	}
+
+	c := &Conn{
+		dsn:    dsn,
+		driver: d,
+	}
+	d.conns = append(d.conns, c)
+
+	return c, nil
+}
+
+// FailNext makes the next connection attempts fail with the given errors, in
+// order, whatever their DSN. A nil error lets the attempt go through.
+func (d *Driver) FailNext(errs ...error) {
+	d.mu.Lock()
+	d.next = append(d.next, errs...)
+	d.mu.Unlock()
+}
+
+// Fail makes every connection attempt with dsn fail with err, until told
+// otherwise with a nil error. Connections already open are not affected; see
+// Revoke.
+func (d *Driver) Fail(dsn string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err == nil {
+		delete(d.failing, dsn)
+	} else {
+		d.failing[dsn] = err
+	}
+}
+
+// Revoke simulates the database dropping a credential: new connection
+// attempts with dsn fail with ErrAuth, and connections already open with it
+// are broken (they fail with driver.ErrBadConn, so database/sql discards
+// them).
+func (d *Driver) Revoke(dsn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.failing[dsn] = ErrAuth
+
+	for _, c := range d.conns {
+		if c.dsn == dsn {
+			c.broken = true
+		}
+	}
+}
+
+// Attempts returns the DSN used for every connection attempt so far, in
+// order, whether it succeeded or not.
+func (d *Driver) Attempts() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.attempts...)
+}
+
+// Rejected returns the DSN used for every connection attempt that failed so
+// far, in order.
+func (d *Driver) Rejected() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.rejected...)
+}
+
+// Conns returns every connection opened so far, in order, including those
+// already closed.
+func (d *Driver) Conns() []*Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]*Conn(nil), d.conns...)
+}
+
+// OpenDSNs returns the DSN for each connection currently open.
+func (d *Driver) OpenDSNs() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var dsns []string
+
+	for _, c := range d.conns {
+		if !c.closed {
+			dsns = append(dsns, c.dsn)
+		}
+	}
+
+	return dsns
+}
+
+// Conn is a fake connection.
+type Conn struct {
+	dsn    string
+	driver *Driver
+	closed bool
+	broken bool
+}
+
+// DSN returns the DSN that the connection was opened with.
+func (c *Conn) DSN() string {
+	return c.dsn
+}
+
+// Closed reports whether the connection was closed.
+func (c *Conn) Closed() bool {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+
+	return c.closed
+}
+
+// check fails with driver.ErrBadConn if the connection was revoked.
+func (c *Conn) check() error {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+
+	if c.broken || c.closed {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+// Prepare returns a statement that accepts any arguments.
+func (c *Conn) Prepare(string) (driver.Stmt, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return stmt{c}, nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.driver.mu.Lock()
+	c.closed = true
+	c.driver.mu.Unlock()
+
+	return nil
+}
+
+// Begin starts a transaction that does nothing.
+func (c *Conn) Begin() (driver.Tx, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return tx{}, nil
+}
+
+// Ping fails if the connection was revoked.
+func (c *Conn) Ping(context.Context) error {
+	return c.check()
+}
+
+// IsValid reports whether the connection was revoked, so that database/sql
+// doesn't put broken connections back in the pool.
+func (c *Conn) IsValid() bool {
+	return c.check() == nil
+}
+
+// stmt is a statement that accepts anything, and returns no rows.
+type stmt struct {
+	conn *Conn
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+
+func (s stmt) Exec([]driver.Value) (driver.Result, error) {
+	if err := s.conn.check(); err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s stmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.conn.check(); err != nil {
+		return nil, err
+	}
+
+	return rows{}, nil
+}
+
+// rows is an empty result set.
+type rows struct{}
+
+func (rows) Columns() []string         { return nil }
+func (rows) Close() error              { return nil }
+func (rows) Next([]driver.Value) error { return io.EOF }
+
+// tx is a transaction that does nothing.
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+// Driver and Conn implement the driver interfaces.
+var (
+	_ driver.Driver    = &Driver{}
+	_ driver.Conn      = &Conn{}
+	_ driver.Pinger    = &Conn{}
+	_ driver.Validator = &Conn{}
+)
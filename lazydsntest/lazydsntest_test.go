@@ -0,0 +1,187 @@
+package lazydsntest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+func TestRotation(t *testing.T) {
+	inner := NewDriver()
+	provider := NewProvider("v1")
+	connector, err := lazydsn.New(inner, provider, lazydsn.WithClassifier(Classifier)).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	provider.Set("v2")
+	inner.Revoke("v1")
+
+	// Unlike pings, statements are retried on broken connections.
+	if _, err := db.ExecContext(ctx, "UPDATE t SET x = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := inner.OpenDSNs(); len(got) != 1 || got[0] != "v2" {
+		t.Errorf("open connections use %v, want [v2]", got)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	early, late := clock.After(time.Second), clock.After(time.Minute)
+
+	clock.Advance(30 * time.Second)
+
+	select {
+	case <-early:
+	default:
+		t.Fatal("early waiter didn't fire")
+	}
+
+	select {
+	case <-late:
+		t.Fatal("late waiter fired too soon")
+	default:
+	}
+
+	if got := clock.Now().Sub(start); got != 30*time.Second {
+		t.Errorf("clock advanced %v, want 30s", got)
+	}
+}
+
+func TestChaosSkew(t *testing.T) {
+	clock := NewClock(time.Now())
+	provider := NewProvider("v1")
+	chaos := NewChaos(provider, ChaosConfig{
+		SkewRate:   1,
+		SkewWindow: time.Minute,
+		Clock:      clock,
+	})
+
+	if _, err := chaos.FetchDSN("master"); err != nil {
+		t.Fatal(err)
+	}
+
+	provider.Set("v2")
+
+	if got, _ := chaos.FetchDSN("master"); got != "v1" {
+		t.Errorf("got %q within the skew window, want v1", got)
+	}
+
+	clock.Advance(time.Minute)
+
+	if got, _ := chaos.FetchDSN("master"); got != "v2" {
+		t.Errorf("got %q after the skew window, want v2", got)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	var recording bytes.Buffer
+
+	provider := NewProvider("postgres://app:one@db/app", "postgres://app:two@db/app")
+	recorder := NewRecorder(provider, &recording)
+
+	for range 3 {
+		if _, err := recorder.FetchDSN("master"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if strings.Contains(recording.String(), "one") || strings.Contains(recording.String(), "two") {
+		t.Fatalf("recording leaks secrets:\n%s", recording.String())
+	}
+
+	replayer, err := Replay(&recording)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for range 4 {
+		dsn, err := replayer.FetchDSN("master")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, dsn)
+	}
+
+	if got[0] == got[1] || got[1] != got[2] || got[2] != got[3] {
+		t.Errorf("replayed %v, want a single rotation after the first fetch", got)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	result := Simulate(t, Rotation{
+		Publish: 10 * time.Millisecond,
+		Revoke:  60 * time.Millisecond,
+		Warmup:  10 * time.Millisecond,
+		Settle:  10 * time.Millisecond,
+		Pool: func(db *sql.DB) {
+			db.SetConnMaxLifetime(5 * time.Millisecond)
+		},
+	})
+
+	if result.Statements == 0 {
+		t.Error("no statements were issued")
+	}
+}
+
+func TestRotatingDriver(t *testing.T) {
+	clock := NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	inner := NewRotatingDriver(clock, Schedule{
+		Start:       clock.Now(),
+		Every:       time.Hour,
+		Overlap:     time.Minute,
+		DropExpired: true,
+	})
+
+	connector, err := lazydsn.New(inner, inner.Provider(0), lazydsn.WithClock(clock)).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	for want := range 3 {
+		var got int
+
+		if err := db.QueryRow("SELECT CURRENT_GENERATION").Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("connection uses generation %d, want %d", got, want)
+		}
+
+		// The idle connection breaks once its credential expires.
+		clock.Advance(time.Hour + 2*time.Minute)
+	}
+
+	if _, err := inner.Open(inner.DSN(0)); err == nil {
+		t.Error("expired credential accepted")
+	}
+}
@@ -0,0 +1,112 @@
+package lazydsntest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Provider is a controllable provider. It resolves every master DSN to the
+// DSN at the head of its queue; DSNs are taken off the queue as they're
+// fetched, except for the last one, that sticks. Errors and latency can be
+// injected. It's safe for concurrent use.
+type Provider struct {
+	mu      sync.Mutex
+	dsns    []string
+	errs    []error
+	latency time.Duration
+	fetches int
+}
+
+// NewProvider creates a provider with the given DSNs queued.
+func NewProvider(dsns ...string) *Provider {
+	return &Provider{
+		dsns: dsns,
+	}
+}
+
+// Push queues more DSNs. To have the provider switch to a DSN right away,
+// use Set instead.
+func (p *Provider) Push(dsns ...string) {
+	p.mu.Lock()
+	p.dsns = append(p.dsns, dsns...)
+	p.mu.Unlock()
+}
+
+// Set replaces the queue with dsn alone, as when a credential is rotated.
+func (p *Provider) Set(dsn string) {
+	p.mu.Lock()
+	p.dsns = []string{dsn}
+	p.mu.Unlock()
+}
+
+// FailNext makes the next fetches fail with the given errors, in order. A nil
+// error lets the fetch go through.
+func (p *Provider) FailNext(errs ...error) {
+	p.mu.Lock()
+	p.errs = append(p.errs, errs...)
+	p.mu.Unlock()
+}
+
+// SetLatency makes every fetch take d, or until its context is done.
+func (p *Provider) SetLatency(d time.Duration) {
+	p.mu.Lock()
+	p.latency = d
+	p.mu.Unlock()
+}
+
+// Fetches returns the number of fetches so far.
+func (p *Provider) Fetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fetches
+}
+
+// FetchDSN resolves the DSN at the head of the queue.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves the DSN at the head of the queue.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, _ string) (string, error) {
+	p.mu.Lock()
+	p.fetches++
+	latency := p.latency
+	p.mu.Unlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(p.dsns) == 0 {
+		return "", errEmpty
+	}
+
+	dsn := p.dsns[0]
+
+	if len(p.dsns) > 1 {
+		p.dsns = p.dsns[1:]
+	}
+
+	return dsn, nil
+}
@@ -0,0 +1,227 @@
+package lazydsntest
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// errNotRecorded is returned by replayers for master DSNs not in the
+// recording.
+var errNotRecorded = errors.New("lazydsntest: master DSN not recorded")
+
+// Fetch is a recorded fetch. Secrets in DSN and Err are masked (see
+// lazydsn.MaskSecrets) with tokens, that are the same for the same secret, so
+// rotations can still be told apart. Tokens are only consistent within a
+// recording.
+type Fetch struct {
+	// At is the time since recording started.
+	At     time.Duration `json:"at"`
+	Master string        `json:"master"`
+	DSN    string        `json:"dsn,omitempty"`
+	Err    string        `json:"err,omitempty"`
+}
+
+// Recorder is a provider that wraps another one, recording every fetch as a
+// line of JSON (see Fetch). Recordings can be taken in production, where a
+// rotation misbehaves, and replayed in tests with Replay. It's safe for
+// concurrent use.
+type Recorder struct {
+	provider lazydsn.DSNProvider
+	start    time.Time
+	key      [32]byte
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecorder wraps p with a provider that records fetches to w.
+func NewRecorder(p lazydsn.DSNProvider, w io.Writer) *Recorder {
+	r := &Recorder{
+		provider: p,
+		start:    time.Now(),
+		enc:      json.NewEncoder(w),
+	}
+
+	// Tokens must not be reversible by brute force, so they're keyed with
+	// a secret that doesn't outlive the recorder.
+	_, _ = rand.Read(r.key[:])
+
+	return r
+}
+
+// FetchDSN resolves dsn through the wrapped provider, and records the result.
+func (r *Recorder) FetchDSN(dsn string) (string, error) {
+	return r.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves dsn through the wrapped provider, and records
+// the result.
+func (r *Recorder) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	var (
+		inner string
+		err   error
+	)
+
+	if p, ok := r.provider.(lazydsn.FullDSNProvider); ok {
+		inner, err = p.FetchDSNWithContext(ctx, dsn)
+	} else {
+		inner, err = r.provider.FetchDSN(dsn)
+	}
+
+	f := Fetch{
+		At:     time.Since(r.start),
+		Master: dsn,
+	}
+
+	if err != nil {
+		// Errors may quote the DSN, but we don't have it here; mask what
+		// looks like a secret in the message itself.
+		f.Err = lazydsn.MaskSecrets(err.Error(), r.token)
+	} else {
+		f.DSN = lazydsn.MaskSecrets(inner, r.token)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(f)
+	}
+
+	return inner, err
+}
+
+// Err returns the first error found writing the recording, if any. Fetches
+// are not affected by those.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// token returns the mask for secret.
+func (r *Recorder) token(secret string) string {
+	mac := hmac.New(sha256.New, r.key[:])
+	mac.Write([]byte(secret))
+
+	return "masked-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// Replayer is a provider that answers with the fetches in a recording. By
+// default, each master DSN gets its recorded results in order, one per fetch,
+// and the last one sticks; with a clock (see WithClock), the result is the
+// latest one recorded up to the time elapsed since the replayer was created,
+// so that timing is reproduced too. Recorded errors are returned as errors
+// with the same message. It's safe for concurrent use.
+type Replayer struct {
+	mu      sync.Mutex
+	fetches map[string][]Fetch
+	next    map[string]int
+	clock   lazydsn.Clock
+	start   time.Time
+}
+
+// Replay reads a recording from r, as written by a Recorder.
+func Replay(r io.Reader) (*Replayer, error) {
+	p := &Replayer{
+		fetches: make(map[string][]Fetch),
+		next:    make(map[string]int),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var f Fetch
+
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			return nil, err
+		}
+
+		p.fetches[f.Master] = append(p.fetches[f.Master], f)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// WithClock makes the replayer reproduce the timing of the recording with
+// clock, which is usually a fake Clock. Time starts counting now.
+func (p *Replayer) WithClock(clock lazydsn.Clock) *Replayer {
+	p.mu.Lock()
+	p.clock, p.start = clock, clock.Now()
+	p.mu.Unlock()
+
+	return p
+}
+
+// FetchDSN returns the recorded result for dsn.
+func (p *Replayer) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext returns the recorded result for dsn.
+func (p *Replayer) FetchDSNWithContext(_ context.Context, dsn string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fetches := p.fetches[dsn]
+
+	if len(fetches) == 0 {
+		return "", errNotRecorded
+	}
+
+	var f Fetch
+
+	if p.clock != nil {
+		elapsed := p.clock.Now().Sub(p.start)
+		f = fetches[0]
+
+		for _, next := range fetches[1:] {
+			if next.At > elapsed {
+				break
+			}
+
+			f = next
+		}
+	} else {
+		i := p.next[dsn]
+		f = fetches[i]
+
+		if i < len(fetches)-1 {
+			p.next[dsn] = i + 1
+		}
+	}
+
+	if f.Err != "" {
+		return "", errors.New(f.Err)
+	}
+
+	return f.DSN, nil
+}
+
+// Recorder and Replayer implement the lazydsn.FullDSNProvider interface.
+var (
+	_ lazydsn.FullDSNProvider = &Recorder{}
+	_ lazydsn.FullDSNProvider = &Replayer{}
+)
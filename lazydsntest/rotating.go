@@ -0,0 +1,270 @@
+package lazydsntest
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// Schedule sets how a RotatingDriver rotates its credentials. Credentials
+// come in generations, numbered from zero: generation n is issued at
+// Start + n*Every, and accepted until the next one is issued, plus Overlap.
+// DSNs are built from Format, with the generation number in place of its %d
+// verb ("app:v%d@fake/db", if empty).
+type Schedule struct {
+	Start   time.Time
+	Every   time.Duration
+	Overlap time.Duration
+	Format  string
+
+	// By default, connections that are already open outlive their
+	// credentials, as with most databases. When DropExpired is set, they
+	// break once their credential has been expired for longer than Grace.
+	DropExpired bool
+	Grace       time.Duration
+}
+
+// RotatingDriver is an in-memory, SQL-ish driver whose accepted credentials
+// change on a schedule, as told by a clock. With a fake Clock, validity
+// windows, revocations and grace periods can be exercised in CI, without
+// containers or waiting. Connections understand a few statements:
+//
+//	SELECT 1
+//	SELECT CURRENT_USER         -- the user in the connection's DSN
+//	SELECT CURRENT_GENERATION   -- the generation of its credential
+//
+// Anything else is accepted, and returns no rows. It's safe for concurrent
+// use.
+type RotatingDriver struct {
+	schedule Schedule
+	clock    lazydsn.Clock
+
+	mu      sync.Mutex
+	revoked map[int]bool
+}
+
+// NewRotatingDriver creates a driver that rotates credentials as set in
+// schedule, with the time told by clock. Every must be positive.
+func NewRotatingDriver(clock lazydsn.Clock, schedule Schedule) *RotatingDriver {
+	if schedule.Format == "" {
+		schedule.Format = "app:v%d@fake/db"
+	}
+
+	return &RotatingDriver{
+		schedule: schedule,
+		clock:    clock,
+		revoked:  make(map[int]bool),
+	}
+}
+
+// DSN returns the DSN for the given generation.
+func (d *RotatingDriver) DSN(generation int) string {
+	return fmt.Sprintf(d.schedule.Format, generation)
+}
+
+// Generation returns the generation of the credential issued last. It's
+// negative before Start.
+func (d *RotatingDriver) Generation() int {
+	elapsed := d.clock.Now().Sub(d.schedule.Start)
+
+	if elapsed < 0 {
+		return -1
+	}
+
+	return int(elapsed / d.schedule.Every)
+}
+
+// Provider returns a provider that resolves every master DSN to the DSN of
+// the generation issued last, as a secrets backend kept in sync with the
+// database would. Lag delays new generations, as seen by the provider.
+func (d *RotatingDriver) Provider(lag time.Duration) lazydsn.DSNProvider {
+	return lazydsn.DSNProviderFunc(func(string) (string, error) {
+		elapsed := d.clock.Now().Sub(d.schedule.Start) - lag
+
+		if elapsed < 0 {
+			return "", errEmpty
+		}
+
+		return d.DSN(int(elapsed / d.schedule.Every)), nil
+	})
+}
+
+// Revoke drops the credential for the given generation ahead of schedule.
+// Connections opened with it break right away.
+func (d *RotatingDriver) Revoke(generation int) {
+	d.mu.Lock()
+	d.revoked[generation] = true
+	d.mu.Unlock()
+}
+
+// generationOf returns the generation of the credential in dsn, if any.
+func (d *RotatingDriver) generationOf(dsn string) (int, bool) {
+	var n int
+
+	if _, err := fmt.Sscanf(dsn, d.schedule.Format, &n); err != nil || d.DSN(n) != dsn {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// expired returns how long ago the given generation expired, which is
+// negative while it's valid.
+func (d *RotatingDriver) expired(generation int) time.Duration {
+	d.mu.Lock()
+	revoked := d.revoked[generation]
+	d.mu.Unlock()
+
+	if revoked {
+		return 1<<63 - 1
+	}
+
+	now := d.clock.Now()
+	issued := d.schedule.Start.Add(time.Duration(generation) * d.schedule.Every)
+
+	if now.Before(issued) {
+		// Not issued yet.
+		return 1<<63 - 1
+	}
+
+	return now.Sub(issued.Add(d.schedule.Every + d.schedule.Overlap))
+}
+
+// Open opens a connection, if the credential in dsn is currently accepted.
+func (d *RotatingDriver) Open(dsn string) (driver.Conn, error) {
+	generation, ok := d.generationOf(dsn)
+
+	if !ok || d.expired(generation) >= 0 {
+		return nil, ErrAuth
+	}
+
+	user, _, _ := strings.Cut(dsn, ":")
+
+	return &rotatingConn{
+		driver:     d,
+		user:       user,
+		generation: generation,
+	}, nil
+}
+
+// rotatingConn is a connection opened by a RotatingDriver.
+type rotatingConn struct {
+	driver     *RotatingDriver
+	user       string
+	generation int
+}
+
+// check fails with driver.ErrBadConn if the connection's credential was
+// revoked, or expired and the schedule drops those.
+func (c *rotatingConn) check() error {
+	d := c.driver
+	expired := d.expired(c.generation)
+
+	d.mu.Lock()
+	revoked := d.revoked[c.generation]
+	d.mu.Unlock()
+
+	if revoked || d.schedule.DropExpired && expired > d.schedule.Grace {
+		return driver.ErrBadConn
+	}
+
+	return nil
+}
+
+func (c *rotatingConn) Prepare(query string) (driver.Stmt, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return &rotatingStmt{
+		conn:  c,
+		query: strings.ToUpper(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))),
+	}, nil
+}
+
+func (c *rotatingConn) Close() error { return nil }
+
+func (c *rotatingConn) Begin() (driver.Tx, error) {
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return tx{}, nil
+}
+
+// Ping fails if the connection broke.
+func (c *rotatingConn) Ping(context.Context) error {
+	return c.check()
+}
+
+// IsValid reports whether the connection broke, so that database/sql doesn't
+// put it back in the pool.
+func (c *rotatingConn) IsValid() bool {
+	return c.check() == nil
+}
+
+// rotatingStmt is a statement for a rotatingConn.
+type rotatingStmt struct {
+	conn  *rotatingConn
+	query string
+}
+
+func (s *rotatingStmt) Close() error  { return nil }
+func (s *rotatingStmt) NumInput() int { return -1 }
+
+func (s *rotatingStmt) Exec([]driver.Value) (driver.Result, error) {
+	if err := s.conn.check(); err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(0), nil
+}
+
+func (s *rotatingStmt) Query([]driver.Value) (driver.Rows, error) {
+	if err := s.conn.check(); err != nil {
+		return nil, err
+	}
+
+	switch s.query {
+	case "SELECT 1":
+		return &valueRows{value: int64(1)}, nil
+	case "SELECT CURRENT_USER":
+		return &valueRows{value: s.conn.user}, nil
+	case "SELECT CURRENT_GENERATION":
+		return &valueRows{value: int64(s.conn.generation)}, nil
+	}
+
+	return rows{}, nil
+}
+
+// valueRows is a result set with a single value.
+type valueRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (r *valueRows) Columns() []string { return []string{"value"} }
+func (r *valueRows) Close() error      { return nil }
+
+func (r *valueRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	dest[0], r.done = r.value, true
+
+	return nil
+}
+
+// RotatingDriver implements the driver interfaces.
+var (
+	_ driver.Driver    = &RotatingDriver{}
+	_ driver.Pinger    = &rotatingConn{}
+	_ driver.Validator = &rotatingConn{}
+)
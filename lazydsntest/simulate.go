@@ -0,0 +1,197 @@
+package lazydsntest
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// Credentials used by Simulate, as seen by the fake driver.
+const (
+	OldDSN = "app:old@fake/db"
+	NewDSN = "app:new@fake/db"
+)
+
+// Rotation describes a rotation scenario for Simulate. Times are relative to
+// the start of the rotation, and real; database/sql itself keeps time with
+// the system's clock, so scenarios should be kept short (milliseconds, not
+// minutes).
+type Rotation struct {
+	// Options are given to lazydsn.New, after a Classifier for the fake
+	// driver's errors.
+	Options []lazydsn.Option
+
+	// Pool, if set, configures the pool before the scenario starts; e.g.,
+	// to set the maximum lifetime of connections.
+	Pool func(db *sql.DB)
+
+	// Provider, if set, resolves the DSNs instead of a plain Provider
+	// handing out OldDSN and then NewDSN at Publish. Wrappers (such as
+	// Chaos) must be built on top of p.
+	Provider func(p *Provider) lazydsn.DSNProvider
+
+	// Activate is when the database starts accepting the new credential,
+	// Publish is when the provider starts handing it out, and Revoke is
+	// when the database drops the old one. The window between Activate
+	// and Revoke is the overlap, when both work.
+	Activate time.Duration
+	Publish  time.Duration
+	Revoke   time.Duration
+
+	// Warmup is how long the pool works with the old credential before the
+	// rotation starts, and Settle how long it keeps working after the old
+	// credential is revoked.
+	Warmup time.Duration
+	Settle time.Duration
+
+	// Workers is the number of goroutines issuing statements all along;
+	// four, if zero.
+	Workers int
+}
+
+// RotationResult tells how the pool fared in a rotation scenario.
+type RotationResult struct {
+	// Statements counts the statements issued, and Failures holds the
+	// errors for those that failed.
+	Statements int
+	Failures   []error
+
+	// Rejected lists the DSNs of connection attempts that the database
+	// rejected. Even if the pool recovered from those, they're auth
+	// failures that a real database would log, and possibly rate limit.
+	Rejected []string
+}
+
+// Simulate runs a full rotation scenario against a fake driver, with
+// statements issued non-stop through a lazydsn pool, and fails t if any of
+// those fails or any connection attempt is rejected. That turns "is rotation
+// configured safely?" into a unit test:
+//
+//	lazydsntest.Simulate(t, lazydsntest.Rotation{
+//		Publish: 10 * time.Millisecond,
+//		Revoke:  50 * time.Millisecond,
+//		Pool: func(db *sql.DB) {
+//			db.SetConnMaxLifetime(20 * time.Millisecond)
+//		},
+//	})
+func Simulate(t testing.TB, r Rotation) RotationResult {
+	t.Helper()
+
+	inner := NewDriver()
+	inner.Fail(NewDSN, ErrAuth)
+
+	provider := NewProvider(OldDSN)
+	var p lazydsn.DSNProvider = provider
+
+	if r.Provider != nil {
+		p = r.Provider(provider)
+	}
+
+	opts := append([]lazydsn.Option{lazydsn.WithClassifier(Classifier)}, r.Options...)
+	connector, err := lazydsn.New(inner, p, opts...).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	if r.Pool != nil {
+		r.Pool(db)
+	}
+
+	workers := r.Workers
+
+	if workers <= 0 {
+		workers = 4
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result RotationResult
+	)
+
+	for range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				_, err := db.ExecContext(ctx, "UPDATE t SET x = 1")
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				mu.Lock()
+				result.Statements++
+
+				if err != nil {
+					result.Failures = append(result.Failures, err)
+				}
+
+				mu.Unlock()
+
+				// Let the scenario go on, even with a single CPU.
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	time.Sleep(r.Warmup)
+
+	steps := []struct {
+		at time.Duration
+		do func()
+	}{
+		{r.Activate, func() { inner.Fail(NewDSN, nil) }},
+		{r.Publish, func() { provider.Set(NewDSN) }},
+		{r.Revoke, func() { inner.Revoke(OldDSN) }},
+	}
+
+	// Steps at the same time happen in the order above.
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].at < steps[j].at
+	})
+
+	// Steps may run late when workers keep the CPU busy; sleeping from one
+	// step to the next, rather than from the start, makes sure that windows
+	// are never shorter than intended.
+	var last time.Duration
+
+	for _, step := range steps {
+		time.Sleep(step.at - last)
+		step.do()
+		last = step.at
+	}
+
+	time.Sleep(r.Settle)
+	cancel()
+	wg.Wait()
+
+	result.Rejected = inner.Rejected()
+
+	if len(result.Failures) > 0 {
+		t.Errorf("%d of %d statements failed during rotation; first error: %v",
+			len(result.Failures), result.Statements, result.Failures[0])
+	}
+
+	if len(result.Rejected) > 0 {
+		t.Errorf("the database rejected %d connection attempts during rotation; first with %s",
+			len(result.Rejected), result.Rejected[0])
+	}
+
+	return result
+}
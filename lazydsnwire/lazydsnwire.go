@@ -0,0 +1,53 @@
+/*
+Package lazydsnwire has providers to wire lazydsn into applications built with
+Google's wire. ProviderSet provides a *sql.DB opened with lazydsn.OpenDB, and
+the *lazydsn.Driver behind it, out of an inner driver and a lazydsn.PoolConfig
+supplied by the application; the database is closed by the cleanup function
+that wire hands back:
+
+	func initDB(cfg lazydsn.PoolConfig) (*sql.DB, func(), error) {
+		wire.Build(
+			wire.InterfaceValue(new(driver.Driver), &mysql.MySQLDriver{}),
+			lazydsnwire.ProviderSet,
+		)
+		return nil, nil, nil
+	}
+*/
+package lazydsnwire
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/google/wire"
+)
+
+// ProviderSet provides a *sql.DB, and the *lazydsn.Driver behind it.
+var ProviderSet = wire.NewSet(NewDB, NewDriver)
+
+// NewDB opens the pool described by cfg with the inner driver d. The cleanup
+// function closes it.
+func NewDB(d driver.Driver, cfg lazydsn.PoolConfig) (*sql.DB, func(), error) {
+	db, err := lazydsn.OpenDB(d, cfg)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return db, func() {
+		db.Close()
+	}, nil
+}
+
+// NewDriver returns the Driver behind db.
+func NewDriver(db *sql.DB) (*lazydsn.Driver, error) {
+	d, ok := lazydsn.DriverOf(db)
+
+	if !ok {
+		return nil, errors.New("lazydsnwire: database not backed by lazydsn")
+	}
+
+	return d, nil
+}
@@ -0,0 +1,67 @@
+package lazydsnwire_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+	"github.com/gkristic/lazydsn/lazydsnwire"
+)
+
+// plainConnector connects to "db" with the inner driver, without lazydsn.
+type plainConnector struct {
+	inner *lazydsntest.Driver
+}
+
+func (c plainConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.inner.Open("db")
+}
+
+func (c plainConnector) Driver() driver.Driver {
+	return c.inner
+}
+
+// TestProviders checks that the providers hand out the database and the
+// driver behind it, and that the cleanup function closes the database.
+func TestProviders(t *testing.T) {
+	db, cleanup, err := lazydsnwire.NewDB(lazydsntest.NewDriver(), lazydsn.PoolConfig{
+		DSN:      "master",
+		Provider: lazydsntest.NewProvider("db"),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lazydsnwire.NewDriver(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup()
+
+	if err := db.Ping(); err == nil {
+		t.Error("got the database open after cleanup, want it closed")
+	}
+}
+
+// TestProviderErrors checks that configuration errors are reported, and that
+// databases not backed by lazydsn are refused.
+func TestProviderErrors(t *testing.T) {
+	if _, _, err := lazydsnwire.NewDB(lazydsntest.NewDriver(), lazydsn.PoolConfig{DSN: "master"}); err == nil {
+		t.Error("got no error for a pool without a provider")
+	}
+
+	plain := sql.OpenDB(plainConnector{lazydsntest.NewDriver()})
+	defer plain.Close()
+
+	if _, err := lazydsnwire.NewDriver(plain); err == nil {
+		t.Error("got a driver for a plain database, want an error")
+	}
+}
@@ -0,0 +1,124 @@
+package lazydsn
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// A Starter is a provider that needs to be started before use; e.g., to set
+// up SDK clients, or start goroutines that watch for changes or renew tokens.
+// Start is called before the first connector that uses the provider fetches
+// anything, with the context of whatever triggered that; it's not meant to
+// bound background work. If Start fails, the error is returned in place of a
+// connection, and Start is tried again the next time.
+//
+// Providers that also implement io.Closer are closed once every connector
+// that used them is closed, which happens when the databases are closed.
+// That's the time to stop background work, and release resources. A provider
+// may be started again afterwards, if used by a new connector. Providers that
+// implement io.Closer alone are closed the same way.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// lifecycleState counts the connectors using the provider. See Starter.
+type lifecycleState struct {
+	mu    sync.Mutex
+	users int
+}
+
+// providerUse tells whether a connector is accounted for as using the
+// provider.
+type providerUse struct {
+	started atomic.Bool
+}
+
+// start accounts for u using the provider, starting the latter if it's the
+// first one.
+func (d *Driver) start(ctx context.Context, u *providerUse) error {
+	if u.started.Load() || d.starter == nil && d.closer == nil {
+		return nil
+	}
+
+	s := &d.lifecycle
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.started.Load() {
+		return nil
+	}
+
+	if s.users == 0 && d.starter != nil {
+		if err := d.starter.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.users++
+	u.started.Store(true)
+
+	return nil
+}
+
+// stop accounts for u no longer using the provider, closing the latter if it
+// was the last one.
+func (d *Driver) stop(u *providerUse) error {
+	if !u.started.CompareAndSwap(true, false) {
+		return nil
+	}
+
+	s := &d.lifecycle
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users--; s.users == 0 && d.closer != nil {
+		return d.closer.Close()
+	}
+
+	return nil
+}
+
+// masterState counts the connectors open for every master DSN, so that what
+// they share (health probes, dry runs, pre-warmed connections and the
+// credentials to revoke) is only released along with the last one.
+type masterState struct {
+	mu    sync.Mutex
+	users map[string]int
+}
+
+// acquire accounts for a new connector for dsn. Shared providers that revoke
+// credentials are told about the first one; see sharedRevoker.
+func (d *Driver) acquire(dsn string) {
+	s := &d.masters
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users == nil {
+		s.users = make(map[string]int)
+	}
+
+	key := d.fingerprint(dsn)
+
+	if s.users[key]++; s.users[key] == 1 && d.shared != nil {
+		d.shared.acquire(dsn)
+	}
+}
+
+// release accounts for a connector for dsn being closed, and tells whether
+// it was the last one.
+func (d *Driver) release(dsn string) bool {
+	s := &d.masters
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := d.fingerprint(dsn)
+
+	if s.users[key]--; s.users[key] > 0 {
+		return false
+	}
+
+	delete(s.users, key)
+
+	return true
+}
@@ -0,0 +1,350 @@
+package lazydsn
+
+import (
+	"crypto/tls"
+	"maps"
+	"time"
+)
+
+// An Option configures optional behavior for a Driver. Options are given to
+// New or Register.
+type Option func(*Driver)
+
+// WithClassifier sets the classifier used to categorize errors returned by the
+// inner driver. See Classifier.
+func WithClassifier(c Classifier) Option {
+	return func(d *Driver) {
+		d.classifier = c
+	}
+}
+
+// WithObserver sets an observer that is notified about every fetch and connect
+// attempt, successful or not. There's a single observer per driver; to notify
+// several, combine them with Observers.
+func WithObserver(o Observer) Option {
+	return func(d *Driver) {
+		d.observer = o
+	}
+}
+
+// WithAuditor sets an auditor that gets a record every time a credential is
+// used to open a connection. See Auditor.
+func WithAuditor(a Auditor) Option {
+	return func(d *Driver) {
+		d.auditor = a
+	}
+}
+
+// WithVerifier makes the driver check every provider response with v before
+// using it. See Verifier and JWSVerifier.
+func WithVerifier(v Verifier) Option {
+	return func(d *Driver) {
+		d.verifier = v
+	}
+}
+
+// WithTLS enables support for CA bundles supplied by the provider (see
+// DSNInfo), so that CA rotations can be rolled out without restarting the
+// application. Every time the bundle changes, a new TLS configuration is built
+// out of base (which can be nil) and given to apply, that must hand it over to
+// the inner driver. New connections use the updated configuration from then
+// on; connections already open are not affected.
+func WithTLS(base *tls.Config, apply TLSApplier) Option {
+	return func(d *Driver) {
+		d.tls = &tlsState{
+			base:  base,
+			apply: apply,
+		}
+	}
+}
+
+// WithRotationPolicy sets the policy that resolved credentials are checked
+// against. See RotationPolicy.
+func WithRotationPolicy(p RotationPolicy) Option {
+	return func(d *Driver) {
+		d.policy = p
+	}
+}
+
+// WithIdentityGuard enables detection of changes in the identity that the
+// driver connects as. See IdentityGuard.
+func WithIdentityGuard(g IdentityGuard) Option {
+	return func(d *Driver) {
+		d.identity = &g
+	}
+}
+
+// WithDecorators adds decorators that every DSN returned by the provider goes
+// through, in order, before it's used. See Decorator.
+func WithDecorators(decorators ...Decorator) Option {
+	return func(d *Driver) {
+		d.decorators = append(d.decorators, decorators...)
+	}
+}
+
+// WithConnAttributes makes the driver tag every new connection with attrs,
+// so that DBAs can attribute sessions to services and rotations during
+// audits; see SetConnAttributes for how they're set in each DSN format. The
+// driver adds the credential version (see DSNInfo.Version) and the name it
+// was registered with (see Register), as AttrVersion and AttrAlias; providers
+// may add more, or override any of them (see DSNInfo.Attributes). Attributes
+// are set after decorators run; see WithDecorators.
+func WithConnAttributes(attrs map[string]string) Option {
+	return func(d *Driver) {
+		d.attributes = maps.Clone(attrs)
+
+		if d.attributes == nil {
+			d.attributes = make(map[string]string)
+		}
+	}
+}
+
+// WithDSNPolicy sets a policy that every resolved DSN must comply with before
+// it's used. See DSNPolicy.
+func WithDSNPolicy(p DSNPolicy) Option {
+	return func(d *Driver) {
+		d.dsnPolicy = p
+	}
+}
+
+// WithCooldown sets how long endpoints that failed to connect are avoided, when
+// the provider returns several candidate DSNs. See MultiDSNProvider.
+func WithCooldown(d time.Duration) Option {
+	return func(drv *Driver) {
+		drv.cooldown = d
+	}
+}
+
+// WithBalancing sets how new connections are distributed, when the provider
+// returns several candidate DSNs. See Balancing and MultiDSNProvider.
+func WithBalancing(b Balancing) Option {
+	return func(d *Driver) {
+		d.balancing = b
+	}
+}
+
+// WithHealthProbe enables background health checks for the candidates
+// returned by a MultiDSNProvider. See HealthProbe.
+func WithHealthProbe(p HealthProbe) Option {
+	return func(d *Driver) {
+		d.probe = &p
+	}
+}
+
+// WithDryRun makes the driver try the credentials staged for the next
+// rotation every interval, for providers that implement StagedDSNProvider. A
+// connection is opened with them, pinged, and closed right away, within
+// timeout (2s if zero); the pool keeps using current credentials. Each try is
+// reported as EventDryRun, with the error if it failed. Dry runs go on for as
+// long as a database opened with the driver is open, starting with the first
+// connection.
+func WithDryRun(interval, timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.dryRunInterval = interval
+		d.dryRunTimeout = timeout
+	}
+}
+
+// WithPrewarm makes the driver open n connections in the background as soon as
+// it fails over to a different candidate (see MultiDSNProvider), whether
+// because the provider now prefers it, or because the active one failed or
+// was evicted by health probes. New connections requested by the pool are
+// then taken from those, instead of waiting for a handshake each. Connections
+// not taken within ttl (a minute, if zero) are closed, as are those whose DSN
+// was rotated meanwhile. This only applies to the Failover strategy; see
+// Balancing.
+func WithPrewarm(n int, ttl time.Duration) Option {
+	return func(d *Driver) {
+		d.warmConns = n
+		d.warmTTL = ttl
+	}
+}
+
+// WithRefreshInterval makes connections reuse the inner DSNs last resolved for
+// up to d, instead of asking the provider every time a new connection is
+// needed; fetches from remote secrets backends otherwise dominate the time it
+// takes to connect. Resolutions are refreshed in the background once they're
+// halfway through d, and synchronously once past it. Connectors sharing a
+// master DSN (e.g., reader and writer pools opened with the same one) share
+// resolutions too, so the provider is asked once per refresh, rather than once
+// per pool. Whenever the database rejects credentials that were reused,
+// they're fetched again right away, and the connection retried. That last bit
+// relies on a Classifier; see WithClassifier. Keep d well below the time that
+// credentials stay valid.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(drv *Driver) {
+		drv.refresh = d
+	}
+}
+
+// WithFetchBudget caps the share of a connection attempt's deadline that
+// resolving candidates through the provider may take, as a fraction between 0
+// and 1; e.g., 0.3 leaves at least 70% of the time for connecting, so that a
+// slow backend can't use it all up. It only applies when the context given
+// to database/sql has a deadline. If the provider runs out of time, and
+// there's a snapshot of the last candidates, though expired (see
+// WithRefreshInterval), the connection is attempted with them instead; if
+// they're rejected, they're fetched again with whatever time is left.
+func WithFetchBudget(fraction float64) Option {
+	return func(d *Driver) {
+		d.fetchBudget = fraction
+	}
+}
+
+// WithPrefetch makes the driver fetch the inner DSNs again some lead time
+// before pooled connections reach lifetime, which should match the pool's
+// ConnMaxLifetime (see sql.DB.SetConnMaxLifetime). Connections opened together
+// expire together, and the wave of reconnects that follows then finds fresh
+// resolutions, instead of all waiting for the provider at once. The lead
+// defaults to 5s, and is capped at half the lifetime. This only applies when
+// reusing resolutions; see WithRefreshInterval.
+func WithPrefetch(lifetime, lead time.Duration) Option {
+	return func(d *Driver) {
+		d.prefetchLifetime = lifetime
+		d.prefetchLead = lead
+	}
+}
+
+// WithRotationPing makes the driver ping the first connection opened with
+// every new credential, within timeout, as long as the inner driver's
+// connections implement driver.Pinger. A handshake alone doesn't prove that
+// a freshly rotated credential works (e.g., it may lack grants), and this
+// gives early warning if it doesn't: outcomes are reported as EventValidate,
+// and counted in Stats. Connections that fail the ping are closed, and the
+// error returned instead.
+func WithRotationPing(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.validateTimeout = timeout
+	}
+}
+
+// WithServerIdentity makes the driver check that connections reach the same
+// logical server after a rotation, guarding against providers that point the
+// pool to a different database by mistake. The first connection opened with
+// every new inner DSN is asked for the identity of its server, within the
+// timeout set with WithRotationPing (2s if none), and compared with the one
+// seen first for the same master DSN (and endpoint, for MultiDSNProvider).
+// Connections to a different server are closed, and refused with
+// ErrServerChanged; that's reported with EventIdentity as well. See
+// QueryServerIdentity.
+func WithServerIdentity(f ServerIdentity) Option {
+	return func(d *Driver) {
+		d.serverIdentity = f
+	}
+}
+
+// WithRebuildLimit bounds how many inner connectors the driver creates at
+// the same time, and how many connections with new credentials it validates
+// (see WithRotationPing and WithServerIdentity), to n across all of its
+// connectors. A rotation in the middle of a traffic spike, or one affecting
+// many databases at once (see Shards), would otherwise fan out into as many
+// parallel connector builds and validations as connections requested. The
+// rest wait their turn, and reuse the results if they can; i.e., connections
+// with credentials validated meanwhile aren't validated again. Connecting
+// itself, including TLS handshakes, is bounded by database/sql's pool limits
+// instead; see PoolConfig.
+func WithRebuildLimit(n int) Option {
+	return func(d *Driver) {
+		if n > 0 {
+			d.rebuilds = make(rebuildSlots, n)
+		}
+	}
+}
+
+// WithRoles makes connectors keep separate credentials per role, for
+// authorization models that need more than one per pool; e.g., read-only and
+// read-write, or a database role per service. The role comes from the context
+// given to database/sql (see ContextWithRole), or is defaultRole if there's
+// none. Master DSNs are templates where "{role}" is replaced by the role, and
+// each resulting master DSN is resolved through the provider as usual, with
+// its own snapshots, inner connectors and background work (see
+// WithRefreshInterval), created the first time the role is requested.
+//
+// database/sql doesn't know about roles, so a pooled connection may be picked
+// for a request with a different role than it was opened with. Connections
+// are checked when reused, and when first used if opened in the background
+// (database/sql does that without a context, so they're for the default
+// role). Those for another role are discarded, as driver.ErrBadConn, and the
+// operation is retried with a connection for the right role. That works, but
+// churns connections if roles are mixed in the same pool all the time;
+// consider a pool per role (or mostly so) if that's the case.
+//
+// Taking a connection with sql.DB.Conn involves no driver call, so a fresh
+// one can only be checked once used. Pass the same role to the calls made on
+// the sql.Conn, or at least to the first one. Connections keep their role
+// until released afterwards, whatever the context of later calls.
+func WithRoles(defaultRole string) Option {
+	return func(d *Driver) {
+		d.roles = true
+		d.defaultRole = defaultRole
+	}
+}
+
+// WithRevocationGrace makes the driver replace pooled connections opened
+// with credentials that were rotated more than grace ago, which should be
+// less than the time it takes for the old credentials to be revoked. Such
+// connections are reported as invalid when returned to the pool, and as
+// driver.ErrBadConn when about to be reused, so that database/sql closes them
+// and opens new ones with current credentials, instead of letting queries fail
+// once the database kills them. Connections in use are not interrupted.
+//
+// Providers may also tell when old credentials are actually revoked (see
+// DSNInfo.Revoked), which replaces connections right away, grace or not. A
+// negative grace leaves it up to the provider alone.
+//
+// Old credentials are thus never used past the revocation point, except by
+// connections that were in use by then, until released. Idle connections
+// don't use them either, but only go away as database/sql picks them, or as
+// they reach ConnMaxIdleTime. Stats reports the connections still open with
+// retired credentials, and those open with every version, to follow the
+// drain; every connection discarded is reported as EventRetire.
+func WithRevocationGrace(grace time.Duration) Option {
+	return func(d *Driver) {
+		d.retireConns = true
+		d.revocationGrace = grace
+	}
+}
+
+// WithRevokeTimeout bounds the time that a Revoker is given to revoke
+// credentials, when the last database using them is closed (10s, if zero or
+// not set). Closing the database waits for the revocation, so a backend that
+// hangs would otherwise keep it from ever returning.
+func WithRevokeTimeout(timeout time.Duration) Option {
+	return func(d *Driver) {
+		d.revokeTimeout = timeout
+	}
+}
+
+// WithClock sets the clock that the driver tells the time with, instead of
+// the system's. This is meant for tests; see Clock.
+func WithClock(c Clock) Option {
+	return func(d *Driver) {
+		d.clock = c
+	}
+}
+
+// WithPanicRecovery turns panics raised by the inner driver while opening
+// connections into errors of type *PanicError. Without this option, panics are
+// raised again. Either way, secrets are scrubbed from the panic value first.
+func WithPanicRecovery() Option {
+	return func(d *Driver) {
+		d.recoverPanics = true
+	}
+}
+
+// WithMemoryHardening keeps the driver from retaining plaintext copies of
+// resolved DSNs once connections are open. Whatever the driver needs to
+// remember to detect changes is always kept as a salted digest. Resolutions
+// reused with WithRefreshInterval are kept in memory that is locked (on Linux
+// and macOS, as far as RLIMIT_MEMLOCK allows; see mlock(2)) and wiped once no
+// longer needed, and only copied out for as long as it takes to connect. A
+// SharedProvider used by a hardened driver only coalesces concurrent requests,
+// without keeping responses. Note that this can't prevent the inner driver,
+// or the provider, from keeping their own copies (e.g., in connectors); Go
+// strings can't be wiped either, so copies in flight live until they're
+// garbage collected.
+func WithMemoryHardening() Option {
+	return func(d *Driver) {
+		d.hardened = true
+	}
+}
@@ -0,0 +1,128 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// PanicError is returned instead of propagating panics from the inner driver,
+// when enabled with WithPanicRecovery. Value is the original panic value, with
+// any secrets scrubbed from it.
+type PanicError struct {
+	Value any
+}
+
+// Error describes the panic.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("lazydsn: inner driver panicked: %v", e.Value)
+}
+
+// scrubPanic removes the secrets in dsn from a panic value. Errors keep their
+// chain (see redact), other values are converted to strings if, and only if,
+// they contained secrets.
+func scrubPanic(v any, dsn string) any {
+	switch v := v.(type) {
+	case error:
+		return redact(v, dsn)
+	case string:
+		return scrub(v, dsn)
+	}
+
+	s := fmt.Sprint(v)
+
+	if scrubbed := scrub(s, dsn); scrubbed != s {
+		return scrubbed
+	}
+
+	return v
+}
+
+// guard runs f, that calls into the inner driver using innerDSN, so that
+// secrets can't leak through panics either; e.g., into crash logs. Panics are
+// recovered, scrubbed and then either raised again or turned into errors,
+// depending on the driver's configuration.
+func (d *Driver) guard(innerDSN string, f func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			v = scrubPanic(v, innerDSN)
+
+			if !d.recoverPanics {
+				panic(v)
+			}
+
+			err = &PanicError{
+				Value: v,
+			}
+		}
+	}()
+
+	return f()
+}
+
+// innerOpen opens a connection with the inner driver's Open method, or with a
+// connector when the provider supplies one. Connectors keep those (see
+// dsnConnector); otherwise, there's nowhere to keep them between calls, so
+// each one is closed along with its connection.
+func (d *Driver) innerOpen(ctx context.Context, info DSNInfo) (conn driver.Conn, err error) {
+	if info.Connector != nil {
+		connector, err := d.innerConnector(info)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if conn, err = d.innerConnect(ctx, connector, info.DSN); err != nil {
+			closeConnector(connector)
+			return nil, err
+		}
+
+		return wrapConn(conn, connHooks{
+			onClose: func() {
+				closeConnector(connector)
+			},
+		}), nil
+	}
+
+	err = d.guard(info.DSN, func() error {
+		conn, err = d.Driver.Open(info.DSN)
+		return err
+	})
+
+	return conn, err
+}
+
+// innerConnector creates a connector for info, either with the function given
+// by the provider or with the inner driver, that must implement
+// driver.DriverContext in that case. Builds are bounded; see
+// WithRebuildLimit.
+func (d *Driver) innerConnector(info DSNInfo) (connector driver.Connector, err error) {
+	// Callers hold locks that connects wait on anyway, and connectors are
+	// quick to build; there's no context to give up on here.
+	release, _ := d.rebuilds.acquire(context.Background())
+	defer release()
+
+	err = d.guard(info.DSN, func() error {
+		if info.Connector != nil {
+			connector, err = info.Connector(info.DSN)
+		} else {
+			connector, err = d.Driver.(driver.DriverContext).OpenConnector(info.DSN)
+		}
+
+		return err
+	})
+
+	return connector, err
+}
+
+// innerConnect opens a connection with a connector from the inner driver,
+// created for innerDSN.
+func (d *Driver) innerConnect(ctx context.Context, connector driver.Connector,
+	innerDSN string) (conn driver.Conn, err error) {
+	err = d.guard(innerDSN, func() error {
+		conn, err = connector.Connect(ctx)
+		return err
+	})
+
+	return conn, err
+}
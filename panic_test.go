@@ -0,0 +1,102 @@
+package lazydsn_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// panicDriver panics on Open with what value returns for the DSN.
+type panicDriver struct {
+	value func(dsn string) any
+}
+
+func (d panicDriver) Open(dsn string) (driver.Conn, error) {
+	panic(d.value(dsn))
+}
+
+// panicDSN is the inner DSN that panics are raised for.
+const panicDSN = "postgres://app:s3cr3t@db:5432/orders"
+
+// panicValues returns panic values of every kind, echoing the DSN.
+var panicValues = map[string]func(string) any{
+	"string": func(dsn string) any {
+		return "bad DSN " + dsn
+	},
+	"error": func(dsn string) any {
+		return fmt.Errorf("parsing %s: %w", dsn, driver.ErrBadConn)
+	},
+	"other": func(dsn string) any {
+		return struct{ DSN string }{dsn}
+	},
+}
+
+// openPanicking opens a database whose inner driver panics with value.
+func openPanicking(t *testing.T, value func(string) any, opts ...lazydsn.Option) *sql.DB {
+	t.Helper()
+
+	connector, err := lazydsn.New(panicDriver{value}, lazydsntest.NewProvider(panicDSN), opts...).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// TestPanicRecovery checks that panics are turned into errors, without the
+// secrets in the DSN.
+func TestPanicRecovery(t *testing.T) {
+	for name, value := range panicValues {
+		t.Run(name, func(t *testing.T) {
+			err := openPanicking(t, value, lazydsn.WithPanicRecovery()).Ping()
+
+			var perr *lazydsn.PanicError
+
+			if !errors.As(err, &perr) {
+				t.Fatalf("got %v, want a *PanicError", err)
+			}
+
+			if strings.Contains(err.Error(), "s3cr3t") {
+				t.Errorf("got %q, leaking the password", err)
+			}
+
+			if name == "error" && !errors.Is(perr.Value.(error), driver.ErrBadConn) {
+				t.Errorf("got %v, want the chain kept", perr.Value)
+			}
+		})
+	}
+}
+
+// TestPanicScrubbed checks that panics are raised again, without the secrets
+// in the DSN, unless recovery is enabled.
+func TestPanicScrubbed(t *testing.T) {
+	for name, value := range panicValues {
+		t.Run(name, func(t *testing.T) {
+			db := openPanicking(t, value)
+
+			defer func() {
+				v := recover()
+
+				if v == nil {
+					t.Fatal("got no panic")
+				}
+
+				if s := fmt.Sprint(v); strings.Contains(s, "s3cr3t") || !strings.Contains(s, "[redacted]") {
+					t.Errorf("got %q, want the password redacted", s)
+				}
+			}()
+
+			db.Ping()
+		})
+	}
+}
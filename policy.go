@@ -0,0 +1,54 @@
+package lazydsn
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStaleCredential is returned, wrapped with details, when a credential is
+// older than the rotation policy allows and the policy is enforced.
+var ErrStaleCredential = errors.New("lazydsn: credential older than rotation policy allows")
+
+// RotationPolicy states how long credentials may stay in use. Set MaxAge to
+// the rotation interval of the secret, plus some slack. A credential's age is
+// taken from DSNInfo.Issued, when the provider reports it, or else from the
+// moment the driver first saw it, which is a lower bound.
+//
+// Violations are always reported with EventPolicy events. When Enforce is set,
+// new connections are refused too, turning a rotation process that silently
+// broke into an immediate, visible failure. Think twice before enforcing a
+// policy: refusing connections may take down an application that would
+// otherwise keep working just fine.
+type RotationPolicy struct {
+	MaxAge  time.Duration
+	Enforce bool
+}
+
+// checkPolicy verifies that the credentials just resolved for dsn (i.e.,
+// candidates) comply with the rotation policy, going by the first one. The
+// error returned, if any, must only prevent the connection if the policy is
+// enforced.
+func (d *Driver) checkPolicy(dsn string, candidates []DSNInfo) error {
+	if d.policy.MaxAge <= 0 {
+		return nil
+	}
+
+	info := candidates[0]
+	since := info.Issued
+
+	if since.IsZero() {
+		since = d.since(dsn, candidates)
+	}
+
+	age := d.clock.Now().Sub(since)
+
+	if age <= d.policy.MaxAge {
+		return nil
+	}
+
+	err := fmt.Errorf("%w (age %v, max %v)", ErrStaleCredential, age.Round(time.Second), d.policy.MaxAge)
+	d.emitFor(info, EventPolicy, ClassProvider, err)
+
+	return err
+}
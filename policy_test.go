@@ -0,0 +1,151 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// issuedProvider returns the DSN set last, along with when it was issued.
+type issuedProvider struct {
+	mu   sync.Mutex
+	info lazydsn.DSNInfo
+}
+
+func (p *issuedProvider) FetchDSN(string) (string, error) {
+	info, err := p.FetchDSNInfo(context.Background(), "")
+	return info.DSN, err
+}
+
+func (p *issuedProvider) FetchDSNInfo(context.Context, string) (lazydsn.DSNInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.info, nil
+}
+
+// set sets the DSN to return, and when it was issued.
+func (p *issuedProvider) set(dsn string, issued time.Time) {
+	p.mu.Lock()
+	p.info = lazydsn.DSNInfo{DSN: dsn, Issued: issued}
+	p.mu.Unlock()
+}
+
+// TestRotationPolicy checks that stale credentials are refused when the
+// policy is enforced, without being taken for a rotation, and only reported
+// otherwise.
+func TestRotationPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		enforce   bool
+		rotations int64
+	}{
+		{"enforced", true, 0},
+		{"warning", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := lazydsntest.NewClock(time.Now())
+			p := &issuedProvider{}
+			p.set("db1", clock.Now())
+
+			var (
+				mu       sync.Mutex
+				policies int
+			)
+
+			d := lazydsn.New(lazydsntest.NewDriver(), p,
+				lazydsn.WithClock(clock),
+				lazydsn.WithRotationPolicy(lazydsn.RotationPolicy{MaxAge: time.Hour, Enforce: tt.enforce}),
+				lazydsn.WithObserver(lazydsn.ObserverFunc(func(e lazydsn.Event) {
+					if e.Kind == lazydsn.EventPolicy {
+						mu.Lock()
+						policies++
+						mu.Unlock()
+					}
+				})),
+			)
+
+			connector, err := d.OpenConnector("master")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db := sql.OpenDB(connector)
+			db.SetMaxIdleConns(0)
+			defer db.Close()
+
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+
+			// A stale credential shows up, and then the fresh one is back.
+			p.set("db2", clock.Now().Add(-2*time.Hour))
+			err = db.Ping()
+
+			if tt.enforce != errors.Is(err, lazydsn.ErrStaleCredential) {
+				t.Errorf("got %v for a stale credential, with enforcement %v", err, tt.enforce)
+			}
+
+			p.set("db1", clock.Now())
+
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := d.Stats().Rotations; n != tt.rotations {
+				t.Errorf("got %d rotations, want %d", n, tt.rotations)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if policies != 1 {
+				t.Errorf("got %d policy events, want 1", policies)
+			}
+		})
+	}
+}
+
+// TestRotationPolicyFirstSeen checks that credentials without an issue time
+// are aged since they were first seen.
+func TestRotationPolicyFirstSeen(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("db1")
+	connector, err := lazydsn.New(lazydsntest.NewDriver(), p,
+		lazydsn.WithClock(clock),
+		lazydsn.WithRotationPolicy(lazydsn.RotationPolicy{MaxAge: time.Hour, Enforce: true}),
+	).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxIdleConns(0)
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(2 * time.Hour)
+
+	if err := db.Ping(); !errors.Is(err, lazydsn.ErrStaleCredential) {
+		t.Errorf("got %v, want %v", err, lazydsn.ErrStaleCredential)
+	}
+
+	p.Set("db2")
+
+	if err := db.Ping(); err != nil {
+		t.Errorf("got %v after a rotation, want none", err)
+	}
+}
@@ -0,0 +1,319 @@
+package proxy_test
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/proxy"
+)
+
+// listen returns a listener on a local port, closed with the test.
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	return l
+}
+
+// serveEcho runs a server that echoes back whatever it gets, and returns its
+// address.
+func serveEcho(t *testing.T) string {
+	l := listen(t)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+// connectProxy is an HTTP proxy supporting CONNECT, that only takes requests
+// authorized with the current password.
+type connectProxy struct {
+	addr string
+
+	mu       sync.Mutex
+	password string
+	targets  []string
+}
+
+// serveProxy runs a CONNECT proxy that takes user "u" with password.
+func serveProxy(t *testing.T, password string) *connectProxy {
+	l := listen(t)
+	p := &connectProxy{
+		addr:     l.Addr().String(),
+		password: password,
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go p.serve(conn)
+		}
+	}()
+
+	return p
+}
+
+func (p *connectProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+
+	if err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("u:"+p.password))
+	authorized := req.Method == http.MethodConnect && req.Header.Get("Proxy-Authorization") == auth
+
+	if authorized {
+		p.targets = append(p.targets, req.Host)
+	}
+
+	p.mu.Unlock()
+
+	if !authorized {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+
+	target, err := net.Dial("tcp", req.Host)
+
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+
+	defer target.Close()
+
+	io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// setPassword rotates the password that the proxy takes.
+func (p *connectProxy) setPassword(password string) {
+	p.mu.Lock()
+	p.password = password
+	p.mu.Unlock()
+}
+
+// credsProvider returns a fixed DSN, and the proxy currently set.
+type credsProvider struct {
+	dsn string
+
+	mu    sync.Mutex
+	proxy *url.URL
+}
+
+func (p *credsProvider) FetchDSN(string) (string, error) {
+	return p.dsn, nil
+}
+
+func (p *credsProvider) FetchProxy(context.Context, string) (*url.URL, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.proxy, nil
+}
+
+// setProxy sets the proxy to report.
+func (p *credsProvider) setProxy(u *url.URL) {
+	p.mu.Lock()
+	p.proxy = u
+	p.mu.Unlock()
+}
+
+// asis is an injector that leaves DSNs untouched.
+func asis(info lazydsn.DSNInfo, _ proxy.DialFunc) (lazydsn.DSNInfo, error) {
+	return info, nil
+}
+
+// echo dials addr with p, and checks that the connection goes through.
+func echo(p *proxy.Provider, addr string) error {
+	conn, err := p.DialContext(context.Background(), "tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+
+	if string(buf) != "ping" {
+		return errors.New("got " + string(buf) + " back")
+	}
+
+	return nil
+}
+
+// TestMySQL checks that DSNs are rewritten to use the registered network.
+func TestMySQL(t *testing.T) {
+	tests := []struct {
+		dsn  string
+		want string
+		err  error
+	}{
+		{"u:p@tcp(db:3306)/app", "u:p@lazydsn-proxy(db:3306)/app", nil},
+		{"u:p@w@rd@tcp(db:3306)/app?tls=true", "u:p@w@rd@lazydsn-proxy(db:3306)/app?tls=true", nil},
+		{"u:p@unix(/tmp/mysql.sock)/app", "u:p@unix(/tmp/mysql.sock)/app", nil},
+		{"garbage", "garbage", lazydsn.ErrUnknownFormat},
+	}
+
+	inject := proxy.MySQL("lazydsn-proxy")
+
+	for _, tt := range tests {
+		info, err := inject(lazydsn.DSNInfo{DSN: tt.dsn}, nil)
+
+		if !errors.Is(err, tt.err) {
+			t.Errorf("got %v for %q, want %v", err, tt.dsn, tt.err)
+		}
+
+		if info.DSN != tt.want {
+			t.Errorf("got %q for %q, want %q", info.DSN, tt.dsn, tt.want)
+		}
+	}
+}
+
+// TestDial checks that dialing goes through the proxy last reported for the
+// address, with its current credentials, or directly if there's none.
+func TestDial(t *testing.T) {
+	db := serveEcho(t)
+	hp := serveProxy(t, "old")
+	creds := &credsProvider{dsn: "postgres://app:secret@" + db + "/app"}
+	p := proxy.Wrap(creds, asis)
+
+	if err := echo(p, db); !errors.Is(err, proxy.ErrNoProxy) {
+		t.Fatalf("got %v before fetching, want %v", err, proxy.ErrNoProxy)
+	}
+
+	// Direct.
+	if _, err := p.FetchDSN("master"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := echo(p, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Through the proxy, as the password rotates.
+	for _, password := range []string{"old", "new"} {
+		hp.setPassword(password)
+		creds.setProxy(&url.URL{Scheme: "http", User: url.UserPassword("u", password), Host: hp.addr})
+
+		if _, err := p.FetchDSN("master"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := echo(p, db); err != nil {
+			t.Fatalf("got %v with password %q", err, password)
+		}
+	}
+
+	hp.mu.Lock()
+	targets := hp.targets
+	hp.mu.Unlock()
+
+	if len(targets) != 2 || targets[0] != db || targets[1] != db {
+		t.Errorf("got %q through the proxy, want %q twice", targets, db)
+	}
+
+	// A stale password is refused by the proxy.
+	hp.setPassword("newer")
+
+	if err := echo(p, db); err == nil {
+		t.Error("got a connection with a stale password, want an error")
+	}
+}
+
+// TestUnsupportedProxy checks that proxies with unknown schemes are refused
+// when fetching.
+func TestUnsupportedProxy(t *testing.T) {
+	creds := &credsProvider{
+		dsn:   "postgres://app:secret@db:5432/app",
+		proxy: &url.URL{Scheme: "ftp", Host: "proxy:21"},
+	}
+
+	if _, err := proxy.Wrap(creds, asis).FetchDSN("master"); !errors.Is(err, proxy.ErrUnsupportedProxy) {
+		t.Errorf("got %v, want %v", err, proxy.ErrUnsupportedProxy)
+	}
+}
+
+// TestConnector checks that the connector injector builds inner connectors
+// that dial through the provider.
+func TestConnector(t *testing.T) {
+	db := serveEcho(t)
+	creds := &credsProvider{dsn: "postgres://app:secret@" + db + "/app"}
+
+	var dialed error
+
+	p := proxy.Wrap(creds, proxy.Connector(func(innerDSN string, dial proxy.DialFunc) (driver.Connector, error) {
+		conn, err := dial(context.Background(), "tcp", db)
+
+		if err == nil {
+			conn.Close()
+		}
+
+		dialed = err
+
+		return nil, nil
+	}))
+
+	info, err := p.FetchDSNInfo(context.Background(), "master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Connector == nil {
+		t.Fatal("got no connector")
+	}
+
+	if _, err := info.Connector(info.DSN); err != nil || dialed != nil {
+		t.Errorf("got %v building the connector and %v dialing, want neither", err, dialed)
+	}
+}
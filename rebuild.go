@@ -0,0 +1,25 @@
+package lazydsn
+
+import (
+	"context"
+)
+
+// rebuildSlots bounds the work done by the driver when credentials change,
+// across all of its connectors; see WithRebuildLimit. A nil value means no
+// bound.
+type rebuildSlots chan struct{}
+
+// acquire waits for a free slot, or until ctx is done. The function returned
+// releases the slot.
+func (s rebuildSlots) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
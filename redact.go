@@ -0,0 +1,189 @@
+package lazydsn
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redacted replaces secrets in error messages.
+const redacted = "[redacted]"
+
+// secretKeys matches key/value pairs, as found in PostgreSQL keyword/value
+// DSNs, ODBC connection strings or URL query parameters, whose values must be
+// kept out of error messages. Values can be single quoted, enclosed in braces
+// (ODBC), or run up to the next separator.
+var secretKeys = regexp.MustCompile(`(?i)(?:^|[\s;&?])(?:` + secretKeyNames +
+	`)\s*=\s*('(?:[^'\\]|\\.)*'|\{(?:[^}]|\}\})*\}|[^\s;&]*)`)
+
+// secretKeyNames lists the names of DSN parameters holding secrets.
+const secretKeyNames = `password|passwd|pwd|pass|sslpassword|token|access_?token|secret|api_?key`
+
+// secretKey matches the name of a DSN parameter holding a secret.
+var secretKey = regexp.MustCompile(`(?i)^(?:` + secretKeyNames + `)$`)
+
+// secretsOf returns every substring of dsn that must not show up in error
+// messages, longest first. That includes the DSN itself, as drivers sometimes
+// echo it back, and passwords or tokens found by looking for the most common
+// DSN forms: URLs, MySQL's user:password@..., keyword/value pairs and ODBC.
+// This errs on the side of finding too much.
+func secretsOf(dsn string) []string {
+	if dsn == "" {
+		return nil
+	}
+
+	secrets := []string{dsn}
+	add := func(s string) {
+		if s == "" {
+			return
+		}
+
+		secrets = append(secrets, s)
+
+		if u, err := url.QueryUnescape(s); err == nil && u != s {
+			secrets = append(secrets, u)
+		}
+	}
+
+	if i := strings.Index(dsn, "://"); i >= 0 {
+		rest := dsn[i+3:]
+
+		if j := strings.LastIndex(rest, "@"); j >= 0 {
+			if k := strings.Index(rest[:j], ":"); k >= 0 {
+				add(rest[k+1 : j])
+			}
+		}
+	} else {
+		// MySQL style; the password runs from the first colon up to the
+		// last @ before the path. It can contain both characters, so we
+		// also consider the first @ and keep both candidates.
+		for _, j := range []int{strings.Index(dsn, "@"), strings.LastIndex(dsn, "@")} {
+			if j < 0 {
+				continue
+			}
+
+			// The user name can't look like a keyword/value pair.
+			if k := strings.Index(dsn[:j], ":"); k >= 0 && !strings.ContainsAny(dsn[:k], "= \t;") {
+				add(dsn[k+1 : j])
+			}
+		}
+	}
+
+	for _, m := range secretKeys.FindAllStringSubmatch(dsn, -1) {
+		v := m[1]
+		add(v)
+
+		if len(v) >= 2 && (v[0] == '\'' || v[0] == '{') {
+			add(v[1 : len(v)-1])
+		}
+	}
+
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	return secrets
+}
+
+// scrub replaces every secret found in dsn from s.
+func scrub(s, dsn string) string {
+	return scrubSecrets(s, secretsOf(dsn))
+}
+
+// scrubSecrets replaces every one of secrets from s; see secretsOf.
+func scrubSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+
+	return s
+}
+
+// MaskSecrets returns dsn with every password or token found in it replaced
+// by what mask returns for it. The same heuristics used to keep secrets out of
+// error messages apply, so this errs on the side of masking too much. It's
+// meant for tools that need to keep DSNs around, such as recordings of what a
+// provider returned, where knowing that a secret changed is enough.
+func MaskSecrets(dsn string, mask func(secret string) string) string {
+	for _, secret := range secretsOf(dsn) {
+		if secret != dsn {
+			dsn = strings.ReplaceAll(dsn, secret, mask(secret))
+		}
+	}
+
+	return dsn
+}
+
+// redactedError is an error whose message had secrets removed. It wraps
+// redacted copies of whatever the original error wrapped, so that errors.Is
+// keeps working with sentinel errors along the chain (database/sql itself
+// relies on that to detect driver.ErrBadConn), while nothing reachable from
+// it holds the secrets. Errors in the chain that hold no secrets are wrapped
+// as they are, so errors.As keeps finding those.
+type redactedError struct {
+	msg  string
+	errs []error
+}
+
+// Error returns the scrubbed error message.
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the redacted copies of the errors that the original wrapped.
+func (e *redactedError) Unwrap() []error {
+	return e.errs
+}
+
+// redact returns err with any secrets in dsn removed from its message, and
+// from those of the errors it wraps. Errors that don't contain secrets
+// anywhere along their chain are returned untouched.
+func redact(err error, dsn string) error {
+	if err == nil {
+		return nil
+	}
+
+	err, _ = redactSecrets(err, secretsOf(dsn))
+
+	return err
+}
+
+// redactSecrets returns err with secrets removed, as redact does, and whether
+// any were found.
+func redactSecrets(err error, secrets []string) (error, bool) {
+	var wrapped []error
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := u.Unwrap(); inner != nil {
+			wrapped = []error{inner}
+		}
+	case interface{ Unwrap() []error }:
+		wrapped = u.Unwrap()
+	}
+
+	msg := err.Error()
+	scrubbed := scrubSecrets(msg, secrets)
+	found := scrubbed != msg
+	errs := make([]error, 0, len(wrapped))
+
+	for _, inner := range wrapped {
+		if inner == nil {
+			continue
+		}
+
+		inner, innerFound := redactSecrets(inner, secrets)
+		found = found || innerFound
+		errs = append(errs, inner)
+	}
+
+	if !found {
+		return err, false
+	}
+
+	return &redactedError{
+		msg:  scrubbed,
+		errs: errs,
+	}, true
+}
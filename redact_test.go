@@ -0,0 +1,165 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// planted is a password full of characters that DSN formats treat specially.
+const planted = "s3cr3t:P@ss/w0rd?&;"
+
+// echoDriver fails every operation with an error quoting the DSN, both in full
+// and split into pieces, the way some drivers report parsing errors.
+type echoDriver struct {
+	cause error
+}
+
+func (d echoDriver) fail(dsn string) error {
+	return fmt.Errorf("invalid DSN %q (near %s): %w", dsn, dsn[len(dsn)/2:], d.cause)
+}
+
+func (d echoDriver) Open(dsn string) (driver.Conn, error) {
+	return nil, d.fail(dsn)
+}
+
+// echoContextDriver adds driver.DriverContext to echoDriver.
+type echoContextDriver struct {
+	echoDriver
+}
+
+func (d echoContextDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return nil, d.fail(dsn)
+}
+
+// innerDSNs holds the same planted password in every supported DSN form.
+var innerDSNs = map[string]string{
+	"mysql":    "app:" + planted + "@tcp(db:3306)/app?tls=true",
+	"url":      "postgres://app:" + url.QueryEscape(planted) + "@db:5432/app?sslmode=require",
+	"urlparam": "sqlserver://db:1433?database=app&password=" + url.QueryEscape(planted),
+	"keyvalue": "host=db user=app password='" + strings.ReplaceAll(planted, "'", `\'`) + "' dbname=app",
+	"odbc":     "Driver={ODBC Driver 18};Server=db;UID=app;PWD={" + planted + "};",
+}
+
+// chain returns err and every error reachable from it by unwrapping.
+func chain(err error) []error {
+	errs := []error{err}
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := u.Unwrap(); inner != nil {
+			errs = append(errs, chain(inner)...)
+		}
+	case interface{ Unwrap() []error }:
+		for _, inner := range u.Unwrap() {
+			errs = append(errs, chain(inner)...)
+		}
+	}
+
+	return errs
+}
+
+func TestErrorsNeverLeakSecrets(t *testing.T) {
+	for name, innerDSN := range innerDSNs {
+		innerDSN := innerDSN
+		provider := DSNProviderFunc(func(string) (string, error) {
+			return innerDSN, nil
+		})
+
+		for _, inner := range []driver.Driver{
+			echoDriver{cause: driver.ErrBadConn},
+			echoContextDriver{echoDriver{cause: driver.ErrBadConn}},
+		} {
+			var observed []error
+			d := New(inner, provider, WithObserver(ObserverFunc(func(e Event) {
+				if e.Err != nil {
+					observed = append(observed, e.Err)
+				}
+			})))
+
+			errs := []error{}
+
+			_, err := d.Open("master")
+			errs = append(errs, err)
+
+			c, err := d.OpenConnector("master")
+
+			if err == nil {
+				_, err = c.Connect(context.Background())
+			}
+
+			errs = append(errs, err)
+			errs = append(errs, observed...)
+
+			for _, err := range errs {
+				if err == nil {
+					t.Fatalf("%s: %T: expected an error", name, inner)
+				}
+
+				for _, e := range chain(err) {
+					msg := e.Error()
+
+					if strings.Contains(msg, planted) || strings.Contains(msg, url.QueryEscape(planted)) ||
+						strings.Contains(msg, innerDSN) {
+						t.Errorf("%s: %T: secret leaked in %q", name, inner, msg)
+					}
+				}
+
+				msg := err.Error()
+
+				if !errors.Is(err, driver.ErrBadConn) {
+					t.Errorf("%s: %T: original error lost in %q", name, inner, msg)
+				}
+			}
+		}
+	}
+}
+
+func TestRedactKeepsCleanErrors(t *testing.T) {
+	err := errors.New("Permission denied: connection refused")
+
+	for name, innerDSN := range innerDSNs {
+		if got := redact(err, innerDSN); got != err {
+			t.Errorf("%s: expected the original error, got %v", name, got)
+		}
+	}
+}
+
+// quietError holds a secret, but keeps it out of its message.
+type quietError struct {
+	err error
+}
+
+func (e quietError) Error() string { return "something went wrong" }
+func (e quietError) Unwrap() error { return e.err }
+
+// TestRedactChain checks that secrets are removed all along the chain of
+// wrapped errors, even under errors whose own message is clean, and that
+// sentinels in the chain are still found.
+func TestRedactChain(t *testing.T) {
+	dsn := innerDSNs["url"]
+	leaky := fmt.Errorf("dialing %s: %w", dsn, driver.ErrBadConn)
+	errs := []error{
+		fmt.Errorf("connecting: %w", leaky),
+		quietError{leaky},
+		errors.Join(errors.New("clean"), leaky),
+	}
+
+	for _, err := range errs {
+		got := redact(err, dsn)
+
+		for _, e := range chain(got) {
+			if msg := e.Error(); strings.Contains(msg, dsn) {
+				t.Errorf("%T: secret leaked in %q", err, msg)
+			}
+		}
+
+		if !errors.Is(got, driver.ErrBadConn) {
+			t.Errorf("%T: original error lost in %q", err, got)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Package redshift implements a lazydsn provider that mints temporary database
+credentials for Amazon Redshift clusters, with the GetClusterCredentials and
+GetClusterCredentialsWithIAM APIs. The master DSN is a Postgres URL for the
+cluster, without credentials, and the inner DSN is the same URL carrying the
+temporary user and password:
+
+	import awsredshift "github.com/aws/aws-sdk-go-v2/service/redshift"
+
+	client := awsredshift.NewFromConfig(cfg)
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(),
+		redshift.New(client, redshift.WithDBUser("app")),
+	)
+
+	db, err := sql.Open("lazydsn:pgx",
+		"postgres://analytics.abc123.us-east-1.redshift.amazonaws.com:5439/dev?sslmode=require")
+
+Temporary credentials last between 15 minutes and an hour, which is why
+they're better minted as connections are opened than handed to sql.Open once.
+By default, a new user and password is requested for every connection. Those
+are cheap enough, but lazydsn.WithRefreshInterval can reuse them for a while,
+as long as the interval is well below the duration (see WithDuration).
+
+The cluster identifier is taken from the first label of the host name, as it
+is in the endpoints that Redshift assigns; clusters reached through other
+names (a custom domain, an SSH tunnel, and so on) need WithCluster. The
+database name is taken from the path, and defaults to "dev".
+
+Every set of credentials looks like a rotation to the driver, which doesn't
+matter, except for lazydsn.WithRevocationGrace: Redshift keeps sessions open
+after their credentials expire, so connections shouldn't be drained just
+because newer credentials were minted.
+*/
+package redshift
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/gkristic/lazydsn"
+)
+
+// ErrNoCluster is returned when the cluster identifier can't be told from
+// the master DSN, and none was set with WithCluster.
+var ErrNoCluster = errors.New("redshift: unknown cluster identifier")
+
+// Client is the subset of the Redshift API used by the provider.
+// *redshift.Client implements it.
+type Client interface {
+	GetClusterCredentials(context.Context, *redshift.GetClusterCredentialsInput,
+		...func(*redshift.Options)) (*redshift.GetClusterCredentialsOutput, error)
+	GetClusterCredentialsWithIAM(context.Context, *redshift.GetClusterCredentialsWithIAMInput,
+		...func(*redshift.Options)) (*redshift.GetClusterCredentialsWithIAMOutput, error)
+}
+
+// Provider mints temporary credentials for Redshift clusters. It implements
+// lazydsn.InfoDSNProvider.
+type Provider struct {
+	client     Client
+	cluster    string
+	user       string
+	groups     []string
+	autoCreate bool
+	duration   time.Duration
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithCluster sets the identifier of the cluster, instead of taking it from
+// the host name in the master DSN.
+func WithCluster(id string) Option {
+	return func(p *Provider) {
+		p.cluster = id
+	}
+}
+
+// WithDBUser has credentials minted for the database user name, with
+// GetClusterCredentials. Otherwise, GetClusterCredentialsWithIAM is used,
+// and the user is derived by Redshift from the caller's IAM identity.
+func WithDBUser(user string) Option {
+	return func(p *Provider) {
+		p.user = user
+	}
+}
+
+// WithDBGroups sets the groups that the user joins for the session. It's
+// only meaningful along with WithDBUser.
+func WithDBGroups(groups ...string) Option {
+	return func(p *Provider) {
+		p.groups = groups
+	}
+}
+
+// WithAutoCreate has the user created if it doesn't exist yet. It's only
+// meaningful along with WithDBUser.
+func WithAutoCreate() Option {
+	return func(p *Provider) {
+		p.autoCreate = true
+	}
+}
+
+// WithDuration sets how long credentials last, between 15 minutes and an
+// hour. Redshift's default, 15 minutes, is used otherwise.
+func WithDuration(d time.Duration) Option {
+	return func(p *Provider) {
+		p.duration = d
+	}
+}
+
+// New creates a provider.
+func New(client Client, opts ...Option) *Provider {
+	p := &Provider{
+		client: client,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// FetchDSN implements the lazydsn.DSNProvider interface.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the lazydsn.FullDSNProvider interface.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo mints a new set of credentials for the cluster at dsn, and
+// returns dsn with them. They're reported as issued right away.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	u, err := url.Parse(dsn)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	cluster := p.cluster
+
+	if cluster == "" {
+		cluster, _, _ = strings.Cut(u.Hostname(), ".")
+	}
+
+	if cluster == "" {
+		return lazydsn.DSNInfo{}, ErrNoCluster
+	}
+
+	db := strings.TrimPrefix(u.Path, "/")
+
+	if db == "" {
+		db = "dev"
+	}
+
+	var duration *int32
+
+	if p.duration > 0 {
+		duration = aws.Int32(int32(p.duration / time.Second))
+	}
+
+	issued := time.Now()
+	var user, password string
+
+	if p.user != "" {
+		out, err := p.client.GetClusterCredentials(ctx, &redshift.GetClusterCredentialsInput{
+			ClusterIdentifier: aws.String(cluster),
+			DbName:            aws.String(db),
+			DbUser:            aws.String(p.user),
+			DbGroups:          p.groups,
+			AutoCreate:        aws.Bool(p.autoCreate),
+			DurationSeconds:   duration,
+		})
+
+		if err != nil {
+			return lazydsn.DSNInfo{}, err
+		}
+
+		user, password = aws.ToString(out.DbUser), aws.ToString(out.DbPassword)
+	} else {
+		out, err := p.client.GetClusterCredentialsWithIAM(ctx, &redshift.GetClusterCredentialsWithIAMInput{
+			ClusterIdentifier: aws.String(cluster),
+			DbName:            aws.String(db),
+			DurationSeconds:   duration,
+		})
+
+		if err != nil {
+			return lazydsn.DSNInfo{}, err
+		}
+
+		user, password = aws.ToString(out.DbUser), aws.ToString(out.DbPassword)
+	}
+
+	// Temporary users carry a prefix separated by a colon (e.g.,
+	// "IAM:app"), which url.UserPassword escapes as needed.
+	u.User = url.UserPassword(user, password)
+
+	return lazydsn.DSNInfo{
+		DSN:    u.String(),
+		Issued: issued,
+	}, nil
+}
+
+// Provider implements the lazydsn.InfoDSNProvider and lazydsn.FullDSNProvider
+// interfaces.
+var (
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.FullDSNProvider = &Provider{}
+)
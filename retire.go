@@ -0,0 +1,238 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRetired caps the number of retired credentials remembered. Connections
+// opened with credentials that old are long gone, as long as the pool's
+// ConnMaxLifetime is set; see Doctor.
+const maxRetired = 64
+
+// retirementState keeps track of the inner DSNs that rotations superseded, as
+// fingerprints, and of the connections open with every inner DSN. Current
+// inner DSNs are kept by master DSN fingerprint. See WithRevocationGrace.
+type retirementState struct {
+	mu      sync.Mutex
+	current map[string][]credential
+	retired map[string]*retiredDSN
+	live    map[string]*liveDSN
+}
+
+// liveDSN counts the connections open with an inner DSN.
+type liveDSN struct {
+	version string
+	open    int64
+}
+
+// credential is an inner DSN fingerprint, along with the version of the
+// secret it was built from, if known.
+type credential struct {
+	fp      string
+	version string
+}
+
+// retiredDSN tells when an inner DSN was superseded, for which master DSN
+// fingerprint, and whether the provider reported it revoked since.
+type retiredDSN struct {
+	credential
+	master  string
+	since   time.Time
+	revoked bool
+}
+
+// retire takes note of the candidates just resolved for dsn, retiring the
+// inner DSNs that were current until now, but aren't among them anymore.
+// Retired DSNs whose version any of the candidates reports as revoked (see
+// DSNInfo.Revoked) are marked as such.
+func (d *Driver) retire(dsn string, candidates []DSNInfo) {
+	if !d.retireConns {
+		return
+	}
+
+	key := d.fingerprint(dsn)
+	creds := make([]credential, len(candidates))
+	var revoked []string
+
+	for i, info := range candidates {
+		creds[i] = credential{d.fingerprint(info.DSN), info.Version}
+		revoked = append(revoked, info.Revoked...)
+	}
+
+	now := d.clock.Now()
+	s := &d.retirements
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		s.current = make(map[string][]credential)
+		s.retired = make(map[string]*retiredDSN)
+	}
+
+	for _, old := range s.current[key] {
+		if !slices.Contains(creds, old) {
+			s.retired[old.fp] = &retiredDSN{
+				credential: old,
+				master:     key,
+				since:      now,
+			}
+		}
+	}
+
+	// A rollback brings credentials back to life.
+	for _, cred := range creds {
+		delete(s.retired, cred.fp)
+	}
+
+	s.current[key] = creds
+
+	for _, r := range s.retired {
+		if r.master == key && r.version != "" && slices.Contains(revoked, r.version) {
+			r.revoked = true
+		}
+	}
+
+	if len(s.retired) > maxRetired {
+		s.forgetOldest()
+	}
+}
+
+// forgetOldest drops the credential retired the longest ago, preferring
+// those without connections left to retire. The caller must hold the lock.
+func (s *retirementState) forgetOldest() {
+	var oldest *retiredDSN
+
+	for _, r := range s.retired {
+		switch {
+		case oldest == nil:
+		case (s.live[r.fp] == nil) != (s.live[oldest.fp] == nil):
+			if s.live[r.fp] != nil {
+				continue
+			}
+		case !r.since.Before(oldest.since):
+			continue
+		}
+
+		oldest = r
+	}
+
+	delete(s.retired, oldest.fp)
+}
+
+// opened accounts for a new connection with cred.
+func (s *retirementState) opened(cred credential) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.live == nil {
+		s.live = make(map[string]*liveDSN)
+	}
+
+	l := s.live[cred.fp]
+
+	if l == nil {
+		l = &liveDSN{
+			version: cred.version,
+		}
+		s.live[cred.fp] = l
+	}
+
+	l.open++
+}
+
+// closed accounts for a connection with the inner DSN fingerprint fp being
+// closed.
+func (s *retirementState) closed(fp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l := s.live[fp]; l != nil {
+		if l.open--; l.open <= 0 {
+			delete(s.live, fp)
+		}
+	}
+}
+
+// stats returns the number of connections open by version, and how many of
+// them are open with retired credentials, yet to be drained.
+func (s *retirementState) stats() (map[string]int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make(map[string]int64)
+	var draining int64
+
+	for fp, l := range s.live {
+		versions[l.version] += l.open
+
+		if s.retired[fp] != nil {
+			draining += l.open
+		}
+	}
+
+	return versions, draining
+}
+
+// revoked tells whether the inner DSN with fingerprint fp is past its
+// revocation point, as of now: that's either when the provider reported it
+// revoked, or the grace period after it was retired, unless negative.
+func (s *retirementState) revoked(fp string, grace time.Duration, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.retired[fp]
+
+	return ok && (r.revoked || grace >= 0 && now.Sub(r.since) >= grace)
+}
+
+// guardRetired makes conn, just opened with info, unusable once its
+// credentials are past their revocation point: database/sql then gets
+// driver.ErrBadConn when about to reuse it, and closes it instead of putting
+// it back in the pool. Either way, it opens a new one with the current
+// credentials, rather than letting the next query fail to authenticate. Every
+// connection retired is reported with EventRetire, once. Connections are
+// accounted for by version meanwhile, so that the drain can be followed in
+// Stats.
+func (d *Driver) guardRetired(info DSNInfo, conn driver.Conn) driver.Conn {
+	if !d.retireConns {
+		return conn
+	}
+
+	cred := credential{d.fingerprint(info.DSN), info.Version}
+	d.retirements.opened(cred)
+
+	var retired atomic.Bool
+	retire := func() bool {
+		if !d.retirements.revoked(cred.fp, d.revocationGrace, d.clock.Now()) {
+			return false
+		}
+
+		if retired.CompareAndSwap(false, true) {
+			d.emit(EventRetire, ClassNone, nil)
+		}
+
+		return true
+	}
+
+	return wrapConn(conn, connHooks{
+		onClose: func() {
+			d.retirements.closed(cred.fp)
+		},
+		valid: func() bool {
+			return !retire()
+		},
+		reset: func(context.Context) error {
+			if retire() {
+				return driver.ErrBadConn
+			}
+
+			return nil
+		},
+	})
+}
@@ -0,0 +1,85 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestRevocationGrace checks that pooled connections opened with rotated
+// credentials are drained once the grace period is over, and not before.
+func TestRevocationGrace(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	inner := lazydsntest.NewDriver()
+	d := lazydsn.New(inner, p, lazydsn.WithClock(clock), lazydsn.WithRevocationGrace(time.Minute))
+
+	connector, err := d.OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+
+	// Hold two connections, so that both end up in the pool.
+	hold := func() {
+		t.Helper()
+		var conns []*sql.Conn
+
+		for range 2 {
+			conn, err := db.Conn(ctx)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conns = append(conns, conn)
+		}
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	hold()
+	p.Set("new")
+
+	// A new connection notices the rotation, but pooled ones are still
+	// good for a while.
+	db.SetMaxIdleConns(3)
+	conns := make([]*sql.Conn, 3)
+
+	for i := range conns {
+		if conns[i], err = db.Conn(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	if s := d.Stats(); s.Draining != 2 || s.Retired != 0 {
+		t.Fatalf("got %d connections draining and %d retired, want 2 and 0", s.Draining, s.Retired)
+	}
+
+	clock.Advance(time.Minute)
+	hold()
+
+	if s := d.Stats(); s.Draining != 0 || s.Retired != 2 {
+		t.Errorf("got %d connections draining and %d retired, want 0 and 2", s.Draining, s.Retired)
+	}
+
+	if dsns := inner.OpenDSNs(); slices.Contains(dsns, "old") {
+		t.Errorf("connections with retired credentials still open: %v", dsns)
+	}
+}
@@ -0,0 +1,58 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultRevokeTimeout bounds revocations, unless configured otherwise.
+const defaultRevokeTimeout = 10 * time.Second
+
+// A Revoker is a provider that hands out leased or session bound credentials,
+// such as Vault dynamic secrets or Boundary sessions, and is able to revoke
+// them. Revoke is called when the last connector for dsn is closed, which
+// happens when the last database opened with it is closed, so that abandoned
+// leases don't pile up on the secrets backend. Note that connections still
+// open at that point will stop working, if the backend kills them on
+// revocation. Revoke is given a context bounded by WithRevokeTimeout. To
+// share a provider among drivers, see Share.
+type Revoker interface {
+	Revoke(ctx context.Context, dsn string) error
+}
+
+// close accounts for a connector for dsn being closed, and for it no longer
+// using the provider; see Starter. The last connector for dsn also releases
+// what they share, by stopping health probes and dry runs, closing pre-warmed
+// connections, and asking the provider to revoke its credentials, if it
+// implements Revoker.
+func (d *Driver) close(dsn string, u *providerUse) error {
+	var err error
+
+	if d.release(dsn) {
+		d.stopProbes(dsn)
+		d.stopDryRuns(dsn)
+		d.warm.drop(d.fingerprint(dsn) + "\x00")
+
+		if d.revoker != nil {
+			err = d.revoke(dsn)
+		}
+	}
+
+	return errors.Join(err, d.stop(u))
+}
+
+// revoke asks the provider to revoke the credentials for dsn, within the
+// configured timeout; see WithRevokeTimeout.
+func (d *Driver) revoke(dsn string) error {
+	timeout := d.revokeTimeout
+
+	if timeout <= 0 {
+		timeout = defaultRevokeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.revoker.Revoke(ctx, dsn)
+}
@@ -0,0 +1,46 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// hungRevoker is a provider whose revocations hang until their context is
+// done.
+type hungRevoker struct {
+	*lazydsntest.Provider
+}
+
+func (hungRevoker) Revoke(ctx context.Context, _ string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// TestRevokeTimeout checks that closing the database doesn't wait forever for
+// a revocation that hangs.
+func TestRevokeTimeout(t *testing.T) {
+	p := hungRevoker{lazydsntest.NewProvider("db")}
+	connector, err := lazydsn.New(lazydsntest.NewDriver(), p,
+		lazydsn.WithRevokeTimeout(20*time.Millisecond),
+	).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,185 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrConnectorClosed is returned when connecting with a connector that was
+// closed already.
+var ErrConnectorClosed = errors.New("lazydsn: connector is closed")
+
+// roleKey is the context key for roles.
+type roleKey struct{}
+
+// ContextWithRole returns a copy of ctx carrying the given role, which selects
+// the credentials that connections are opened with; see WithRoles. Give it to
+// database/sql calls, e.g., db.QueryContext or db.Conn.
+func ContextWithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the role in ctx, if any.
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleKey{}).(string)
+
+	return role, ok
+}
+
+// roleConnector keeps a connector per role, each with its own master DSN,
+// created lazily the first time the role is requested.
+type roleConnector struct {
+	masterDSN string
+	driver    *Driver
+
+	mu         sync.Mutex
+	connectors map[string]driver.Connector
+	closed     bool
+}
+
+// Connect opens a new connection for the role in ctx, or the default one if
+// none.
+func (c *roleConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	role := c.driver.roleOf(ctx)
+	connector, err := c.connector(role)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := connector.Connect(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// database/sql picks pooled connections without knowing about roles,
+	// so those for other roles are refused when about to be reused. The
+	// pool discards them, and opens a new one for the right role. Not
+	// every connection goes through a reset first, though: those opened
+	// in the background (with no role, thus for the default one) are
+	// handed to waiting requests as they are. Those are checked when
+	// first used instead. Connections opened for a request that asked for
+	// a role are known to be right for it.
+	var checked atomic.Bool
+
+	if _, ok := RoleFromContext(ctx); ok {
+		checked.Store(true)
+	}
+
+	check := func(ctx context.Context) error {
+		if c.driver.roleOf(ctx) != role {
+			return driver.ErrBadConn
+		}
+
+		checked.Store(true)
+
+		return nil
+	}
+
+	return wrapConn(conn, connHooks{
+		reset: check,
+		use: func(ctx context.Context) error {
+			if checked.Load() {
+				return nil
+			}
+
+			return check(ctx)
+		},
+	}), nil
+}
+
+// connector returns the connector for role, opening it if needed.
+func (c *roleConnector) connector(role string) (driver.Connector, error) {
+	c.mu.Lock()
+
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrConnectorClosed
+	}
+
+	if connector, ok := c.connectors[role]; ok {
+		c.mu.Unlock()
+		return connector, nil
+	}
+
+	c.mu.Unlock()
+
+	// Opening a connector resolves the DSN, which may take a while; don't
+	// hold every other role back meanwhile.
+	connector, err := c.driver.openConnector(strings.ReplaceAll(c.masterDSN, "{role}", role))
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		closeConnector(connector)
+		return nil, ErrConnectorClosed
+	}
+
+	if existing, ok := c.connectors[role]; ok {
+		// Somebody else got here first.
+		closeConnector(connector)
+		return existing, nil
+	}
+
+	c.connectors[role] = connector
+
+	return connector, nil
+}
+
+// Driver returns the driver for the connector.
+func (c *roleConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Close is called by database/sql when the database is closed. The
+// connectors for every role are closed.
+func (c *roleConnector) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+
+	for _, connector := range c.connectors {
+		errs = append(errs, closeConnector(connector))
+	}
+
+	c.connectors = nil
+	c.closed = true
+
+	return errors.Join(errs...)
+}
+
+// roleConnector implements the driver.Connector and io.Closer interfaces.
+var (
+	_ driver.Connector = &roleConnector{}
+	_ io.Closer        = &roleConnector{}
+)
+
+// roleOf returns the role that connections requested with ctx are for.
+func (d *Driver) roleOf(ctx context.Context) string {
+	if role, ok := RoleFromContext(ctx); ok {
+		return role
+	}
+
+	return d.defaultRole
+}
+
+// closeConnector closes connector, if it supports that.
+func closeConnector(connector driver.Connector) error {
+	if closer, ok := connector.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestRoles checks that connections are opened with the credentials for the
+// role in context, and that pooled ones aren't reused across roles.
+func TestRoles(t *testing.T) {
+	inner := lazydsntest.NewDriver()
+	d := lazydsn.New(inner, lazydsn.DSNProviderFunc(func(dsn string) (string, error) {
+		return "db/" + dsn, nil
+	}), lazydsn.WithRoles("reader"))
+
+	connector, err := d.OpenConnector("{role}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	for _, role := range []string{"", "writer", "reader", "writer"} {
+		ctx := context.Background()
+		want := "db/reader"
+
+		if role != "" {
+			ctx = lazydsn.ContextWithRole(ctx, role)
+			want = "db/" + role
+		}
+
+		conn, err := db.Conn(ctx)
+
+		if err != nil {
+			t.Fatalf("role %q: %v", role, err)
+		}
+
+		err = conn.Raw(func(driverConn any) error {
+			if got := lazydsn.Unwrap(driverConn).(*lazydsntest.Conn).DSN(); got != want {
+				t.Errorf("role %q: got a connection for %s, want %s", role, got, want)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Close()
+	}
+}
+
+// TestRolesWaiting checks that connections opened in the background, and
+// handed to a request waiting for another role, aren't used for the latter.
+func TestRolesWaiting(t *testing.T) {
+	inner := lazydsntest.NewDriver()
+	d := lazydsn.New(inner, lazydsn.DSNProviderFunc(func(dsn string) (string, error) {
+		return "db/" + dsn, nil
+	}), lazydsn.WithRoles("reader"))
+
+	connector, err := d.OpenConnector("{role}")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	held, err := db.Conn(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+
+	go func() {
+		_, err := db.ExecContext(lazydsn.ContextWithRole(context.Background(), "writer"), "UPDATE")
+		done <- err
+	}()
+
+	for db.Stats().WaitCount == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Discarding the connection held makes database/sql open another one in
+	// the background, for the default role, and hand it to the writer.
+	_ = held.Raw(func(any) error {
+		return driver.ErrBadConn
+	})
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, conn := range inner.Conns() {
+		if conn.DSN() != "db/writer" && !conn.Closed() {
+			t.Errorf("connection for %s kept open, want it discarded", conn.DSN())
+		}
+	}
+
+	if dsns := inner.OpenDSNs(); !slices.Contains(dsns, "db/writer") {
+		t.Errorf("got connections for %v, want one for db/writer", dsns)
+	}
+}
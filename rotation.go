@@ -0,0 +1,81 @@
+package lazydsn
+
+import (
+	"sync"
+	"time"
+)
+
+// rotationTracker remembers the last inner DSN resolved for every master DSN,
+// so that the driver can tell when credentials were rotated. Both are stored
+// as fingerprints.
+type rotationTracker struct {
+	mu    sync.Mutex
+	inner map[string]trackedDSN
+}
+
+// trackedDSN is the last inner DSN seen for a master DSN, and when it was
+// first seen.
+type trackedDSN struct {
+	fp    string
+	since time.Time
+}
+
+// since returns when the inner DSNs just resolved for dsn (i.e., candidates)
+// were first seen, or the current time if they're new, without taking note
+// of them; see track.
+func (d *Driver) since(dsn string, candidates []DSNInfo) time.Time {
+	var key, fp digest
+	d.digest(dsn, &key)
+	d.digest(joinDSNs(candidates), &fp)
+
+	t := &d.rotations
+	t.mu.Lock()
+	prev, seen := t.inner[string(key[:])]
+	t.mu.Unlock()
+
+	if seen && prev.fp == string(fp[:]) {
+		return prev.since
+	}
+
+	return d.clock.Now()
+}
+
+// track compares the inner DSNs just resolved for dsn (i.e., candidates)
+// against the previous ones and accounts for a rotation if they differ. The
+// very first resolution for a master DSN is not a rotation, but it does set
+// the credential age.
+func (d *Driver) track(dsn string, candidates []DSNInfo) {
+	innerDSN := joinDSNs(candidates)
+	t := &d.rotations
+	t.mu.Lock()
+
+	if t.inner == nil {
+		t.inner = make(map[string]trackedDSN)
+	}
+
+	// This runs for every fetch; fingerprints are only allocated when
+	// there's something new to remember.
+	var key, fp digest
+	d.digest(dsn, &key)
+	d.digest(innerDSN, &fp)
+	prev, seen := t.inner[string(key[:])]
+
+	if seen && prev.fp == string(fp[:]) {
+		t.mu.Unlock()
+		return
+	}
+
+	now := d.clock.Now()
+	t.inner[string(key[:])] = trackedDSN{
+		fp:    string(fp[:]),
+		since: now,
+	}
+	t.mu.Unlock()
+
+	d.stats.credentialSince.Store(now.UnixNano())
+
+	if seen {
+		d.stats.rotations.Add(1)
+		d.emitFor(candidates[0], EventRotate, ClassNone, nil)
+	}
+}
@@ -0,0 +1,202 @@
+package lazydsn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrNoWindow is returned by ScheduledProvider before its first window starts.
+var ErrNoWindow = errors.New("lazydsn: no credential window in effect")
+
+// A Window is a set of credentials in effect from a given time on, until the
+// next window starts. DSN is the inner DSN to use meanwhile. Version
+// identifies the set (see DSNInfo.Version); the start time, in RFC 3339
+// format, is used if empty.
+type Window struct {
+	From    time.Time
+	DSN     string
+	Version string
+}
+
+// A Schedule tells when cut-overs happen, as the next one after a given time.
+// Parsed cron expressions from github.com/robfig/cron implement it.
+type Schedule interface {
+	Next(time.Time) time.Time
+}
+
+// Recurring returns the windows for a recurring rotation among dsns, which
+// take turns in order, starting over after the last one. The first window
+// starts at from, and a new one starts at every time that s gives, up to (and
+// excluding) until; e.g., with a monthly schedule and two DSNs, credentials
+// alternate every month. Versions are left for the provider to fill in.
+func Recurring(s Schedule, from, until time.Time, dsns ...string) []Window {
+	if len(dsns) == 0 {
+		return nil
+	}
+
+	var windows []Window
+
+	for t, i := from, 0; t.Before(until); i++ {
+		windows = append(windows, Window{
+			From: t,
+			DSN:  dsns[i%len(dsns)],
+		})
+
+		next := s.Next(t)
+
+		if !next.After(t) {
+			// Schedules return the zero time when there's nothing
+			// left, and we don't want to loop forever either way.
+			break
+		}
+
+		t = next
+	}
+
+	return windows
+}
+
+// ScheduledProvider picks the inner DSN among credentials distributed ahead of
+// time, by the time windows they're in effect for. It's meant for
+// organizations that stage rotations in advance, and flip credentials at
+// known cut-over times, rather than having applications ask an API. The
+// master DSN is ignored; use a provider per database.
+//
+// Clocks are never perfectly in sync, so for a while around every cut-over
+// (skew, either way), the credentials on the other side are reported as a
+// fallback, to be tried if the database rejects the current ones (see
+// DSNInfo.Fallbacks). Each window is reported as issued when it starts, and
+// credential versions are available for pinning; see VersionedDSNProvider.
+// The next window's credentials can be tried ahead of time; see WithDryRun.
+//
+// Cut-overs are only noticed when DSNs are fetched. Reusing resolutions (see
+// WithRefreshInterval) delays them by up to the refresh interval.
+type ScheduledProvider struct {
+	windows []Window
+	skew    time.Duration
+}
+
+// NewScheduledProvider creates a provider for the given windows, which don't
+// need to be in order. Times within skew of a cut-over are considered
+// uncertain, and get the credentials on both sides.
+func NewScheduledProvider(windows []Window, skew time.Duration) *ScheduledProvider {
+	windows = slices.Clone(windows)
+
+	slices.SortStableFunc(windows, func(a, b Window) int {
+		return a.From.Compare(b.From)
+	})
+
+	for i := range windows {
+		if windows[i].Version == "" {
+			windows[i].Version = windows[i].From.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return &ScheduledProvider{
+		windows: windows,
+		skew:    skew,
+	}
+}
+
+// FetchDSN implements the DSNProvider interface.
+func (p *ScheduledProvider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the FullDSNProvider interface.
+func (p *ScheduledProvider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo returns the credentials in effect now.
+func (p *ScheduledProvider) FetchDSNInfo(ctx context.Context, dsn string) (DSNInfo, error) {
+	return p.FetchDSNVersion(ctx, dsn, "")
+}
+
+// FetchDSNVersion returns the credentials with the given version, or those in
+// effect now if version is empty.
+func (p *ScheduledProvider) FetchDSNVersion(_ context.Context, _, version string) (DSNInfo, error) {
+	now := time.Now()
+
+	if version != "" {
+		i := slices.IndexFunc(p.windows, func(w Window) bool {
+			return w.Version == version
+		})
+
+		if i < 0 {
+			return DSNInfo{}, fmt.Errorf("lazydsn: unknown credential version %q", version)
+		}
+
+		return p.info(i, now), nil
+	}
+
+	i := p.current(now)
+
+	if i < 0 {
+		return DSNInfo{}, ErrNoWindow
+	}
+
+	return p.info(i, now), nil
+}
+
+// FetchStagedDSN returns the credentials for the next window, if any. See
+// StagedDSNProvider.
+func (p *ScheduledProvider) FetchStagedDSN(_ context.Context, _ string) (DSNInfo, error) {
+	now := time.Now()
+	i := p.current(now) + 1
+
+	if i >= len(p.windows) {
+		return DSNInfo{}, ErrNothingStaged
+	}
+
+	return p.info(i, now), nil
+}
+
+// current returns the index of the window in effect at now, which is the last
+// one that started already, or -1 if none did.
+func (p *ScheduledProvider) current(now time.Time) int {
+	i, found := slices.BinarySearchFunc(p.windows, now, func(w Window, t time.Time) int {
+		return w.From.Compare(t)
+	})
+
+	if !found {
+		i--
+	}
+
+	return i
+}
+
+// info describes window i, with fallbacks for the windows around it if now is
+// close to a cut-over.
+func (p *ScheduledProvider) info(i int, now time.Time) DSNInfo {
+	w := p.windows[i]
+	info := DSNInfo{
+		DSN:     w.DSN,
+		Version: w.Version,
+		Issued:  w.From,
+	}
+
+	if i > 0 && now.Sub(w.From) < p.skew {
+		info.Fallbacks = append(info.Fallbacks, p.windows[i-1].Version)
+	}
+
+	if i+1 < len(p.windows) && p.windows[i+1].From.Sub(now) < p.skew {
+		info.Fallbacks = append(info.Fallbacks, p.windows[i+1].Version)
+	}
+
+	return info
+}
+
+// ScheduledProvider implements the FullDSNProvider, InfoDSNProvider,
+// VersionedDSNProvider and StagedDSNProvider interfaces.
+var (
+	_ FullDSNProvider      = &ScheduledProvider{}
+	_ InfoDSNProvider      = &ScheduledProvider{}
+	_ VersionedDSNProvider = &ScheduledProvider{}
+	_ StagedDSNProvider    = &ScheduledProvider{}
+)
@@ -0,0 +1,144 @@
+package lazydsn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash"
+	"runtime"
+	"slices"
+)
+
+// fingerprint returns what the driver retains of s for the sole purpose of
+// detecting changes: a salted digest, so that no plaintext copies of resolved
+// DSNs are kept around after connections are open. Digests are also shorter
+// than most DSNs, which makes comparing them cheaper.
+func (d *Driver) fingerprint(s string) string {
+	var sum digest
+	d.digest(s, &sum)
+
+	return string(sum[:])
+}
+
+// digest is a fingerprint that doesn't need to be allocated. Maps keyed by
+// fingerprints can be looked up with string(sum[:]) for free.
+type digest [sha256.Size]byte
+
+// digest computes the fingerprint of s into sum.
+func (d *Driver) digest(s string, sum *digest) {
+	// This runs several times for every new connection; keyed hashes are
+	// expensive to set up, so we reuse them.
+	h, ok := d.hashes.Get().(*hasher)
+
+	if !ok {
+		h = &hasher{
+			mac: hmac.New(sha256.New, d.salt[:]),
+		}
+	}
+
+	h.mac.Reset()
+	h.buf = append(h.buf[:0], s...)
+	h.mac.Write(h.buf)
+	*sum = digest(h.mac.Sum(h.sum[:0]))
+
+	// Don't leave plaintext behind.
+	clear(h.buf)
+	d.hashes.Put(h)
+}
+
+// hasher is a keyed hash, along with buffers to feed it and read its sum
+// without allocating.
+type hasher struct {
+	mac hash.Hash
+	buf []byte
+	sum digest
+}
+
+// secret holds a DSN in memory that is locked (i.e., never swapped to disk,
+// on platforms where that is supported), and wiped once it's no longer
+// reachable. Go strings can't be wiped, so that's how resolutions kept for
+// reuse are held under memory hardening; see WithMemoryHardening.
+type secret struct {
+	buf []byte
+}
+
+// newSecret copies s into locked memory. Note that s itself remains wherever
+// it was; callers should drop any references to it as soon as possible.
+func newSecret(s string) *secret {
+	sec := &secret{
+		buf: allocLocked(len(s)),
+	}
+
+	copy(sec.buf, s)
+	runtime.SetFinalizer(sec, (*secret).destroy)
+
+	return sec
+}
+
+// String returns a copy of the secret. The copy lives in regular memory, so
+// it should be kept only for as long as strictly needed.
+func (s *secret) String() string {
+	v := string(s.buf)
+	runtime.KeepAlive(s)
+
+	return v
+}
+
+// equal reports whether the secret matches v, in constant time. A nil secret
+// matches nothing.
+func (s *secret) equal(v string) bool {
+	if s == nil || len(s.buf) != len(v) {
+		return false
+	}
+
+	var diff byte
+
+	for i := range s.buf {
+		diff |= s.buf[i] ^ v[i]
+	}
+
+	runtime.KeepAlive(s)
+
+	return diff == 0
+}
+
+// destroy wipes the secret and releases its memory.
+func (s *secret) destroy() {
+	clear(s.buf)
+	freeLocked(s.buf)
+	s.buf = nil
+}
+
+// seal returns candidates with their DSNs moved into secrets, for them to be
+// kept for reuse under memory hardening. Candidates are returned as they are
+// otherwise, or if sealed already. See unseal.
+func (d *Driver) seal(candidates []DSNInfo) []DSNInfo {
+	if !d.hardened || len(candidates) == 0 || candidates[0].sealed != nil {
+		return candidates
+	}
+
+	sealed := slices.Clone(candidates)
+
+	for i := range sealed {
+		sealed[i].sealed = newSecret(sealed[i].DSN)
+		sealed[i].DSN = ""
+	}
+
+	return sealed
+}
+
+// unseal returns candidates with their DSNs back in place, if sealed, for the
+// duration of a connection attempt. See seal.
+func unseal(candidates []DSNInfo) []DSNInfo {
+	if len(candidates) == 0 || candidates[0].sealed == nil {
+		return candidates
+	}
+
+	plain := slices.Clone(candidates)
+
+	for i := range plain {
+		plain[i].DSN = plain[i].sealed.String()
+		plain[i].sealed = nil
+	}
+
+	return plain
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package lazydsn
+
+import (
+	"syscall"
+)
+
+// allocLocked returns a buffer of n bytes, mapped outside of the Go heap and
+// locked into physical memory. If locking is not possible (e.g., because of
+// RLIMIT_MEMLOCK), the buffer is still returned, unlocked; it will be wiped
+// on release anyway.
+func allocLocked(n int) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	buf, err := syscall.Mmap(-1, 0, n, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON)
+
+	if err != nil {
+		return make([]byte, n)
+	}
+
+	_ = syscall.Mlock(buf)
+
+	return buf
+}
+
+// freeLocked releases a buffer obtained from allocLocked. The caller is
+// expected to have wiped it already.
+func freeLocked(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
+
+	// Both calls fail harmlessly for buffers that came from the heap when
+	// mapping wasn't possible; Munmap only releases what Mmap returned.
+	_ = syscall.Munlock(buf)
+	_ = syscall.Munmap(buf)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package lazydsn
+
+// allocLocked returns a regular buffer of n bytes. Memory locking is not
+// supported on this platform; buffers are still wiped on release.
+func allocLocked(n int) []byte {
+	return make([]byte, n)
+}
+
+// freeLocked is a no-op on this platform.
+func freeLocked(_ []byte) {
+}
@@ -0,0 +1,59 @@
+package lazydsn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestSealedSnapshots checks that, under memory hardening, neither snapshots
+// nor shared resolutions hold plaintext DSNs, while connections still get
+// them.
+func TestSealedSnapshots(t *testing.T) {
+	const dsn = "postgres://app:" + planted + "@db:5432/app"
+
+	d := New(nopContextDriver{}, DSNProviderFunc(func(string) (string, error) {
+		return dsn, nil
+	}), WithRefreshInterval(time.Minute), WithMemoryHardening())
+
+	connector, err := d.OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := connector.(*nativeConnector)
+	conn, err := c.Connect(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+
+	snap := c.snapshots.current.Load()
+
+	if snap == nil {
+		t.Fatal("got no snapshot")
+	}
+
+	res, err := d.resolution(context.Background(), "master", d.versions.generation.Load(), time.Minute)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, candidates := range [][]DSNInfo{snap.candidates, res.value} {
+		if info := candidates[0]; info.DSN != "" || !info.sealed.equal(dsn) {
+			t.Errorf("got DSN %q kept in the clear, want it sealed", info.DSN)
+		}
+	}
+
+	if got := snap.unsealed().candidates[0].DSN; got != dsn {
+		t.Errorf("got %q unsealed, want %q", got, dsn)
+	}
+
+	if snap.connector(dsn) == nil {
+		t.Error("got no connector for the sealed DSN")
+	}
+}
@@ -0,0 +1,161 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrServerChanged is returned, wrapped with details, when a connection with
+// new credentials reaches a different server than before. See
+// WithServerIdentity.
+var ErrServerChanged = errors.New("lazydsn: server identity changed")
+
+// A ServerIdentity tells which logical server conn is connected to. Values
+// are opaque; they're only compared with each other. See WithServerIdentity
+// and QueryServerIdentity.
+type ServerIdentity func(ctx context.Context, conn driver.Conn) (string, error)
+
+// QueryServerIdentity returns a ServerIdentity that runs query, and takes the
+// first column of the first row as the identity. The query should return
+// something that's unique to the logical database, and that survives
+// failovers, which may happen at any time; e.g., the system identifier for
+// PostgreSQL, that's shared by physical replicas:
+//
+//	SELECT system_identifier FROM pg_control_system()
+//
+// Or the server UUID for MySQL, if failovers are handled at the storage level,
+// as with Aurora or Cloud SQL:
+//
+//	SELECT @@server_uuid
+func QueryServerIdentity(query string) ServerIdentity {
+	return func(ctx context.Context, conn driver.Conn) (string, error) {
+		return queryValue(ctx, conn, query)
+	}
+}
+
+// serverState keeps the server identity seen for every master DSN and
+// endpoint.
+type serverState struct {
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+// verifyServer checks that conn, just opened with new credentials in info,
+// reaches the same server as connections for the same master DSN (and
+// endpoint) did before. The first identity seen is remembered for good; a
+// different one afterwards is reported with EventIdentity, and refused.
+func (d *Driver) verifyServer(ctx context.Context, info DSNInfo, conn driver.Conn) error {
+	if d.serverIdentity == nil {
+		return nil
+	}
+
+	timeout := d.validateTimeout
+
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var id string
+
+	err := d.guard(info.DSN, func() (err error) {
+		id, err = d.serverIdentity(ctx, conn)
+		return err
+	})
+
+	if err != nil {
+		return redact(fmt.Errorf("lazydsn: verifying server identity: %w", err), info.DSN)
+	}
+
+	key := info.master + "\x00" + info.Endpoint
+	s := &d.servers
+	s.mu.Lock()
+
+	if s.ids == nil {
+		s.ids = make(map[string]string)
+	}
+
+	prev, seen := s.ids[key]
+
+	if !seen {
+		s.ids[key] = id
+	}
+
+	s.mu.Unlock()
+
+	if !seen || prev == id {
+		return nil
+	}
+
+	err = fmt.Errorf("%w from %q to %q", ErrServerChanged, prev, id)
+	d.emitFor(info, EventIdentity, ClassProvider, err)
+
+	return err
+}
+
+// queryValue runs query on conn, and returns the first column of the first
+// row, as a string.
+func queryValue(ctx context.Context, conn driver.Conn, query string) (string, error) {
+	var (
+		rows driver.Rows
+		err  = driver.ErrSkip
+	)
+
+	if q, ok := conn.(driver.QueryerContext); ok {
+		rows, err = q.QueryContext(ctx, query, nil)
+	}
+
+	if errors.Is(err, driver.ErrSkip) {
+		var stmt driver.Stmt
+
+		if p, ok := conn.(driver.ConnPrepareContext); ok {
+			stmt, err = p.PrepareContext(ctx, query)
+		} else {
+			stmt, err = conn.Prepare(query)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		defer stmt.Close()
+
+		if q, ok := stmt.(driver.StmtQueryContext); ok {
+			rows, err = q.QueryContext(ctx, nil)
+		} else {
+			rows, err = stmt.Query(nil) //nolint:staticcheck
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rows.Close()
+
+	dest := make([]driver.Value, len(rows.Columns()))
+
+	if len(dest) == 0 {
+		return "", errors.New("lazydsn: query returned no columns")
+	}
+
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			return "", errors.New("lazydsn: query returned no rows")
+		}
+
+		return "", err
+	}
+
+	if b, ok := dest[0].([]byte); ok {
+		return string(b), nil
+	}
+
+	return fmt.Sprint(dest[0]), nil
+}
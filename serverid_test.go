@@ -0,0 +1,52 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestServerIdentity checks that rotations are only accepted as long as they
+// keep pointing to the same server.
+func TestServerIdentity(t *testing.T) {
+	p := lazydsntest.NewProvider("a/v1")
+	d := lazydsn.New(lazydsntest.NewDriver(), p, lazydsn.WithServerIdentity(
+		func(_ context.Context, conn driver.Conn) (string, error) {
+			// Test DSNs name the server before the slash.
+			server, _, _ := strings.Cut(conn.(*lazydsntest.Conn).DSN(), "/")
+			return server, nil
+		},
+	))
+
+	connector, err := d.OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	// Keep every connection new, so that each one sees the rotation.
+	db.SetMaxIdleConns(0)
+
+	for _, dsn := range []string{"a/v1", "a/v2"} {
+		p.Set(dsn)
+
+		if err := db.Ping(); err != nil {
+			t.Fatalf("rotating to %s: %v", dsn, err)
+		}
+	}
+
+	p.Set("b/v3")
+
+	if err := db.Ping(); !errors.Is(err, lazydsn.ErrServerChanged) {
+		t.Errorf("got %v rotating to a different server, want %v", err, lazydsn.ErrServerChanged)
+	}
+}
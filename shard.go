@@ -0,0 +1,267 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by Shards.
+var (
+	ErrNoShard      = errors.New("lazydsn: no shard in context")
+	ErrShardsClosed = errors.New("lazydsn: shards are closed")
+)
+
+// shardKey is the context key for shard identifiers.
+type shardKey struct{}
+
+// ContextWithShard returns a copy of ctx carrying the given shard identifier.
+// See Shards.DBContext. The context given to database/sql calls makes it down
+// to providers too, when a new connection is needed, so providers can also
+// resolve DSNs by shard with ShardFromContext.
+func ContextWithShard(ctx context.Context, shard string) context.Context {
+	return context.WithValue(ctx, shardKey{}, shard)
+}
+
+// ShardFromContext returns the shard identifier in ctx, if any.
+func ShardFromContext(ctx context.Context) (string, bool) {
+	shard, ok := ctx.Value(shardKey{}).(string)
+
+	return shard, ok
+}
+
+// defaultShardGrace is how long pools are safe from eviction after being
+// handed out, unless configured otherwise; see ShardConfig.
+const defaultShardGrace = 30 * time.Second
+
+// ShardConfig describes a sharded fleet of databases, all backed by the same
+// inner driver and provider. Pool describes the pool for every shard; its DSN
+// is a template for the master DSN, where "{shard}" is replaced by the shard
+// identifier (an empty DSN stands for the identifier alone). MaxShards caps
+// the number of pools kept open, and IdleTimeout closes the pools for shards
+// that weren't requested for that long; either is disabled when zero. Pools
+// are never evicted within Grace (30s, if zero) of being handed out, so that
+// callers get to use them.
+type ShardConfig struct {
+	Driver      driver.Driver
+	Pool        PoolConfig
+	MaxShards   int
+	IdleTimeout time.Duration
+	Grace       time.Duration
+}
+
+// Shards manages a pool per shard, opened lazily the first time each shard
+// is requested. All pools share a single Driver, so that stats and events
+// account for the whole fleet; the provider gets the master DSN for each
+// shard, as usual.
+//
+// Pools over the cap, or idle for too long, are evicted (i.e., closed) when
+// other shards are requested. Pools with connections in use, or handed out
+// within the grace period (see ShardConfig), are never evicted, so the cap can
+// be exceeded temporarily. Still, don't hold on to the *sql.DB for a shard
+// longer than the grace period; request it again instead.
+type Shards struct {
+	driver *Driver
+	cfg    ShardConfig
+
+	mu     sync.Mutex
+	pools  map[string]*shardPool
+	closed bool
+}
+
+// shardPool is the pool for a single shard.
+type shardPool struct {
+	db   *sql.DB
+	used time.Time
+}
+
+// OpenShards prepares a fleet of shards, as described by cfg. Pools are only
+// opened on demand.
+func OpenShards(cfg ShardConfig) (*Shards, error) {
+	if cfg.Pool.Provider == nil {
+		return nil, errors.New("lazydsn: shards need a provider")
+	}
+
+	return &Shards{
+		driver: New(cfg.Driver, cfg.Pool.Provider, cfg.Pool.Options...),
+		cfg:    cfg,
+		pools:  make(map[string]*shardPool),
+	}, nil
+}
+
+// Driver returns the driver shared by all shards.
+func (s *Shards) Driver() *Driver {
+	return s.driver
+}
+
+// DB returns the pool for the given shard, opening it if needed.
+func (s *Shards) DB(shard string) (*sql.DB, error) {
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrShardsClosed
+	}
+
+	if p, ok := s.pools[shard]; ok {
+		p.used = s.driver.clock.Now()
+		victims := s.evict(shard)
+		s.mu.Unlock()
+		closeAll(victims)
+
+		return p.db, nil
+	}
+
+	s.mu.Unlock()
+
+	// Opening a pool resolves the DSN, which may take a while; don't hold
+	// every other shard back meanwhile.
+	db, err := s.open(shard)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		db.Close()
+
+		return nil, ErrShardsClosed
+	}
+
+	if p, ok := s.pools[shard]; ok {
+		// Somebody else got here first.
+		p.used = s.driver.clock.Now()
+		s.mu.Unlock()
+		db.Close()
+
+		return p.db, nil
+	}
+
+	s.pools[shard] = &shardPool{
+		db:   db,
+		used: s.driver.clock.Now(),
+	}
+
+	victims := s.evict(shard)
+	s.mu.Unlock()
+	closeAll(victims)
+
+	return db, nil
+}
+
+// DBContext returns the pool for the shard in ctx. See ContextWithShard.
+func (s *Shards) DBContext(ctx context.Context) (*sql.DB, error) {
+	shard, ok := ShardFromContext(ctx)
+
+	if !ok {
+		return nil, ErrNoShard
+	}
+
+	return s.DB(shard)
+}
+
+// open opens the pool for a shard.
+func (s *Shards) open(shard string) (*sql.DB, error) {
+	cfg := s.cfg.Pool
+	cfg.DSN = shard
+
+	if s.cfg.Pool.DSN != "" {
+		cfg.DSN = strings.ReplaceAll(s.cfg.Pool.DSN, "{shard}", shard)
+	}
+
+	connector, err := s.driver.OpenConnector(cfg.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db := sql.OpenDB(connector)
+	configurePool(db, cfg)
+	warmUp(db, cfg)
+
+	return db, nil
+}
+
+// evict removes the pools that are idle for too long, and then the least
+// recently used ones while over the cap, and returns them to be closed. The
+// pool for keep, and those handed out within the grace period, are never
+// evicted. The caller must hold the lock.
+func (s *Shards) evict(keep string) []*sql.DB {
+	var (
+		victims    []*sql.DB
+		candidates []string
+	)
+
+	now := s.driver.clock.Now()
+	grace := s.cfg.Grace
+
+	if grace <= 0 {
+		grace = defaultShardGrace
+	}
+
+	for shard, p := range s.pools {
+		if shard == keep || now.Sub(p.used) < grace || p.db.Stats().InUse > 0 {
+			continue
+		}
+
+		if s.cfg.IdleTimeout > 0 && now.Sub(p.used) > s.cfg.IdleTimeout {
+			victims = append(victims, p.db)
+			delete(s.pools, shard)
+		} else {
+			candidates = append(candidates, shard)
+		}
+	}
+
+	if s.cfg.MaxShards <= 0 || len(s.pools) <= s.cfg.MaxShards {
+		return victims
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return s.pools[candidates[i]].used.Before(s.pools[candidates[j]].used)
+	})
+
+	for _, shard := range candidates {
+		if len(s.pools) <= s.cfg.MaxShards {
+			break
+		}
+
+		victims = append(victims, s.pools[shard].db)
+		delete(s.pools, shard)
+	}
+
+	return victims
+}
+
+// closeAll closes evicted pools. Errors are dropped; there's nobody waiting
+// for them.
+func closeAll(dbs []*sql.DB) {
+	for _, db := range dbs {
+		db.Close()
+	}
+}
+
+// Close closes the pools for every shard. Shards can't be requested after
+// that.
+func (s *Shards) Close() error {
+	s.mu.Lock()
+	pools := s.pools
+	s.pools = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	var errs []error
+
+	for _, p := range pools {
+		errs = append(errs, p.db.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,55 @@
+package lazydsn_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// TestShardGrace checks that a pool just handed out survives requests for
+// other shards, even when over the cap and not yet used, and that it's
+// evicted once the grace period is over.
+func TestShardGrace(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	shards, err := lazydsn.OpenShards(lazydsn.ShardConfig{
+		Driver: lazydsntest.NewDriver(),
+		Pool: lazydsn.PoolConfig{
+			Provider: lazydsntest.NewProvider("db"),
+			Options:  []lazydsn.Option{lazydsn.WithClock(clock)},
+		},
+		MaxShards: 1,
+		Grace:     time.Minute,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer shards.Close()
+
+	a, err := shards.DB("a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := shards.DB("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Ping(); err != nil {
+		t.Fatalf("got %v for a pool within the grace period, want it open", err)
+	}
+
+	clock.Advance(time.Minute)
+
+	if _, err := shards.DB("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Ping(); err == nil {
+		t.Error("got a pool over the cap open after the grace period, want it evicted")
+	}
+}
@@ -0,0 +1,321 @@
+package lazydsn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SharedProvider shares a provider among several drivers; e.g., those for
+// separate OLTP and reporting pools against the same database, registered with
+// different aliases and options. Responses are cached for ttl, by master DSN
+// (and version; see VersionedDSNProvider), and concurrent requests for the
+// same one are coalesced, so that the load on the backend scales with the
+// number of distinct secrets, rather than with the number of pools:
+//
+//	shared := lazydsn.Share(provider, time.Minute)
+//	lazydsn.Register("lazydsn:oltp", inner, shared,
+//		lazydsn.WithRotationPing(time.Second),
+//	)
+//	lazydsn.Register("lazydsn:reports", inner, shared)
+//
+// Drivers see the provider as if it was given directly, with the same
+// interfaces; each one still detects rotations, and verifies, decorates and
+// checks DSNs, with its own options. Providers that need to be started (see
+// Starter) are started once for all drivers, and closed once every connector
+// of every driver is closed. Likewise, credentials are only revoked (see
+// Revoker) once the last connector for their master DSN is closed, across
+// drivers. Staged credentials (see StagedDSNProvider) aren't cached.
+//
+// Drivers have their own resolutions too (see WithRefreshInterval), so a
+// rotation may take up to ttl longer to be noticed. Keep ttl short, and below
+// the refresh interval if any; a few seconds already spare the backend from
+// bursts of pools connecting at once. Credentials rejected by the database
+// are only fetched again from the backend once the ttl is up, so retries in
+// the meantime get the same ones; a ttl of zero only coalesces requests. So
+// does hardening any of the drivers; see WithMemoryHardening. Responses are
+// timed by the clock of the driver asking for them (see WithClock).
+type SharedProvider struct {
+	inner DSNProvider
+	ttl   time.Duration
+	salt  [16]byte
+
+	mu        sync.Mutex
+	hardened  bool
+	masters   map[string]int
+	flights   flights[any]
+	lifecycle lifecycleState
+}
+
+// Share returns a SharedProvider for p, caching its responses for ttl.
+func Share(p DSNProvider, ttl time.Duration) *SharedProvider {
+	s := &SharedProvider{
+		inner: p,
+		ttl:   ttl,
+	}
+
+	// As with drivers, the salt only has to be unpredictable.
+	_, _ = rand.Read(s.salt[:])
+
+	return s
+}
+
+// FetchDSN implements the DSNProvider interface, going through the cache.
+func (s *SharedProvider) FetchDSN(dsn string) (string, error) {
+	return s.full(nil).FetchDSNWithContext(context.Background(), dsn)
+}
+
+// full returns the inner provider as a FullDSNProvider, going through the
+// cache on behalf of d, if any.
+func (s *SharedProvider) full(d *Driver) FullDSNProvider {
+	fdsnp, ok := s.inner.(FullDSNProvider)
+
+	if !ok {
+		fdsnp = fullProvider{
+			DSNProvider: s.inner,
+		}
+	}
+
+	return sharedFull{s, d, fdsnp}
+}
+
+// bind makes drv reach the inner provider through the cache. The provider
+// interfaces found by New are replaced, as long as drv uses them. It must be
+// called once drv's options are applied.
+func (s *SharedProvider) bind(drv *Driver) {
+	s.mu.Lock()
+	s.hardened = s.hardened || drv.hardened
+	s.mu.Unlock()
+
+	drv.dsnp = s.full(drv)
+
+	if drv.idsnp != nil {
+		drv.idsnp = sharedInfo{s, drv, drv.idsnp}
+	}
+
+	if drv.vdsnp != nil {
+		drv.vdsnp = sharedVersioned{s, drv, drv.vdsnp}
+	}
+
+	if drv.mdsnp != nil {
+		drv.mdsnp = sharedMulti{s, drv, drv.mdsnp}
+	}
+
+	if drv.bgdsnp != nil {
+		drv.bgdsnp = sharedBlueGreen{s, drv, drv.bgdsnp}
+	}
+
+	if drv.revoker != nil {
+		drv.revoker = sharedRevoker{s}
+		drv.shared = s
+	}
+
+	if drv.starter != nil || drv.closer != nil {
+		drv.starter = sharedLifecycle{s}
+		drv.closer = sharedLifecycle{s}
+	}
+}
+
+// key returns the cache key for a request, as a fingerprint.
+func (s *SharedProvider) key(kind, dsn, version string) string {
+	mac := hmac.New(sha256.New, s.salt[:])
+	mac.Write([]byte(kind + "\x00" + dsn + "\x00" + version))
+
+	return string(mac.Sum(nil))
+}
+
+// get returns the cached response for key, or fetches it unless there's one
+// younger than ttl already, or on its way. Responses are timed with the clock
+// of d, or the real one if nil.
+func sharedGet[T any](ctx context.Context, s *SharedProvider, d *Driver, key string,
+	fetch func(context.Context) (T, error)) (T, error) {
+	var clock Clock = realClock{}
+
+	if d != nil {
+		clock = d.clock
+	}
+
+	s.mu.Lock()
+	retain := s.ttl
+
+	if s.hardened {
+		retain = 0
+	}
+
+	s.mu.Unlock()
+
+	res, err := s.flights.do(ctx, clock, key, s.ttl, retain, func(ctx context.Context) (any, error) {
+		return fetch(ctx)
+	})
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return res.value.(T), nil
+}
+
+// sharedFull is a FullDSNProvider going through the cache.
+type sharedFull struct {
+	s     *SharedProvider
+	d     *Driver
+	inner FullDSNProvider
+}
+
+// FetchDSN implements the DSNProvider interface.
+func (p sharedFull) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext implements the FullDSNProvider interface.
+func (p sharedFull) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	return sharedGet(ctx, p.s, p.d, p.s.key("dsn", dsn, ""), func(ctx context.Context) (string, error) {
+		return p.inner.FetchDSNWithContext(ctx, dsn)
+	})
+}
+
+// sharedInfo is an InfoDSNProvider going through the cache.
+type sharedInfo struct {
+	s     *SharedProvider
+	d     *Driver
+	inner InfoDSNProvider
+}
+
+// FetchDSNInfo implements the InfoDSNProvider interface.
+func (p sharedInfo) FetchDSNInfo(ctx context.Context, dsn string) (DSNInfo, error) {
+	return sharedGet(ctx, p.s, p.d, p.s.key("info", dsn, ""), func(ctx context.Context) (DSNInfo, error) {
+		return p.inner.FetchDSNInfo(ctx, dsn)
+	})
+}
+
+// sharedVersioned is a VersionedDSNProvider going through the cache.
+type sharedVersioned struct {
+	s     *SharedProvider
+	d     *Driver
+	inner VersionedDSNProvider
+}
+
+// FetchDSNVersion implements the VersionedDSNProvider interface.
+func (p sharedVersioned) FetchDSNVersion(ctx context.Context, dsn, version string) (DSNInfo, error) {
+	return sharedGet(ctx, p.s, p.d, p.s.key("version", dsn, version), func(ctx context.Context) (DSNInfo, error) {
+		return p.inner.FetchDSNVersion(ctx, dsn, version)
+	})
+}
+
+// sharedMulti is a MultiDSNProvider going through the cache.
+type sharedMulti struct {
+	s     *SharedProvider
+	d     *Driver
+	inner MultiDSNProvider
+}
+
+// FetchDSNs implements the MultiDSNProvider interface. Drivers change the
+// candidates they get, so each one gets a copy.
+func (p sharedMulti) FetchDSNs(ctx context.Context, dsn string) ([]DSNInfo, error) {
+	infos, err := sharedGet(ctx, p.s, p.d, p.s.key("multi", dsn, ""), func(ctx context.Context) ([]DSNInfo, error) {
+		return p.inner.FetchDSNs(ctx, dsn)
+	})
+
+	return slices.Clone(infos), err
+}
+
+// sharedBlueGreen is a BlueGreenProvider going through the cache.
+type sharedBlueGreen struct {
+	s     *SharedProvider
+	d     *Driver
+	inner BlueGreenProvider
+}
+
+// FetchBlueGreen implements the BlueGreenProvider interface.
+func (p sharedBlueGreen) FetchBlueGreen(ctx context.Context, dsn string) (BlueGreen, error) {
+	return sharedGet(ctx, p.s, p.d, p.s.key("bluegreen", dsn, ""), func(ctx context.Context) (BlueGreen, error) {
+		return p.inner.FetchBlueGreen(ctx, dsn)
+	})
+}
+
+// sharedLifecycle starts and closes the inner provider on behalf of every
+// driver, counting them as users; each driver counts its own connectors. See
+// Starter.
+type sharedLifecycle struct {
+	s *SharedProvider
+}
+
+// Start starts the inner provider, if it's the first user.
+func (l sharedLifecycle) Start(ctx context.Context) error {
+	s := &l.s.lifecycle
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if starter, ok := l.s.inner.(Starter); ok && s.users == 0 {
+		if err := starter.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	s.users++
+
+	return nil
+}
+
+// Close closes the inner provider, if it was the last user.
+func (l sharedLifecycle) Close() error {
+	s := &l.s.lifecycle
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.users--; s.users == 0 {
+		if closer, ok := l.s.inner.(io.Closer); ok {
+			return closer.Close()
+		}
+	}
+
+	return nil
+}
+
+// sharedRevoker revokes credentials through the inner provider on behalf of
+// every driver, once the last connector for their master DSN is closed; each
+// driver counts its own connectors (see masterState), and tells the provider
+// about the first one by means of acquire. See Revoker.
+type sharedRevoker struct {
+	s *SharedProvider
+}
+
+// acquire accounts for a driver opening its first connector for dsn.
+func (s *SharedProvider) acquire(dsn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.masters == nil {
+		s.masters = make(map[string]int)
+	}
+
+	s.masters[s.key("master", dsn, "")]++
+}
+
+// Revoke implements the Revoker interface, revoking the credentials for dsn
+// if the calling driver was the last one using them.
+func (r sharedRevoker) Revoke(ctx context.Context, dsn string) error {
+	s := r.s
+	key := s.key("master", dsn, "")
+	s.mu.Lock()
+
+	if s.masters[key]--; s.masters[key] > 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	delete(s.masters, key)
+	s.mu.Unlock()
+
+	return s.inner.(Revoker).Revoke(ctx, dsn)
+}
+
+// SharedProvider implements the DSNProvider interface; see New for how the
+// rest of the inner provider's interfaces are made available.
+var _ DSNProvider = &SharedProvider{}
@@ -0,0 +1,116 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// lifecycleProvider is a provider that counts starts, closes and
+// revocations.
+type lifecycleProvider struct {
+	*lazydsntest.Provider
+
+	mu                      sync.Mutex
+	starts, closes, revokes int
+}
+
+func (p *lifecycleProvider) Start(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.starts++
+
+	return nil
+}
+
+func (p *lifecycleProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closes++
+
+	return nil
+}
+
+func (p *lifecycleProvider) Revoke(context.Context, string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.revokes++
+
+	return nil
+}
+
+// counts returns the starts, closes and revocations so far.
+func (p *lifecycleProvider) counts() (starts, closes, revokes int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.starts, p.closes, p.revokes
+}
+
+// TestSharedProvider checks that drivers sharing a provider share its
+// responses for the ttl, and that it's started, closed and has credentials
+// revoked once for all of them.
+func TestSharedProvider(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := &lifecycleProvider{Provider: lazydsntest.NewProvider("db")}
+	shared := lazydsn.Share(p, time.Minute)
+
+	var dbs []*sql.DB
+
+	for range 2 {
+		connector, err := lazydsn.New(lazydsntest.NewDriver(), shared, lazydsn.WithClock(clock)).OpenConnector("master")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(connector)
+		db.SetMaxIdleConns(0)
+		dbs = append(dbs, db)
+	}
+
+	ping := func() {
+		t.Helper()
+
+		for _, db := range dbs {
+			if err := db.Ping(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ping()
+	ping()
+
+	if n := p.Fetches(); n != 1 {
+		t.Errorf("got %d fetches within the ttl, want 1", n)
+	}
+
+	clock.Advance(time.Minute)
+	ping()
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches after the ttl, want 2", n)
+	}
+
+	dbs[0].Close()
+
+	if starts, closes, revokes := p.counts(); starts != 1 || closes != 0 || revokes != 0 {
+		t.Errorf("got %d starts, %d closes and %d revocations with a database open, want 1, 0 and 0",
+			starts, closes, revokes)
+	}
+
+	dbs[1].Close()
+
+	if starts, closes, revokes := p.counts(); starts != 1 || closes != 1 || revokes != 1 {
+		t.Errorf("got %d starts, %d closes and %d revocations, want one each", starts, closes, revokes)
+	}
+}
@@ -0,0 +1,290 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPrefetchLead is how long before connections expire that candidates
+// are prefetched, unless configured otherwise with WithPrefetch.
+const defaultPrefetchLead = 5 * time.Second
+
+// snapshot holds the candidates last resolved for a master DSN, when, with
+// what version pinned (as a generation; see versionState), and the inner
+// driver's connectors for them, along with the openFunc that uses them, if
+// any. Snapshots are immutable once published; a rotation publishes a new
+// one. That's what lets connections be opened without taking any locks, in the
+// steady state.
+type snapshot struct {
+	candidates []DSNInfo
+	connectors []driver.Connector
+	open       openFunc
+	fetched    time.Time
+	generation uint64
+}
+
+// connector returns the connector for the candidate with the given DSN, if
+// the snapshot has one. Candidates are few, and DSNs usually share their
+// backing array with the candidate's, so a linear scan is cheaper than
+// anything else here.
+func (s *snapshot) connector(dsn string) driver.Connector {
+	for i := range s.connectors {
+		if s.candidates[i].DSN == dsn || s.candidates[i].sealed.equal(dsn) {
+			return s.connectors[i]
+		}
+	}
+
+	return nil
+}
+
+// unsealed returns the snapshot with its candidates in the clear, for a
+// connection to be opened with; see seal. Snapshots that aren't sealed are
+// returned as they are.
+func (s *snapshot) unsealed() *snapshot {
+	if len(s.candidates) == 0 || s.candidates[0].sealed == nil {
+		return s
+	}
+
+	plain := *s
+	plain.candidates = unseal(s.candidates)
+
+	return &plain
+}
+
+// snapshots keeps the last resolution for a connector's master DSN, so that
+// new connections don't have to wait for the provider every time. See
+// WithRefreshInterval. Snapshots are refreshed in the background once they
+// get halfway through the refresh interval, and synchronously only when they
+// are past it, or when the database rejects the credentials in them.
+type snapshots struct {
+	masterDSN string
+	driver    *Driver
+
+	// prepare, if set, is called with every new snapshot before it's
+	// published. Native connectors fill in the connectors for it there, in
+	// the same order as the candidates, and the openFunc to use them with;
+	// entries may be nil.
+	prepare func(snap *snapshot)
+
+	current    atomic.Pointer[snapshot]
+	refreshing atomic.Bool
+
+	// prefetching holds when the pending prefetch is due, in nanoseconds
+	// since the epoch, or zero if there's none. See schedule.
+	prefetching atomic.Int64
+}
+
+// enabled tells whether snapshots are kept at all. Otherwise, every
+// connection fetches the candidates. Under memory hardening, snapshots keep
+// their candidates sealed; see seal.
+func (s *snapshots) enabled() bool {
+	return s.driver.refresh > 0
+}
+
+// get returns a snapshot for the master DSN, and whether it's an existing
+// one rather than freshly fetched. Fetches are bounded by the budget set with
+// WithFetchBudget, if any. Snapshots taken before the pinned version last
+// changed are never used; see Driver.Pin.
+func (s *snapshots) get(ctx context.Context) (*snapshot, bool, error) {
+	generation := s.driver.versions.generation.Load()
+
+	if current := s.current.Load(); current != nil && current.generation == generation && s.enabled() {
+		interval := s.driver.refresh
+		age := s.driver.clock.Now().Sub(current.fetched)
+
+		if age < interval {
+			if age >= interval/2 {
+				s.refresh(interval - age)
+			}
+
+			return current, true, nil
+		}
+	}
+
+	fctx, cancel := s.driver.fetchContext(ctx)
+	snap, err := s.fetch(fctx, s.driver.refresh/2)
+	cancel()
+
+	if err != nil && fctx.Err() != nil && ctx.Err() == nil {
+		// Out of budget; see WithFetchBudget. Expired candidates are
+		// better than none, and connecting will tell.
+		if current := s.current.Load(); current != nil && current.generation == generation && s.enabled() {
+			return current, true, nil
+		}
+	}
+
+	return snap, false, err
+}
+
+// connect opens a connection with dial, to one of the candidates in a
+// snapshot for the master DSN. If the database rejects credentials taken from
+// an existing snapshot, they are fetched again and the connection retried, as
+// they may have been rotated meanwhile.
+func (s *snapshots) connect(ctx context.Context,
+	dial func(ctx context.Context, snap *snapshot) (driver.Conn, error)) (driver.Conn, error) {
+	snap, cached, err := s.get(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dial(ctx, snap.unsealed())
+
+	if err == nil {
+		s.schedule()
+	}
+
+	if err == nil || !cached || s.driver.classify(err) != ClassAuth {
+		return conn, err
+	}
+
+	s.invalidate(snap)
+
+	if snap, err = s.fetch(ctx, 0); err != nil {
+		return nil, err
+	}
+
+	if conn, err = dial(ctx, snap.unsealed()); err == nil {
+		s.schedule()
+	}
+
+	return conn, err
+}
+
+// fetch resolves the candidates for the master DSN, and publishes them. A
+// resolution shared with other connectors is used if younger than maxAge.
+// Resolutions are shared by all the connectors of a driver, so that pools
+// sharing a master DSN (e.g., several sql.DB opened with it) make the provider
+// query its backend once per refresh, rather than once per pool; concurrent
+// fetches for the same master DSN are coalesced, too. See flights.
+func (s *snapshots) fetch(ctx context.Context, maxAge time.Duration) (*snapshot, error) {
+	d := s.driver
+	generation := d.versions.generation.Load()
+
+	if !s.enabled() {
+		candidates, err := d.fetch(ctx, s.masterDSN)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.publish(candidates, d.clock.Now(), generation), nil
+	}
+
+	res, err := d.resolution(ctx, s.masterDSN, generation, maxAge)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.publish(res.value, res.fetched, generation), nil
+}
+
+// resolution returns the resolution for dsn shared by the driver's
+// connectors, if younger than maxAge, or a new one, shared from then on.
+// Resolutions made with another version pinned (i.e., another generation) are
+// kept apart. Under memory hardening, candidates are shared sealed; see seal.
+func (d *Driver) resolution(ctx context.Context, dsn string, generation uint64,
+	maxAge time.Duration) (*flight[[]DSNInfo], error) {
+	key := d.fingerprint(dsn) + "\x00" + strconv.FormatUint(generation, 10)
+
+	return d.resolutions.do(ctx, d.clock, key, maxAge, d.refresh,
+		func(ctx context.Context) ([]DSNInfo, error) {
+			candidates, err := d.fetch(ctx, dsn)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return d.seal(candidates), nil
+		})
+}
+
+// publish makes a snapshot out of candidates, fetched at the given time and
+// generation, and makes it the current one. Connectors are prepared with the
+// DSNs in the clear, but snapshots keep them sealed under memory hardening.
+func (s *snapshots) publish(candidates []DSNInfo, fetched time.Time, generation uint64) *snapshot {
+	snap := &snapshot{
+		candidates: unseal(candidates),
+		fetched:    fetched,
+		generation: generation,
+	}
+
+	if s.prepare != nil {
+		s.prepare(snap)
+	}
+
+	if s.enabled() {
+		snap.candidates = s.driver.seal(candidates)
+		s.current.Store(snap)
+	}
+
+	return snap
+}
+
+// invalidate drops snap, if it's still the current snapshot, so that the next
+// connection fetches the candidates again.
+func (s *snapshots) invalidate(snap *snapshot) {
+	s.current.CompareAndSwap(snap, nil)
+}
+
+// refresh fetches the candidates in the background, unless that's already
+// going on. The current snapshot is kept if that fails; it will be fetched
+// synchronously once expired. The refresh waits until then at most; a fetch
+// still going on by then is joined by the synchronous ones, within its own
+// bounds (see flights).
+func (s *snapshots) refresh(timeout time.Duration) {
+	if !s.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer s.refreshing.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		_, _ = s.fetch(ctx, s.driver.refresh/2)
+	}()
+}
+
+// schedule arranges for the candidates to be fetched shortly before a
+// connection opened just now reaches its maximum lifetime, unless
+// there's a fetch scheduled already; see WithPrefetch. Pools tend to open
+// connections in bursts (e.g., on startup), so the first connection opened
+// after a prefetch stands for the bulk of the next wave of reconnects.
+func (s *snapshots) schedule() {
+	d := s.driver
+
+	if d.prefetchLifetime <= 0 || !s.enabled() {
+		return
+	}
+
+	lead := d.prefetchLead
+
+	if lead <= 0 {
+		lead = defaultPrefetchLead
+	}
+
+	lead = min(lead, d.prefetchLifetime/2)
+	due := d.clock.Now().Add(d.prefetchLifetime - lead)
+
+	if !s.prefetching.CompareAndSwap(0, due.UnixNano()) {
+		return
+	}
+
+	go func() {
+		defer s.prefetching.Store(0)
+
+		<-d.clock.After(due.Sub(d.clock.Now()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), lead)
+		defer cancel()
+
+		// Whatever we get must still be fresh once the wave arrives.
+		_, _ = s.fetch(ctx, max(d.refresh/2-lead, 0))
+	}()
+}
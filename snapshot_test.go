@@ -0,0 +1,376 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// openSnapshots opens a database with a driver that reuses resolutions for a
+// minute, and tells the time with clock. Connections aren't pooled, so that
+// every one of them goes through the connector.
+func openSnapshots(t *testing.T, inner *lazydsntest.Driver, p lazydsn.DSNProvider, clock lazydsn.Clock,
+	opts ...lazydsn.Option) *sql.DB {
+	t.Helper()
+
+	opts = append([]lazydsn.Option{
+		lazydsn.WithClock(clock),
+		lazydsn.WithRefreshInterval(time.Minute),
+		lazydsn.WithClassifier(lazydsntest.Classifier),
+	}, opts...)
+
+	connector, err := lazydsn.New(inner, p, opts...).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxIdleConns(0)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// connectDSN opens a connection with ctx, and returns the inner DSN it was
+// opened with.
+func connectDSN(ctx context.Context, db *sql.DB) (string, error) {
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	var dsn string
+
+	err = conn.Raw(func(driverConn any) error {
+		dsn = lazydsn.Unwrap(driverConn).(*lazydsntest.Conn).DSN()
+		return nil
+	})
+
+	return dsn, err
+}
+
+// TestSnapshotReuse checks that resolutions are reused until they expire,
+// and fetched again synchronously afterwards.
+func TestSnapshotReuse(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, clock)
+	ctx := context.Background()
+
+	for range 3 {
+		if dsn, err := connectDSN(ctx, db); err != nil || dsn != "old" {
+			t.Fatalf("got %q (%v), want old", dsn, err)
+		}
+	}
+
+	if n := p.Fetches(); n != 1 {
+		t.Fatalf("got %d fetches, want 1", n)
+	}
+
+	p.Set("new")
+	clock.Advance(time.Minute)
+
+	if dsn, err := connectDSN(ctx, db); err != nil || dsn != "new" {
+		t.Fatalf("got %q (%v) once expired, want new", dsn, err)
+	}
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+// TestSnapshotBackgroundRefresh checks that resolutions halfway through the
+// refresh interval are still used, while they're refreshed in the background,
+// and that connections opened meanwhile see either snapshot as a whole.
+func TestSnapshotBackgroundRefresh(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, clock)
+	ctx := context.Background()
+
+	if _, err := connectDSN(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Set("new")
+	p.SetLatency(10 * time.Millisecond)
+	clock.Advance(30 * time.Second)
+
+	if dsn, err := connectDSN(ctx, db); err != nil || dsn != "old" {
+		t.Fatalf("got %q (%v) halfway through, want old", dsn, err)
+	}
+
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				dsn, err := connectDSN(ctx, db)
+
+				if err != nil || dsn != "old" && dsn != "new" {
+					t.Errorf("got %q (%v), want old or new", dsn, err)
+					return
+				}
+
+				if dsn == "new" {
+					return
+				}
+
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+// TestSnapshotAuthRetry checks that reused credentials rejected by the
+// database are fetched again right away, and the connection retried.
+func TestSnapshotAuthRetry(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	inner := lazydsntest.NewDriver()
+	db := openSnapshots(t, inner, p, clock)
+	ctx := context.Background()
+
+	if _, err := connectDSN(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Set("new")
+	inner.Fail("old", lazydsntest.ErrAuth)
+
+	if dsn, err := connectDSN(ctx, db); err != nil || dsn != "new" {
+		t.Fatalf("got %q (%v), want new", dsn, err)
+	}
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+
+	// Other failures don't invalidate the snapshot.
+	inner.FailNext(errors.New("connection refused"))
+
+	if _, err := connectDSN(ctx, db); err == nil {
+		t.Fatal("got no error, want the database's")
+	}
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches after a non-auth failure, want 2", n)
+	}
+}
+
+// TestSnapshotShared checks that connectors sharing a master DSN share
+// resolutions too, and that concurrent fetches are coalesced.
+func TestSnapshotShared(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("db")
+	p.SetLatency(50 * time.Millisecond)
+	d := lazydsn.New(lazydsntest.NewDriver(), p,
+		lazydsn.WithClock(clock),
+		lazydsn.WithRefreshInterval(time.Minute),
+	)
+
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Bool
+	)
+
+	for range 2 {
+		connector, err := d.OpenConnector("master")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := sql.OpenDB(connector)
+		defer db.Close()
+
+		for range 4 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				if err := db.Ping(); err != nil {
+					failed.Store(true)
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	if failed.Load() {
+		t.Fatal("got errors connecting")
+	}
+
+	if n := p.Fetches(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
+
+// TestSnapshotPrefetch checks that resolutions are fetched again shortly
+// before connections reach their lifetime, so that reconnects don't wait.
+func TestSnapshotPrefetch(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, clock,
+		lazydsn.WithPrefetch(time.Minute, 5*time.Second),
+	)
+	ctx := context.Background()
+
+	if _, err := connectDSN(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Set("new")
+	clock.Advance(55 * time.Second)
+
+	for p.Fetches() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Reconnects arrive once connections expire, and find the prefetched
+	// resolution, still fresh.
+	clock.Advance(5 * time.Second)
+
+	for {
+		dsn, err := connectDSN(ctx, db)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dsn == "new" {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := p.Fetches(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+}
+
+// TestSnapshotBudget checks that expired resolutions are used when the
+// provider runs out of its share of the deadline.
+func TestSnapshotBudget(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("old")
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, clock, lazydsn.WithFetchBudget(0.5))
+
+	if _, err := connectDSN(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Set("new")
+	p.SetLatency(time.Second)
+	clock.Advance(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if dsn, err := connectDSN(ctx, db); err != nil || dsn != "old" {
+		t.Errorf("got %q (%v), want the expired old", dsn, err)
+	}
+}
+
+// hungProvider is a provider that never returns until released, whatever its
+// context says.
+type hungProvider struct {
+	release chan struct{}
+	fetches atomic.Int32
+}
+
+func (p *hungProvider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+func (p *hungProvider) FetchDSNWithContext(context.Context, string) (string, error) {
+	p.fetches.Add(1)
+	<-p.release
+
+	return "db", nil
+}
+
+// TestSnapshotHungProvider checks that connections give up on a provider that
+// ignores its context once their own context is done, and that they share a
+// single fetch meanwhile, whose outcome is used once it's available.
+func TestSnapshotHungProvider(t *testing.T) {
+	p := &hungProvider{
+		release: make(chan struct{}),
+	}
+
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, lazydsntest.NewClock(time.Now()))
+
+	var wg sync.WaitGroup
+
+	for range 3 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			if _, err := connectDSN(ctx, db); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(p.release)
+
+	if dsn, err := connectDSN(context.Background(), db); err != nil || dsn != "db" {
+		t.Fatalf("got %q (%v), want db", dsn, err)
+	}
+
+	if n := p.fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
+
+// TestSnapshotHardened checks that resolutions are reused under memory
+// hardening too.
+func TestSnapshotHardened(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := lazydsntest.NewProvider("db")
+	db := openSnapshots(t, lazydsntest.NewDriver(), p, clock, lazydsn.WithMemoryHardening())
+
+	for range 3 {
+		if dsn, err := connectDSN(context.Background(), db); err != nil || dsn != "db" {
+			t.Fatalf("got %q (%v), want db", dsn, err)
+		}
+	}
+
+	if n := p.Fetches(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+}
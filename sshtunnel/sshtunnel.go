@@ -0,0 +1,465 @@
+/*
+Package sshtunnel lets lazydsn reach databases through an SSH bastion, with
+bastion credentials that rotate just like database passwords do. The provider
+that resolves DSNs also reports the bastion to go through (see
+BastionProvider), and connections are carried over an SSH tunnel managed by
+this package:
+
+	p := sshtunnel.Wrap(provider, sshtunnel.WithHostKeyCallback(knownHosts))
+
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(), p)
+
+Tunnels work with any inner driver: each one listens on a local port, and the
+DSN is pointed to it (see lazydsn.ReplaceHost). A tunnel is kept for as long as
+the bastion and database address stay the same. When either changes (e.g.,
+the bastion key was rotated), a new tunnel is opened for new connections, and
+the old one is closed once the connections through it are gone. Tunnels are
+closed along with the last database using the provider, and opened again if
+needed afterwards; see lazydsn.Starter.
+
+Note that, since the inner driver connects to a local address, verifying the
+database server's TLS certificate requires setting the server name explicitly
+in the inner driver's TLS configuration.
+*/
+package sshtunnel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors returned by the provider.
+var (
+	ErrNoHostKey = errors.New("sshtunnel: no way to verify the bastion's host key")
+	ErrNoPort    = errors.New("sshtunnel: DSN has no database port")
+)
+
+// Bastion describes the SSH server to go through. Addr is a host:port pair.
+// PrivateKey is PEM encoded, and encrypted with Passphrase if not empty.
+// HostKey, in authorized_keys format, is the key that the bastion must
+// present; it can be left empty if the provider was given a host key
+// callback.
+type Bastion struct {
+	Addr       string
+	User       string
+	PrivateKey []byte
+	Passphrase []byte
+	HostKey    string
+}
+
+// A BastionProvider reports the bastion to use for the given master DSN. It
+// gets called along with the DSN itself, every time a connection is needed.
+type BastionProvider interface {
+	FetchBastion(ctx context.Context, dsn string) (Bastion, error)
+}
+
+// Provider resolves DSNs with another provider, and points them to SSH
+// tunnels. It implements lazydsn.InfoDSNProvider, io.Closer, and
+// lazydsn.Revoker if the wrapped provider does.
+type Provider struct {
+	creds   lazydsn.FullDSNProvider
+	info    lazydsn.InfoDSNProvider
+	revoker lazydsn.Revoker
+	bastion BastionProvider
+	hostKey ssh.HostKeyCallback
+
+	mu      sync.Mutex
+	tunnels map[string]*tunnel
+}
+
+// An Option configures optional behavior for a Provider.
+type Option func(*Provider)
+
+// WithHostKeyCallback sets the callback that verifies bastion host keys that
+// the provider doesn't report; see Bastion.
+func WithHostKeyCallback(cb ssh.HostKeyCallback) Option {
+	return func(p *Provider) {
+		p.hostKey = cb
+	}
+}
+
+// WithBastionProvider sets where bastions are fetched from, for providers
+// that don't implement BastionProvider themselves.
+func WithBastionProvider(b BastionProvider) Option {
+	return func(p *Provider) {
+		p.bastion = b
+	}
+}
+
+// Wrap creates a provider that resolves DSNs with creds, and reaches the
+// database through the bastion reported by creds itself, unless set with
+// WithBastionProvider.
+func Wrap(creds lazydsn.DSNProvider, opts ...Option) *Provider {
+	p := &Provider{
+		tunnels: make(map[string]*tunnel),
+	}
+
+	p.info, _ = creds.(lazydsn.InfoDSNProvider)
+	p.revoker, _ = creds.(lazydsn.Revoker)
+	p.bastion, _ = creds.(BastionProvider)
+
+	if full, ok := creds.(lazydsn.FullDSNProvider); ok {
+		p.creds = full
+	} else {
+		p.creds = lazydsn.DSNProviderWCFunc(func(_ context.Context, dsn string) (string, error) {
+			return creds.FetchDSN(dsn)
+		})
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// FetchDSN resolves the DSN, pointed to the tunnel.
+func (p *Provider) FetchDSN(dsn string) (string, error) {
+	return p.FetchDSNWithContext(context.Background(), dsn)
+}
+
+// FetchDSNWithContext resolves the DSN, pointed to the tunnel.
+func (p *Provider) FetchDSNWithContext(ctx context.Context, dsn string) (string, error) {
+	info, err := p.FetchDSNInfo(ctx, dsn)
+
+	return info.DSN, err
+}
+
+// FetchDSNInfo resolves the DSN, pointed to the tunnel. The database address
+// in the original DSN is reported as the endpoint.
+func (p *Provider) FetchDSNInfo(ctx context.Context, dsn string) (lazydsn.DSNInfo, error) {
+	var (
+		info lazydsn.DSNInfo
+		err  error
+	)
+
+	if p.info != nil {
+		info, err = p.info.FetchDSNInfo(ctx, dsn)
+	} else {
+		info.DSN, err = p.creds.FetchDSNWithContext(ctx, dsn)
+	}
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	if p.bastion == nil {
+		return lazydsn.DSNInfo{}, errors.New("sshtunnel: no bastion provider")
+	}
+
+	bastion, err := p.bastion.FetchBastion(ctx, dsn)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	target := lazydsn.ParseDSN(info.DSN).Host
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return lazydsn.DSNInfo{}, ErrNoPort
+	}
+
+	t, err := p.tunnel(ctx, dsn, bastion, target)
+
+	if err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	if info.DSN, err = lazydsn.ReplaceHost(info.DSN, t.addr()); err != nil {
+		return lazydsn.DSNInfo{}, err
+	}
+
+	info.Endpoint = target
+
+	return info, nil
+}
+
+// tunnel returns the tunnel for dsn, opening a new one if the bastion or the
+// target changed, or the current one is broken.
+func (p *Provider) tunnel(ctx context.Context, dsn string, b Bastion, target string) (*tunnel, error) {
+	key := keyOf(b, target)
+
+	p.mu.Lock()
+
+	if t := p.tunnels[dsn]; t != nil && t.key == key && !t.broken() {
+		p.mu.Unlock()
+		return t, nil
+	}
+
+	p.mu.Unlock()
+
+	// Dialing the bastion takes a while; don't hold other DSNs back.
+	t, err := p.open(ctx, b, target, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if old := p.tunnels[dsn]; old != nil {
+		if old.key == key && !old.broken() {
+			// Somebody else got here first.
+			t.retire()
+			return old, nil
+		}
+
+		old.retire()
+	}
+
+	p.tunnels[dsn] = t
+
+	return t, nil
+}
+
+// open dials the bastion and starts listening for connections to tunnel.
+func (p *Provider) open(ctx context.Context, b Bastion, target, key string) (*tunnel, error) {
+	var (
+		signer ssh.Signer
+		err    error
+	)
+
+	if len(b.Passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(b.PrivateKey, b.Passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(b.PrivateKey)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	hostKey := p.hostKey
+
+	if b.HostKey != "" {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(b.HostKey))
+
+		if err != nil {
+			return nil, err
+		}
+
+		hostKey = ssh.FixedHostKey(pub)
+	}
+
+	if hostKey == nil {
+		return nil, ErrNoHostKey
+	}
+
+	client, err := dial(ctx, b.Addr, &ssh.ClientConfig{
+		User:            b.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKey,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	t := &tunnel{
+		key:    key,
+		target: target,
+		client: client,
+		ln:     ln,
+	}
+
+	go t.serve()
+
+	return t, nil
+}
+
+// dial connects to an SSH server, honoring ctx.
+func dial(ctx context.Context, addr string, cfg *ssh.ClientConfig) (*ssh.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Revoke closes the tunnel for dsn, and revokes the credentials with the
+// wrapped provider, if it's a lazydsn.Revoker.
+func (p *Provider) Revoke(ctx context.Context, dsn string) error {
+	p.mu.Lock()
+
+	if t := p.tunnels[dsn]; t != nil {
+		t.retire()
+		delete(p.tunnels, dsn)
+	}
+
+	p.mu.Unlock()
+
+	if p.revoker != nil {
+		return p.revoker.Revoke(ctx, dsn)
+	}
+
+	return nil
+}
+
+// Close closes every tunnel. Connections through them are closed as well.
+// Those needed afterwards, if any, are opened again.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for dsn, t := range p.tunnels {
+		t.close()
+		delete(p.tunnels, dsn)
+	}
+
+	return nil
+}
+
+// keyOf identifies a tunnel by everything that went into opening it.
+func keyOf(b Bastion, target string) string {
+	h := sha256.New()
+
+	for _, s := range [][]byte{[]byte(b.Addr), []byte(b.User), b.PrivateKey, b.Passphrase, []byte(b.HostKey), []byte(target)} {
+		h.Write(s)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// tunnel forwards connections accepted on a local listener to the database,
+// through the bastion.
+type tunnel struct {
+	key    string
+	target string
+	client *ssh.Client
+	ln     net.Listener
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	failed  bool
+}
+
+// addr returns the local address that the tunnel listens on.
+func (t *tunnel) addr() string {
+	return t.ln.Addr().String()
+}
+
+// serve accepts local connections until the listener is closed.
+func (t *tunnel) serve() {
+	for {
+		local, err := t.ln.Accept()
+
+		if err != nil {
+			return
+		}
+
+		remote, err := t.client.Dial("tcp", t.target)
+
+		if err != nil {
+			// Most likely, the SSH connection is gone.
+			t.mu.Lock()
+			t.failed = true
+			t.mu.Unlock()
+			local.Close()
+
+			continue
+		}
+
+		t.mu.Lock()
+		t.active++
+		t.mu.Unlock()
+
+		go t.pipe(local, remote)
+	}
+}
+
+// pipe copies data both ways until either side is done.
+func (t *tunnel) pipe(local, remote net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go copyConn(local, remote)
+	go copyConn(remote, local)
+	<-done
+	local.Close()
+	remote.Close()
+	<-done
+
+	t.mu.Lock()
+	t.active--
+	last := t.retired && t.active == 0
+	t.mu.Unlock()
+
+	if last {
+		t.client.Close()
+	}
+}
+
+// broken reports whether the tunnel failed to reach the database.
+func (t *tunnel) broken() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.failed
+}
+
+// retire stops accepting new connections, and closes the SSH connection once
+// the ones already open are gone.
+func (t *tunnel) retire() {
+	t.ln.Close()
+
+	t.mu.Lock()
+	t.retired = true
+	idle := t.active == 0
+	t.mu.Unlock()
+
+	if idle {
+		t.client.Close()
+	}
+}
+
+// close closes the tunnel right away.
+func (t *tunnel) close() {
+	t.ln.Close()
+	t.client.Close()
+}
+
+// Provider implements the lazydsn provider interfaces.
+var (
+	_ lazydsn.FullDSNProvider = &Provider{}
+	_ lazydsn.InfoDSNProvider = &Provider{}
+	_ lazydsn.Revoker         = &Provider{}
+	_ io.Closer               = &Provider{}
+)
@@ -0,0 +1,333 @@
+package sshtunnel_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+	"github.com/gkristic/lazydsn/sshtunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+// bastionFunc adapts a function to sshtunnel.BastionProvider.
+type bastionFunc func(ctx context.Context, dsn string) (sshtunnel.Bastion, error)
+
+func (f bastionFunc) FetchBastion(ctx context.Context, dsn string) (sshtunnel.Bastion, error) {
+	return f(ctx, dsn)
+}
+
+// fixedBastion always reports b.
+func fixedBastion(b *sshtunnel.Bastion) bastionFunc {
+	return func(context.Context, string) (sshtunnel.Bastion, error) {
+		return *b, nil
+	}
+}
+
+// clientKey returns a new private key, PEM encoded.
+func clientKey(t *testing.T) []byte {
+	t.Helper()
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(key, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+// bastion is an in-process SSH server that forwards connections anywhere, for
+// any of the keys it was given.
+type bastion struct {
+	addr    string
+	hostKey string
+	conns   atomic.Int32
+}
+
+// startBastion starts a bastion that authorizes the given PEM encoded keys.
+func startBastion(t *testing.T, keys ...[]byte) *bastion {
+	t.Helper()
+
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorized := make(map[string]bool)
+
+	for _, key := range keys {
+		signer, err := ssh.ParsePrivateKey(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authorized[string(signer.PublicKey().Marshal())] = true
+	}
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !authorized[string(key.Marshal())] {
+				return nil, errors.New("unknown key")
+			}
+
+			return nil, nil
+		},
+	}
+
+	cfg.AddHostKey(hostSigner)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	b := &bastion{
+		addr:    ln.Addr().String(),
+		hostKey: string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go b.serve(conn, cfg)
+		}
+	}()
+
+	return b
+}
+
+// serve handles an SSH connection, forwarding direct-tcpip channels.
+func (b *bastion) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	b.conns.Add(1)
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+
+		if newChan.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChan.ExtraData(), &target) != nil {
+			newChan.Reject(ssh.UnknownChannelType, "not supported")
+			continue
+		}
+
+		remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.FormatUint(uint64(target.Port), 10)))
+
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		ch, chReqs, err := newChan.Accept()
+
+		if err != nil {
+			remote.Close()
+			continue
+		}
+
+		go ssh.DiscardRequests(chReqs)
+
+		go func() {
+			go io.Copy(ch, remote)
+			io.Copy(remote, ch)
+			ch.Close()
+			remote.Close()
+		}()
+	}
+}
+
+// startEcho starts a server that echoes back whatever it receives, standing
+// for the database, and returns its address.
+func startEcho(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// echo checks that data sent to the host in dsn comes back.
+func echo(t *testing.T, dsn string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", lazydsn.ParseDSN(dsn).Host)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	want := []byte("ping")
+
+	if _, err := conn.Write(want); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]byte, len(want))
+
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %q back, want %q", got, want)
+	}
+}
+
+// TestFetchErrors checks the errors reported before any tunnel is opened.
+func TestFetchErrors(t *testing.T) {
+	key := clientKey(t)
+
+	tests := []struct {
+		name    string
+		dsn     string
+		bastion sshtunnel.Bastion
+		want    error
+	}{
+		{"no port", "postgres://u:p@db/app", sshtunnel.Bastion{PrivateKey: key, HostKey: "x"}, sshtunnel.ErrNoPort},
+		{"no host key", "postgres://u:p@db:5432/app", sshtunnel.Bastion{PrivateKey: key}, sshtunnel.ErrNoHostKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := sshtunnel.Wrap(lazydsntest.NewProvider(tt.dsn), sshtunnel.WithBastionProvider(fixedBastion(&tt.bastion)))
+
+			if _, err := p.FetchDSN("master"); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	p := sshtunnel.Wrap(lazydsntest.NewProvider("postgres://u:p@db:5432/app"))
+
+	if _, err := p.FetchDSN("master"); err == nil {
+		t.Error("got no error without a bastion provider")
+	}
+}
+
+// TestTunnel checks that DSNs are pointed to a tunnel that reaches the
+// database, that the tunnel is reused until the bastion changes, and that
+// tunnels are opened again after Close.
+func TestTunnel(t *testing.T) {
+	db := startEcho(t)
+	oldKey, newKey := clientKey(t), clientKey(t)
+	srv := startBastion(t, oldKey, newKey)
+	b := sshtunnel.Bastion{
+		Addr:       srv.addr,
+		User:       "tunnel",
+		PrivateKey: oldKey,
+		HostKey:    srv.hostKey,
+	}
+
+	p := sshtunnel.Wrap(lazydsntest.NewProvider("postgres://u:p@"+db+"/app"),
+		sshtunnel.WithBastionProvider(fixedBastion(&b)),
+	)
+
+	defer p.Close()
+
+	ctx := context.Background()
+	info, err := p.FetchDSNInfo(ctx, "master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Endpoint != db {
+		t.Errorf("got endpoint %q, want %q", info.Endpoint, db)
+	}
+
+	echo(t, info.DSN)
+
+	if again, err := p.FetchDSNInfo(ctx, "master"); err != nil || again.DSN != info.DSN {
+		t.Errorf("got %q (%v), want the same tunnel at %q", again.DSN, err, info.DSN)
+	}
+
+	if n := srv.conns.Load(); n != 1 {
+		t.Errorf("got %d SSH connections, want 1", n)
+	}
+
+	// A rotated bastion key gets a tunnel of its own.
+	b.PrivateKey = newKey
+	rotated, err := p.FetchDSNInfo(ctx, "master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated.DSN == info.DSN {
+		t.Error("got the same tunnel after rotating the bastion key")
+	}
+
+	echo(t, rotated.DSN)
+
+	// Closing the provider isn't final: the next database to use it needs
+	// tunnels again.
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := p.FetchDSNInfo(ctx, "master")
+
+	if err != nil {
+		t.Fatalf("got %v after Close, want a new tunnel", err)
+	}
+
+	echo(t, reopened.DSN)
+
+	if n := srv.conns.Load(); n != 3 {
+		t.Errorf("got %d SSH connections, want 3", n)
+	}
+}
@@ -0,0 +1,161 @@
+package lazydsn
+
+import (
+	"database/sql"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters describing the driver's activity since it was created.
+// Failures are broken down by ErrorClass; provider failures are always
+// accounted for under ClassProvider. CredentialAge is the time elapsed since
+// the provider last returned a new inner DSN (or the first one, if there were
+// no rotations yet), and is zero before the first successful fetch. Endpoints
+// is only filled in for MultiDSNProvider and BlueGreenProvider, and lists
+// endpoints that have open connections or failed recently; see EndpointStats.
+// Versions and Draining are only filled in with WithRevocationGrace;
+// connections opened with unversioned credentials are counted under the empty
+// version.
+type Stats struct {
+	Fetches  int64                // Attempts to resolve the inner DSN
+	Connects int64                // Attempts to connect with the inner driver
+	Failures map[ErrorClass]int64 // Failed attempts of either kind, by class
+
+	Rotations     int64         // Changes observed in resolved inner DSNs
+	LastRotation  time.Time     // When the current inner DSN was first seen
+	CredentialAge time.Duration // Time elapsed since LastRotation
+
+	ActiveVersion string // Secret version last resolved, if versioned
+	PinnedVersion string // Secret version pinned with Pin or Rollback
+
+	Endpoints  map[string]EndpointStats // Endpoint health, by name
+	Evictions  int64                    // Endpoints evicted by health probes
+	Recoveries int64                    // Evicted endpoints that recovered
+
+	Validations int64 // Pings of connections with new credentials
+
+	DryRuns        int64 // Tries of credentials staged for the next rotation
+	DryRunFailures int64 // Tries of staged credentials that failed
+
+	Versions map[string]int64 // Open connections by credential version
+	Draining int64            // Open connections with retired credentials
+	Retired  int64            // Connections discarded for retired credentials
+}
+
+// driverStats keeps the live counters behind Stats.
+type driverStats struct {
+	fetches         atomic.Int64
+	connects        atomic.Int64
+	failures        [numErrorClasses]atomic.Int64
+	rotations       atomic.Int64
+	credentialSince atomic.Int64
+	evictions       atomic.Int64
+	recoveries      atomic.Int64
+	validations     atomic.Int64
+	retired         atomic.Int64
+	dryRuns         atomic.Int64
+	dryRunFailures  atomic.Int64
+}
+
+// record accounts for a single operation. Health probes and dry runs are not
+// operations of their own, so their errors aren't counted as failures.
+func (s *driverStats) record(kind EventKind, class ErrorClass) {
+	switch kind {
+	case EventFetch:
+		s.fetches.Add(1)
+	case EventConnect:
+		s.connects.Add(1)
+	case EventEvict:
+		s.evictions.Add(1)
+		return
+	case EventRecover:
+		s.recoveries.Add(1)
+		return
+	case EventValidate:
+		s.validations.Add(1)
+	case EventRetire:
+		s.retired.Add(1)
+		return
+	case EventDryRun:
+		s.dryRuns.Add(1)
+
+		if class != ClassNone {
+			s.dryRunFailures.Add(1)
+		}
+
+		return
+	}
+
+	if class != ClassNone && class >= 0 && class < numErrorClasses {
+		s.failures[class].Add(1)
+	}
+}
+
+// Stats returns a snapshot of the driver's counters.
+func (d *Driver) Stats() Stats {
+	s := Stats{
+		Fetches:  d.stats.fetches.Load(),
+		Connects: d.stats.connects.Load(),
+		Failures: make(map[ErrorClass]int64),
+	}
+
+	for class := range d.stats.failures {
+		if n := d.stats.failures[class].Load(); n > 0 {
+			s.Failures[ErrorClass(class)] = n
+		}
+	}
+
+	s.Rotations = d.stats.rotations.Load()
+	s.Evictions = d.stats.evictions.Load()
+	s.Recoveries = d.stats.recoveries.Load()
+	s.Validations = d.stats.validations.Load()
+	s.Retired = d.stats.retired.Load()
+	s.DryRuns = d.stats.dryRuns.Load()
+	s.DryRunFailures = d.stats.dryRunFailures.Load()
+
+	if d.retireConns {
+		s.Versions, s.Draining = d.retirements.stats()
+	}
+
+	if since := d.stats.credentialSince.Load(); since != 0 {
+		s.LastRotation = time.Unix(0, since)
+		s.CredentialAge = d.clock.Now().Sub(s.LastRotation)
+	}
+
+	d.versions.mu.Lock()
+	s.ActiveVersion = d.versions.active
+	s.PinnedVersion = d.versions.pinned
+	d.versions.mu.Unlock()
+
+	if d.mdsnp != nil || d.bgdsnp != nil {
+		s.Endpoints = d.endpoints.stats(d.clock.Now())
+	}
+
+	return s
+}
+
+// PoolStats merges the connection pool statistics kept by database/sql with
+// the driver's own, so that dashboards can get everything about a database
+// from a single place. Both structs are embedded, so fields show up flattened
+// when encoding to JSON.
+type PoolStats struct {
+	sql.DBStats
+	Stats
+}
+
+// PoolStatsOf returns the combined statistics for db. The second return value
+// reports whether db is actually backed by a lazydsn Driver, as found by
+// DriverOf; when it isn't, only the pool statistics are filled in.
+func PoolStatsOf(db *sql.DB) (PoolStats, bool) {
+	ps := PoolStats{
+		DBStats: db.Stats(),
+	}
+
+	d, ok := DriverOf(db)
+
+	if ok {
+		ps.Stats = d.Stats()
+	}
+
+	return ps, ok
+}
@@ -0,0 +1,90 @@
+package lazydsn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+)
+
+// errBadCABundle is returned when a provider supplies a CA bundle without any
+// usable certificates.
+var errBadCABundle = errors.New("lazydsn: no valid certificates in CA bundle")
+
+// A TLSApplier makes a TLS configuration available to the inner driver, and
+// returns the inner DSN that should be used with it. Drivers differ widely in
+// this regard. For example, github.com/go-sql-driver/mysql takes configurations
+// registered by name:
+//
+//	func(dsn string, cfg *tls.Config, bundle []byte) (string, error) {
+//		name := fmt.Sprintf("lazydsn-%x", sha256.Sum256(bundle))
+//		if err := mysql.RegisterTLSConfig(name, cfg); err != nil {
+//			return "", err
+//		}
+//		return dsn + "?tls=" + name, nil // Assuming no other parameters
+//	}
+//
+// PostgreSQL drivers, on the other hand, read the bundle from the file given
+// in the sslrootcert parameter. The applier is called on every fetch, but the
+// configuration given only changes when the bundle does.
+type TLSApplier func(innerDSN string, cfg *tls.Config, bundle []byte) (string, error)
+
+// tlsState holds the TLS configuration built from the last CA bundle seen.
+type tlsState struct {
+	base  *tls.Config
+	apply TLSApplier
+
+	mu     sync.Mutex
+	bundle string
+	cfg    *tls.Config
+}
+
+// config returns the TLS configuration for bundle, building a new one only
+// when the bundle changed since the last call.
+func (s *tlsState) config(bundle []byte) (*tls.Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg != nil && s.bundle == string(bundle) {
+		return s.cfg, nil
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errBadCABundle
+	}
+
+	cfg := &tls.Config{}
+
+	if s.base != nil {
+		cfg = s.base.Clone()
+	}
+
+	cfg.RootCAs = pool
+	s.cfg, s.bundle = cfg, string(bundle)
+
+	return cfg, nil
+}
+
+// prepare finishes processing a freshly resolved DSN before it's used, by
+// applying the TLS configuration for the CA bundle given by the provider.
+func (d *Driver) prepare(info DSNInfo) (DSNInfo, error) {
+	if d.tls == nil || info.CABundle == nil {
+		return info, nil
+	}
+
+	cfg, err := d.tls.config(info.CABundle)
+
+	if err != nil {
+		return DSNInfo{}, err
+	}
+
+	info.DSN, err = d.tls.apply(info.DSN, cfg, info.CABundle)
+
+	if err != nil {
+		return DSNInfo{}, err
+	}
+
+	return info, nil
+}
@@ -0,0 +1,161 @@
+package lazydsn_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/pem"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// newCA returns a PEM encoded, self-signed CA certificate, along with a pool
+// holding it.
+func newCA(t *testing.T, name string) ([]byte, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pool
+}
+
+// bundleProvider returns "db" along with the CA bundle set last.
+type bundleProvider struct {
+	mu     sync.Mutex
+	bundle []byte
+}
+
+func (p *bundleProvider) FetchDSN(string) (string, error) {
+	return "db", nil
+}
+
+func (p *bundleProvider) FetchDSNInfo(context.Context, string) (lazydsn.DSNInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return lazydsn.DSNInfo{DSN: "db", CABundle: p.bundle}, nil
+}
+
+// set sets the CA bundle to return.
+func (p *bundleProvider) set(bundle []byte) {
+	p.mu.Lock()
+	p.bundle = bundle
+	p.mu.Unlock()
+}
+
+// TestTLS checks that TLS configurations are built out of the base one when
+// the CA bundle changes, and reused otherwise.
+func TestTLS(t *testing.T) {
+	bundleA, poolA := newCA(t, "CA A")
+	bundleB, poolB := newCA(t, "CA B")
+	p := &bundleProvider{}
+
+	var cfgs []*tls.Config
+
+	apply := func(innerDSN string, cfg *tls.Config, _ []byte) (string, error) {
+		cfgs = append(cfgs, cfg)
+		return innerDSN + "?tls=custom", nil
+	}
+
+	inner := lazydsntest.NewDriver()
+	connector, err := lazydsn.New(inner, p,
+		lazydsn.WithTLS(&tls.Config{ServerName: "db.internal"}, apply),
+	).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxIdleConns(0)
+	defer db.Close()
+
+	ping := func() {
+		t.Helper()
+
+		if err := db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a bundle, there's nothing to apply.
+	ping()
+
+	if len(cfgs) != 0 {
+		t.Fatalf("got %d configurations applied without a bundle, want none", len(cfgs))
+	}
+
+	p.set(bundleA)
+	ping()
+	ping()
+	p.set(bundleB)
+	ping()
+
+	if len(cfgs) != 3 {
+		t.Fatalf("got %d configurations applied, want 3", len(cfgs))
+	}
+
+	if cfgs[0] != cfgs[1] {
+		t.Error("got a new configuration for the same bundle, want it reused")
+	}
+
+	if cfgs[1] == cfgs[2] {
+		t.Error("got the same configuration for a new bundle, want it rebuilt")
+	}
+
+	for i, want := range []*x509.CertPool{poolA, poolA, poolB} {
+		if !cfgs[i].RootCAs.Equal(want) || cfgs[i].ServerName != "db.internal" {
+			t.Errorf("got configuration %d with server %q and other CAs than expected", i, cfgs[i].ServerName)
+		}
+	}
+
+	if attempts := inner.Attempts(); attempts[len(attempts)-1] != "db?tls=custom" {
+		t.Errorf("got %q, want the DSN returned by the applier", attempts)
+	}
+
+	// Bundles without certificates are refused.
+	p.set([]byte("garbage"))
+
+	if err := db.Ping(); err == nil {
+		t.Error("got no error for a bad bundle")
+	}
+}
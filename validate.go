@@ -0,0 +1,94 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+)
+
+// maxValidated caps the number of credentials remembered as validated. Only
+// the last few matter; the set is simply started over once full.
+const maxValidated = 64
+
+// validationState remembers which inner DSNs have been validated already, as
+// fingerprints. See WithRotationPing.
+type validationState struct {
+	mu   sync.Mutex
+	done map[string]bool
+}
+
+// validate pings conn, just opened with info, if that's the first connection
+// opened with the credentials in it. That gives early warning of rotations
+// that produced credentials the database doesn't accept as it should (e.g.,
+// missing grants that only show up past the handshake). The outcome is
+// reported with EventValidate. The server's identity is verified as well, if
+// configured; see WithServerIdentity. Connections that fail either check are
+// closed, and the error returned instead; the next connection with the same
+// credentials is validated again. Validations are bounded; see
+// WithRebuildLimit.
+func (d *Driver) validate(ctx context.Context, info DSNInfo, conn driver.Conn) (driver.Conn, error) {
+	if d.validateTimeout <= 0 && d.serverIdentity == nil {
+		return conn, nil
+	}
+
+	var sum digest
+	d.digest(info.DSN, &sum)
+
+	s := &d.validations
+	s.mu.Lock()
+	done := s.done[string(sum[:])]
+	s.mu.Unlock()
+
+	if done {
+		return conn, nil
+	}
+
+	release, err := d.rebuilds.acquire(ctx)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	defer release()
+
+	if d.rebuilds != nil {
+		// Someone else may have validated the same credentials while
+		// we waited.
+		s.mu.Lock()
+		done = s.done[string(sum[:])]
+		s.mu.Unlock()
+
+		if done {
+			return conn, nil
+		}
+	}
+
+	if pinger, ok := conn.(driver.Pinger); ok && d.validateTimeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, d.validateTimeout)
+		err := redact(pinger.Ping(ctx), info.DSN)
+		cancel()
+		d.emit(EventValidate, d.classify(err), err)
+
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if err := d.verifyServer(ctx, info, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s.mu.Lock()
+
+	if s.done == nil || len(s.done) >= maxValidated {
+		s.done = make(map[string]bool)
+	}
+
+	s.done[string(sum[:])] = true
+	s.mu.Unlock()
+
+	return conn, nil
+}
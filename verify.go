@@ -0,0 +1,249 @@
+package lazydsn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrBadSignature is returned when a provider response fails verification.
+var ErrBadSignature = errors.New("lazydsn: provider response signature is not valid")
+
+// ErrBadClaims is returned when a provider response is properly signed, but
+// wasn't issued for the master DSN it was requested for, or has expired.
+var ErrBadClaims = errors.New("lazydsn: provider response claims are not valid")
+
+// jwsLeeway is the clock skew tolerated when checking the times in claims.
+const jwsLeeway = time.Minute
+
+// A Verifier checks provider responses before they are used, protecting
+// against compromised intermediaries between the application and the actual
+// source of credentials, like a credential broker. It returns the verified
+// response, which can differ from the one given; e.g., when the DSN comes
+// wrapped in a signed envelope. Nothing in the response given should be
+// passed through unless it's covered by the verification; e.g., a tampered CA
+// bundle or list of fallbacks would be as harmful as a tampered DSN. Verify is
+// given the master DSN that info was
+// requested for (with the role filled in; see WithRoles), and the current
+// time, as told by the driver's clock (see WithClock), so that responses
+// meant for other master DSNs, or replayed past their expiry, can be told
+// apart.
+type Verifier interface {
+	Verify(masterDSN string, info DSNInfo, now time.Time) (DSNInfo, error)
+}
+
+// JWSVerifier is a Verifier for providers that return DSNs as JWS objects, in
+// compact serialization. The signature must be valid for any of the
+// configured keys. Supported algorithms are RS256, RS512, PS256, PS512, ES256,
+// ES384 and EdDSA (Ed25519), depending on the type of each key. The payload
+// is a JSON object with the inner DSN in a "dsn" member, along with these
+// claims (see RFC 7519):
+//
+//   - aud: the master DSN, or a list of master DSNs, the response is for;
+//   - exp: when the response expires, as seconds since the epoch;
+//   - iat: optionally, when the response was issued, likewise.
+//
+// Otherwise, a response intercepted for a master DSN could be handed out for
+// another, or replayed for as long as its signing key is trusted. Times are
+// checked with a minute of leeway, for clock skew.
+//
+// The rest of DSNInfo is taken from the payload too, if at all: "version",
+// "fallbacks", "ca_bundle" (PEM), "issued" (seconds since the epoch),
+// "endpoint", "weight", "revoked" and "attributes" map to the fields of the
+// same name. Whatever the provider set outside of the JWS is dropped,
+// Connector included, since it can't be told apart from what a compromised
+// intermediary could have added.
+type JWSVerifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewJWSVerifier creates a verifier for signatures made with the private
+// counterparts of keys. More than one key can be given to allow for signing
+// key rotation. Keys must be *rsa.PublicKey, *ecdsa.PublicKey or
+// ed25519.PublicKey.
+func NewJWSVerifier(keys ...crypto.PublicKey) (*JWSVerifier, error) {
+	for _, key := range keys {
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("lazydsn: unsupported key type %T", key)
+		}
+	}
+
+	return &JWSVerifier{
+		keys: keys,
+	}, nil
+}
+
+// jwsClaims is the payload of a JWS verified by JWSVerifier.
+type jwsClaims struct {
+	DSN      string          `json:"dsn"`
+	Audience json.RawMessage `json:"aud"`
+	Expiry   *int64          `json:"exp"`
+	IssuedAt *int64          `json:"iat"`
+
+	Version    string            `json:"version"`
+	Fallbacks  []string          `json:"fallbacks"`
+	CABundle   string            `json:"ca_bundle"`
+	Issued     *int64            `json:"issued"`
+	Endpoint   string            `json:"endpoint"`
+	Weight     int               `json:"weight"`
+	Revoked    []string          `json:"revoked"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// Verify checks the JWS in info.DSN, and its claims, and returns the DSNInfo
+// in the payload.
+func (v *JWSVerifier) Verify(masterDSN string, info DSNInfo, now time.Time) (DSNInfo, error) {
+	parts := strings.Split(info.DSN, ".")
+
+	if len(parts) != 3 {
+		return DSNInfo{}, ErrBadSignature
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil || json.Unmarshal(rawHeader, &header) != nil {
+		return DSNInfo{}, ErrBadSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return DSNInfo{}, ErrBadSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return DSNInfo{}, ErrBadSignature
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if !slices.ContainsFunc(v.keys, func(key crypto.PublicKey) bool {
+		return verifyJWS(header.Alg, key, signed, sig)
+	}) {
+		return DSNInfo{}, ErrBadSignature
+	}
+
+	var claims jwsClaims
+
+	if json.Unmarshal(payload, &claims) != nil || !claims.valid(masterDSN, now) {
+		return DSNInfo{}, ErrBadClaims
+	}
+
+	return claims.info(), nil
+}
+
+// info returns the DSNInfo carried by the claims.
+func (c *jwsClaims) info() DSNInfo {
+	info := DSNInfo{
+		DSN:        c.DSN,
+		Version:    c.Version,
+		Fallbacks:  c.Fallbacks,
+		Endpoint:   c.Endpoint,
+		Weight:     c.Weight,
+		Revoked:    c.Revoked,
+		Attributes: c.Attributes,
+	}
+
+	if c.CABundle != "" {
+		info.CABundle = []byte(c.CABundle)
+	}
+
+	if c.Issued != nil {
+		info.Issued = time.Unix(*c.Issued, 0)
+	}
+
+	return info
+}
+
+// valid tells whether the claims are for masterDSN, and still current at now.
+func (c *jwsClaims) valid(masterDSN string, now time.Time) bool {
+	var audience []string
+
+	if json.Unmarshal(c.Audience, &audience) != nil {
+		var single string
+
+		if json.Unmarshal(c.Audience, &single) != nil {
+			return false
+		}
+
+		audience = []string{single}
+	}
+
+	switch {
+	case c.DSN == "", !slices.Contains(audience, masterDSN), c.Expiry == nil:
+		return false
+	case !now.Add(-jwsLeeway).Before(time.Unix(*c.Expiry, 0)):
+		return false
+	case c.IssuedAt != nil && now.Add(jwsLeeway).Before(time.Unix(*c.IssuedAt, 0)):
+		return false
+	}
+
+	return true
+}
+
+// verifyJWS checks a single signature. The algorithm must match the key type;
+// in particular, "none" is never accepted.
+func verifyJWS(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256":
+			h := sha256.Sum256(signed)
+			return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+		case "RS512":
+			h := sha512.Sum512(signed)
+			return rsa.VerifyPKCS1v15(key, crypto.SHA512, h[:], sig) == nil
+		case "PS256":
+			h := sha256.Sum256(signed)
+			return rsa.VerifyPSS(key, crypto.SHA256, h[:], sig, nil) == nil
+		case "PS512":
+			h := sha512.Sum512(signed)
+			return rsa.VerifyPSS(key, crypto.SHA512, h[:], sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		var h []byte
+
+		switch {
+		case alg == "ES256" && key.Curve.Params().BitSize == 256:
+			sum := sha256.Sum256(signed)
+			h = sum[:]
+		case alg == "ES384" && key.Curve.Params().BitSize == 384:
+			sum := sha512.Sum384(signed)
+			h = sum[:]
+		default:
+			return false
+		}
+
+		if len(sig) != 2*((key.Curve.Params().BitSize+7)/8) {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+
+		return ecdsa.Verify(key, h, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(key, signed, sig)
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+package lazydsn_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// signJWS returns claims as a JWS signed with key, in compact serialization.
+func signJWS(t *testing.T, alg string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	var (
+		sig []byte
+		err error
+	)
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(signed))
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, serr := ecdsa.Sign(rand.Reader, key, sum[:])
+		sig, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), serr
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// TestJWSVerifier checks that only responses signed with a configured key,
+// with the expected algorithm, for the master DSN and not yet expired, are
+// accepted.
+func TestJWSVerifier(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	v, err := lazydsn.NewJWSVerifier(edKey.Public(), ecKey.Public(), rsaKey.Public())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{
+			"dsn": "user:secret@tcp(db)/app",
+			"aud": "master",
+			"iat": now.Add(-time.Minute).Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+
+		return c
+	}
+
+	tamper := func(jws string) string {
+		parts := strings.Split(jws, ".")
+		payload, _ := json.Marshal(claims(map[string]any{"dsn": "attacker@tcp(evil)/app"}))
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+
+		return strings.Join(parts, ".")
+	}
+
+	tests := []struct {
+		name string
+		jws  string
+		want error
+	}{
+		{"EdDSA", signJWS(t, "EdDSA", edKey, claims(nil)), nil},
+		{"ES256", signJWS(t, "ES256", ecKey, claims(nil)), nil},
+		{"RS256", signJWS(t, "RS256", rsaKey, claims(nil)), nil},
+		{"audience list", signJWS(t, "EdDSA", edKey, claims(map[string]any{"aud": []string{"other", "master"}})), nil},
+		{"no iat", signJWS(t, "EdDSA", edKey, claims(map[string]any{"iat": nil})), nil},
+		{"within leeway", signJWS(t, "EdDSA", edKey, claims(map[string]any{"exp": now.Add(-time.Second).Unix()})), nil},
+		{"tampered", tamper(signJWS(t, "EdDSA", edKey, claims(nil))), lazydsn.ErrBadSignature},
+		{"unknown key", signJWS(t, "EdDSA", otherKey, claims(nil)), lazydsn.ErrBadSignature},
+		{"wrong alg", signJWS(t, "RS256", edKey, claims(nil)), lazydsn.ErrBadSignature},
+		{"alg none", strings.Join(strings.Split(signJWS(t, "none", edKey, claims(nil)), ".")[:2], ".") + ".",
+			lazydsn.ErrBadSignature},
+		{"not a JWS", "user:secret@tcp(db)/app", lazydsn.ErrBadSignature},
+		{"wrong audience", signJWS(t, "EdDSA", edKey, claims(map[string]any{"aud": "other"})), lazydsn.ErrBadClaims},
+		{"no audience", signJWS(t, "EdDSA", edKey, claims(map[string]any{"aud": nil})), lazydsn.ErrBadClaims},
+		{"expired", signJWS(t, "EdDSA", edKey, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})),
+			lazydsn.ErrBadClaims},
+		{"no exp", signJWS(t, "EdDSA", edKey, claims(map[string]any{"exp": nil})), lazydsn.ErrBadClaims},
+		{"issued later", signJWS(t, "EdDSA", edKey, claims(map[string]any{"iat": now.Add(time.Hour).Unix()})),
+			lazydsn.ErrBadClaims},
+		{"no dsn", signJWS(t, "EdDSA", edKey, claims(map[string]any{"dsn": nil})), lazydsn.ErrBadClaims},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := v.Verify("master", lazydsn.DSNInfo{DSN: tt.jws}, now)
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			if err == nil && info.DSN != "user:secret@tcp(db)/app" {
+				t.Errorf("got DSN %q, want the one in the payload", info.DSN)
+			}
+		})
+	}
+}
+
+// TestJWSVerifierUnsigned checks that only what's signed makes it into the
+// verified response, and that nothing set around the JWS does.
+func TestJWSVerifierUnsigned(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	v, err := lazydsn.NewJWSVerifier(key.Public())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+	claims := map[string]any{
+		"dsn": "user:secret@tcp(db)/app",
+		"aud": "master",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	planted := lazydsn.DSNInfo{
+		DSN:       signJWS(t, "EdDSA", key, claims),
+		CABundle:  []byte("-----BEGIN CERTIFICATE-----\nevil\n-----END CERTIFICATE-----\n"),
+		Fallbacks: []string{"evil"},
+		Endpoint:  "evil",
+		Connector: func(string) (driver.Connector, error) { return nil, errors.New("evil") },
+	}
+
+	info, err := v.Verify("master", planted, now)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.CABundle != nil || info.Fallbacks != nil || info.Endpoint != "" || info.Connector != nil {
+		t.Errorf("got unsigned fields passed through: %+v", info)
+	}
+
+	claims["ca_bundle"] = "-----BEGIN CERTIFICATE-----\ngood\n-----END CERTIFICATE-----\n"
+	claims["fallbacks"] = []string{"previous"}
+	claims["version"] = "current"
+	planted.DSN = signJWS(t, "EdDSA", key, claims)
+
+	if info, err = v.Verify("master", planted, now); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(info.CABundle) != claims["ca_bundle"] || !slices.Equal(info.Fallbacks, []string{"previous"}) ||
+		info.Version != "current" {
+		t.Errorf("got %+v, want the signed CA bundle, fallbacks and version", info)
+	}
+}
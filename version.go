@@ -0,0 +1,200 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors returned when managing secret versions.
+var (
+	ErrNotVersioned      = errors.New("lazydsn: provider does not support secret versions")
+	ErrNoPreviousVersion = errors.New("lazydsn: no previous secret version known")
+)
+
+// A VersionedDSNProvider resolves DSNs from a backend that keeps several
+// versions of its secrets. Implementing this interface lets applications pin
+// the driver to a given version, or roll back to the one previously in use;
+// see Driver.Pin and Driver.Rollback.
+type VersionedDSNProvider interface {
+	// FetchDSNVersion resolves dsn using the given version of the secret,
+	// where an empty version means the latest one. The version actually
+	// used must be reported in the result.
+	FetchDSNVersion(ctx context.Context, dsn, version string) (DSNInfo, error)
+}
+
+// versionState keeps track of the secret versions used by a driver.
+// Generation counts the changes to the pinned version, so that resolutions
+// made before one aren't reused after it; see snapshots.
+type versionState struct {
+	mu         sync.Mutex
+	pinned     string
+	active     string
+	previous   string
+	generation atomic.Uint64
+}
+
+// observe takes note of the version that was just resolved.
+func (s *versionState) observe(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version != s.active {
+		s.previous, s.active = s.active, version
+	}
+}
+
+// setPin changes the version that fetches should ask for. The caller must
+// hold the lock.
+func (s *versionState) setPin(version string) {
+	s.pinned = version
+	s.generation.Add(1)
+}
+
+// pin returns the version that fetches should ask for.
+func (s *versionState) pin() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pinned
+}
+
+// resolve fetches the inner DSN for dsn, going through the richest interface
+// that the provider supports. The version is only meaningful to versioned
+// providers.
+func (d *Driver) resolve(ctx context.Context, dsn, version string) (DSNInfo, error) {
+	var (
+		info DSNInfo
+		err  error
+	)
+
+	switch {
+	case d.vdsnp != nil:
+		info, err = d.vdsnp.FetchDSNVersion(ctx, dsn, version)
+	case d.idsnp != nil:
+		info, err = d.idsnp.FetchDSNInfo(ctx, dsn)
+	default:
+		info.DSN, err = d.dsnp.FetchDSNWithContext(ctx, dsn)
+	}
+
+	if err != nil {
+		return DSNInfo{}, err
+	}
+
+	return d.process(dsn, info)
+}
+
+// process verifies, decorates, attributes and prepares a DSN freshly returned
+// by the provider for dsn.
+func (d *Driver) process(dsn string, info DSNInfo) (DSNInfo, error) {
+	var err error
+
+	if d.verifier != nil {
+		if info, err = d.verifier.Verify(dsn, info, d.clock.Now()); err != nil {
+			return DSNInfo{}, err
+		}
+	}
+
+	if info, err = d.decorate(info); err != nil {
+		return DSNInfo{}, err
+	}
+
+	if info, err = d.attribute(info); err != nil {
+		return DSNInfo{}, err
+	}
+
+	return d.prepare(info)
+}
+
+// connectWithFallbacks opens a connection with open, using the DSN in info.
+// If that fails because of an authentication error, the fallback versions in
+// info are fetched and tried in order. The original error is returned if none
+// of them works.
+func (d *Driver) connectWithFallbacks(ctx context.Context, dsn string, info DSNInfo,
+	open openFunc) (driver.Conn, error) {
+	conn, err := open(ctx, info)
+
+	if err == nil || d.vdsnp == nil || len(info.Fallbacks) == 0 || d.classify(err) != ClassAuth {
+		return conn, err
+	}
+
+	tried := map[string]bool{
+		d.fingerprint(info.DSN): true,
+	}
+
+	for _, version := range info.Fallbacks {
+		alt, ferr := d.resolve(ctx, dsn, version)
+
+		if ferr != nil {
+			d.emit(EventFetch, ClassProvider, ferr)
+			continue
+		}
+
+		d.emit(EventFetch, ClassNone, nil)
+
+		fp := d.fingerprint(alt.DSN)
+
+		if tried[fp] || d.admit(dsn, alt) != nil {
+			continue
+		}
+
+		tried[fp] = true
+		alt.master = info.master
+
+		if conn, aerr := open(ctx, alt); aerr == nil {
+			d.versions.observe(alt.Version)
+			d.emitFor(alt, EventFallback, ClassNone, nil)
+
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// Pin makes the driver resolve every new connection using the given version of
+// the secret, until Unpin is called. This is meant for incident response, to
+// freeze rotation temporarily. Pinning takes effect with the next connection,
+// even if resolutions are being reused (see WithRefreshInterval), but doesn't
+// affect connections that are already open. It fails with ErrNotVersioned if
+// the provider doesn't implement VersionedDSNProvider.
+func (d *Driver) Pin(version string) error {
+	if d.vdsnp == nil {
+		return ErrNotVersioned
+	}
+
+	d.versions.mu.Lock()
+	d.versions.setPin(version)
+	d.versions.mu.Unlock()
+
+	return nil
+}
+
+// Unpin lets the driver follow the latest version of the secret again.
+func (d *Driver) Unpin() {
+	d.versions.mu.Lock()
+	d.versions.setPin("")
+	d.versions.mu.Unlock()
+}
+
+// Rollback pins the driver to the version of the secret that was in use
+// before the current one, and returns it. Use Unpin to resume rotation once
+// the problem is sorted out.
+func (d *Driver) Rollback() (string, error) {
+	if d.vdsnp == nil {
+		return "", ErrNotVersioned
+	}
+
+	d.versions.mu.Lock()
+	defer d.versions.mu.Unlock()
+
+	if d.versions.previous == "" {
+		return "", ErrNoPreviousVersion
+	}
+
+	d.versions.setPin(d.versions.previous)
+
+	return d.versions.pinned, nil
+}
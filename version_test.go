@@ -0,0 +1,219 @@
+package lazydsn_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// versionedProvider resolves versions to DSNs out of a map, with an empty
+// version standing for the current one. Every response lists fallbacks.
+type versionedProvider struct {
+	mu        sync.Mutex
+	dsns      map[string]string
+	current   string
+	fallbacks []string
+}
+
+func (p *versionedProvider) FetchDSN(dsn string) (string, error) {
+	info, err := p.FetchDSNVersion(context.Background(), dsn, "")
+	return info.DSN, err
+}
+
+func (p *versionedProvider) FetchDSNVersion(_ context.Context, _, version string) (lazydsn.DSNInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if version == "" {
+		version = p.current
+	}
+
+	dsn, ok := p.dsns[version]
+
+	if !ok {
+		return lazydsn.DSNInfo{}, errors.New("no such version")
+	}
+
+	return lazydsn.DSNInfo{
+		DSN:       dsn,
+		Version:   version,
+		Fallbacks: p.fallbacks,
+	}, nil
+}
+
+// rotate makes version the current one.
+func (p *versionedProvider) rotate(version string) {
+	p.mu.Lock()
+	p.current = version
+	p.mu.Unlock()
+}
+
+// openVersioned opens a database with a driver for inner and p. Connections
+// aren't pooled, so that every one of them goes through the connector.
+func openVersioned(t *testing.T, inner *lazydsntest.Driver, p lazydsn.DSNProvider,
+	opts ...lazydsn.Option) (*lazydsn.Driver, *sql.DB) {
+	t.Helper()
+
+	opts = append([]lazydsn.Option{lazydsn.WithClassifier(lazydsntest.Classifier)}, opts...)
+	d := lazydsn.New(inner, p, opts...)
+	connector, err := d.OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxIdleConns(0)
+	t.Cleanup(func() { db.Close() })
+
+	return d, db
+}
+
+// TestRollback checks pinning to the previous version, and resuming rotation
+// afterwards.
+func TestRollback(t *testing.T) {
+	plain := lazydsn.New(lazydsntest.NewDriver(), lazydsntest.NewProvider("db"))
+
+	if _, err := plain.Rollback(); !errors.Is(err, lazydsn.ErrNotVersioned) {
+		t.Errorf("got %v without versions, want %v", err, lazydsn.ErrNotVersioned)
+	}
+
+	p := &versionedProvider{
+		dsns:    map[string]string{"v1": "db/v1", "v2": "db/v2"},
+		current: "v1",
+	}
+
+	inner := lazydsntest.NewDriver()
+	d, db := openVersioned(t, inner, p)
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Rollback(); !errors.Is(err, lazydsn.ErrNoPreviousVersion) {
+		t.Fatalf("got %v before any rotation, want %v", err, lazydsn.ErrNoPreviousVersion)
+	}
+
+	p.rotate("v2")
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if version, err := d.Rollback(); err != nil || version != "v1" {
+		t.Fatalf("got %q (%v), want v1", version, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unpin()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"db/v1", "db/v2", "db/v1", "db/v2"}
+
+	if got := inner.Attempts(); !slices.Equal(got, want) {
+		t.Errorf("got attempts %v, want %v", got, want)
+	}
+}
+
+// TestRollbackSnapshot checks that rolling back takes effect with the next
+// connection, even if resolutions are being reused.
+func TestRollbackSnapshot(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := &versionedProvider{
+		dsns:    map[string]string{"v1": "db/v1", "v2": "db/v2"},
+		current: "v1",
+	}
+
+	inner := lazydsntest.NewDriver()
+	d, db := openVersioned(t, inner, p, lazydsn.WithClock(clock), lazydsn.WithRefreshInterval(time.Hour))
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.rotate("v2")
+	clock.Advance(time.Hour)
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Unpin()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"db/v1", "db/v2", "db/v1", "db/v2"}
+
+	if got := inner.Attempts(); !slices.Equal(got, want) {
+		t.Errorf("got attempts %v, want %v", got, want)
+	}
+}
+
+// TestFallbacks checks that fallback versions are only tried when the current
+// one is rejected as an authentication failure, and that versions resolving
+// to DSNs tried already aren't tried again.
+func TestFallbacks(t *testing.T) {
+	p := &versionedProvider{
+		dsns:      map[string]string{"current": "db/new", "pending": "db/new", "previous": "db/old"},
+		current:   "current",
+		fallbacks: []string{"pending", "previous", "previous"},
+	}
+
+	inner := lazydsntest.NewDriver()
+	d, db := openVersioned(t, inner, p)
+
+	// Anything but an authentication failure is returned as is.
+	inner.FailNext(errors.New("connection refused"))
+
+	if err := db.Ping(); err == nil {
+		t.Fatal("got no error, want the database's")
+	}
+
+	if got, want := inner.Attempts(), []string{"db/new"}; !slices.Equal(got, want) {
+		t.Fatalf("got attempts %v, want %v", got, want)
+	}
+
+	inner.Fail("db/new", lazydsntest.ErrAuth)
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := inner.Attempts()[1:], []string{"db/new", "db/old"}; !slices.Equal(got, want) {
+		t.Errorf("got attempts %v, want %v", got, want)
+	}
+
+	if s := d.Stats(); s.ActiveVersion != "previous" {
+		t.Errorf("got active version %q, want previous", s.ActiveVersion)
+	}
+
+	// When nothing works, the original error comes back.
+	inner.Fail("db/old", errors.New("db/old is gone too"))
+
+	if err := db.Ping(); !errors.Is(err, lazydsntest.ErrAuth) {
+		t.Errorf("got %v, want %v", err, lazydsntest.ErrAuth)
+	}
+}
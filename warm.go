@@ -0,0 +1,222 @@
+package lazydsn
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"time"
+)
+
+// defaultWarmTTL is how long pre-warmed connections are kept, unless
+// configured otherwise.
+const defaultWarmTTL = time.Minute
+
+// warmState keeps track of the active endpoint for every master DSN, along
+// with the openFunc that connected to it, and of the connections pre-warmed
+// after a failover.
+type warmState struct {
+	mu     sync.Mutex
+	active map[string]string
+	opens  map[string]openFunc
+	conns  map[string][]warmConn
+}
+
+// warmConn is a connection opened ahead of time, for the given endpoint and
+// DSN fingerprint.
+type warmConn struct {
+	conn     driver.Conn
+	endpoint string
+	fp       string
+	expires  time.Time
+}
+
+// activate takes note of the endpoint that a connection was just opened to
+// with open, and reports whether it's different from the last one (i.e., a
+// failover).
+func (s *warmState) activate(prefix, endpoint string, open openFunc) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		s.active = make(map[string]string)
+		s.opens = make(map[string]openFunc)
+	}
+
+	last, ok := s.active[prefix]
+	s.active[prefix] = endpoint
+	s.opens[prefix] = open
+
+	return ok && last != endpoint
+}
+
+// failover makes next the active endpoint, if evicted was. It returns the
+// openFunc last used to connect to evicted, and whether it did so.
+func (s *warmState) failover(prefix, evicted, next string) (openFunc, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.active[prefix]; !ok || last != evicted {
+		return nil, false
+	}
+
+	s.active[prefix] = next
+
+	return s.opens[prefix], true
+}
+
+// take returns a pre-warmed connection for info, if there's one. Expired
+// connections, and those for other DSNs (e.g., because the credentials were
+// rotated), are closed along the way.
+func (s *warmState) take(prefix string, info DSNInfo, fp string, now time.Time) driver.Conn {
+	s.mu.Lock()
+
+	var (
+		found driver.Conn
+		stale []driver.Conn
+	)
+
+	kept := s.conns[prefix][:0]
+
+	for _, wc := range s.conns[prefix] {
+		switch {
+		case !now.Before(wc.expires) || (wc.endpoint == info.Endpoint && wc.fp != fp):
+			stale = append(stale, wc.conn)
+		case found == nil && wc.endpoint == info.Endpoint:
+			found = wc.conn
+		default:
+			kept = append(kept, wc)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(s.conns, prefix)
+	} else {
+		s.conns[prefix] = kept
+	}
+
+	s.mu.Unlock()
+
+	for _, conn := range stale {
+		conn.Close()
+	}
+
+	return found
+}
+
+// put stashes a pre-warmed connection.
+func (s *warmState) put(prefix string, wc warmConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[string][]warmConn)
+	}
+
+	s.conns[prefix] = append(s.conns[prefix], wc)
+}
+
+// expire closes the pre-warmed connections for prefix that expired by now.
+func (s *warmState) expire(prefix string, now time.Time) {
+	s.mu.Lock()
+
+	var stale []driver.Conn
+
+	kept := s.conns[prefix][:0]
+
+	for _, wc := range s.conns[prefix] {
+		if now.Before(wc.expires) {
+			kept = append(kept, wc)
+		} else {
+			stale = append(stale, wc.conn)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(s.conns, prefix)
+	} else {
+		s.conns[prefix] = kept
+	}
+
+	s.mu.Unlock()
+
+	for _, conn := range stale {
+		conn.Close()
+	}
+}
+
+// drop closes every pre-warmed connection for prefix.
+func (s *warmState) drop(prefix string) {
+	s.mu.Lock()
+	conns := s.conns[prefix]
+	delete(s.conns, prefix)
+	delete(s.active, prefix)
+	delete(s.opens, prefix)
+	s.mu.Unlock()
+
+	for _, wc := range conns {
+		wc.conn.Close()
+	}
+}
+
+// prewarm opens connections to info in the background, after a failover to
+// it, so that the pool can move over without every new connection paying
+// for the handshake. They're handed out by dial as the pool asks for new
+// connections, and closed once expired if not.
+func (d *Driver) prewarm(prefix string, info DSNInfo, open openFunc) {
+	if d.warmConns <= 0 {
+		return
+	}
+
+	ttl := d.warmTTL
+
+	if ttl <= 0 {
+		ttl = defaultWarmTTL
+	}
+
+	fp := d.fingerprint(info.DSN)
+
+	for i := 0; i < d.warmConns; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), ttl)
+			defer cancel()
+
+			conn, err := open(ctx, info)
+
+			if err != nil {
+				return
+			}
+
+			d.warm.put(prefix, warmConn{
+				conn:     conn,
+				endpoint: info.Endpoint,
+				fp:       fp,
+				expires:  d.clock.Now().Add(ttl),
+			})
+
+			<-d.clock.After(ttl)
+			d.warm.expire(prefix, d.clock.Now())
+		}()
+	}
+}
+
+// prewarmEvicted pre-warms connections to the candidate that connections
+// will fail over to, once health probes evicted the active endpoint (see
+// HealthProbe); i.e., before connections start failing over, rather than
+// after the first one did. Candidates are those probed, in the provider's
+// order.
+func (d *Driver) prewarmEvicted(prefix string, evicted DSNInfo, candidates []DSNInfo) {
+	if d.warmConns <= 0 || d.balancing != Failover || d.bgdsnp != nil {
+		return
+	}
+
+	next := d.endpoints.order(prefix, candidates, Failover, d.clock.Now())[0]
+
+	if next.Endpoint == evicted.Endpoint {
+		// Nowhere else to go.
+		return
+	}
+
+	if open, ok := d.warm.failover(prefix, evicted.Endpoint, next.Endpoint); ok {
+		d.prewarm(prefix, next, open)
+	}
+}
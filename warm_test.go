@@ -0,0 +1,124 @@
+package lazydsn_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/lazydsntest"
+)
+
+// openWarm opens a database with two candidates, probed every second, and
+// two connections pre-warmed for a minute on failover. Connections aren't
+// pooled, so that every one of them goes through the connector. It returns
+// once connected to the first candidate.
+func openWarm(t *testing.T, inner *lazydsntest.Driver, p *multiProvider, clock lazydsn.Clock) *sql.DB {
+	t.Helper()
+
+	connector, err := lazydsn.New(inner, p,
+		lazydsn.WithClock(clock),
+		lazydsn.WithRefreshInterval(time.Hour),
+		lazydsn.WithHealthProbe(lazydsn.HealthProbe{Interval: time.Second}),
+		lazydsn.WithPrewarm(2, time.Minute),
+	).OpenConnector("master")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := sql.OpenDB(connector)
+	db.SetMaxIdleConns(0)
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// warmConns returns the connections open to dsn, and how many were opened.
+func warmConns(inner *lazydsntest.Driver, dsn string) (open, opened int) {
+	for _, conn := range inner.Conns() {
+		if conn.DSN() == dsn {
+			opened++
+
+			if !conn.Closed() {
+				open++
+			}
+		}
+	}
+
+	return open, opened
+}
+
+// evict fails the first candidate, and advances clock until probes evict it
+// and connections to the second one are pre-warmed.
+func evict(t *testing.T, inner *lazydsntest.Driver, p *multiProvider, clock *lazydsntest.Clock) {
+	t.Helper()
+
+	inner.Fail(p.dsns[0], errors.New("connection refused"))
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(time.Second)
+
+	for open, _ := warmConns(inner, p.dsns[1]); open < 2; open, _ = warmConns(inner, p.dsns[1]) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPrewarmOnEvict checks that connections to the next candidate are
+// pre-warmed as soon as probes evict the active one, and that the pool takes
+// those instead of opening new ones.
+func TestPrewarmOnEvict(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := &multiProvider{
+		dsns: []string{"postgres://u:p@a:5432/app", "postgres://u:p@b:5432/app"},
+	}
+
+	inner := lazydsntest.NewDriver()
+	db := openWarm(t, inner, p, clock)
+	evict(t, inner, p, clock)
+
+	_, before := warmConns(inner, p.dsns[1])
+
+	for range 2 {
+		if err := db.Ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, after := warmConns(inner, p.dsns[1]); after != before {
+		t.Errorf("got %d connections opened, want the %d pre-warmed taken", after-before, 2)
+	}
+}
+
+// TestPrewarmExpiry checks that pre-warmed connections that aren't taken are
+// closed once expired, without waiting for a connection to come by.
+func TestPrewarmExpiry(t *testing.T) {
+	clock := lazydsntest.NewClock(time.Now())
+	p := &multiProvider{
+		dsns: []string{"postgres://u:p@a:5432/app", "postgres://u:p@b:5432/app"},
+	}
+
+	inner := lazydsntest.NewDriver()
+	openWarm(t, inner, p, clock)
+	evict(t, inner, p, clock)
+
+	// Probes wait for their next round, and pre-warmed connections for
+	// their expiry.
+	for clock.Waiters() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(time.Minute)
+
+	for open, _ := warmConns(inner, p.dsns[1]); open > 0; open, _ = warmConns(inner, p.dsns[1]) {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Package webhook implements a lazydsn observer that notifies webhooks about
+rotations and other credential events, for teams that alert through Slack,
+PagerDuty or similar services, rather than through metrics:
+
+	notifier := webhook.New([]string{"https://hooks.example.com/lazydsn"})
+	defer notifier.Close()
+
+	lazydsn.Register("lazydsn:pgx", stdlib.GetDefaultDriver(), provider,
+		lazydsn.WithObserver(lazydsn.Observers(metrics, notifier)),
+	)
+
+Drivers take a single observer, so notifiers are combined with any others
+(metrics, in this example) with lazydsn.Observers.
+
+Every event of interest (see WithKinds) is POSTed as a JSON Payload to every
+URL, in the background, so that connections are never held back by webhooks.
+Payloads carry the driver's alias (see lazydsn.Register), the redacted
+identity the credential is for, and its version, but never secrets. Services
+that expect a body of their own can have it built with WithFormat; see Slack.
+
+Notifications are best effort. They're queued, and dropped if the queue is
+full, instead of blocking the driver; webhooks that fail aren't retried.
+Errors go to the handler set with WithErrorHandler, if any.
+*/
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+)
+
+// Defaults for notifiers.
+const (
+	defaultQueueSize = 64
+	defaultTimeout   = 10 * time.Second
+)
+
+// ErrQueueFull is given to the error handler for every notification dropped
+// because the queue was full.
+var ErrQueueFull = errors.New("webhook: queue full, notification dropped")
+
+// defaultKinds are the events notified by default.
+var defaultKinds = []lazydsn.EventKind{
+	lazydsn.EventRotate,
+	lazydsn.EventFallback,
+	lazydsn.EventPolicy,
+	lazydsn.EventIdentity,
+	lazydsn.EventEvict,
+}
+
+// Payload is the JSON body POSTed for every event, unless formatted otherwise;
+// see WithFormat. User, Host and Database come from a redacted view of the
+// inner DSN (see lazydsn.DSNView), and are empty for events that aren't about
+// a given credential.
+type Payload struct {
+	Event    string    `json:"event"`
+	Alias    string    `json:"alias,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	Database string    `json:"database,omitempty"`
+	Version  string    `json:"version,omitempty"`
+	Class    string    `json:"class,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Summary returns a one line, human readable description of p.
+func (p Payload) Summary() string {
+	s := "lazydsn " + p.Event
+
+	if p.Alias != "" {
+		s += " on " + p.Alias
+	}
+
+	if p.User != "" || p.Host != "" || p.Database != "" {
+		s += " for " + p.User + "@" + p.Host + "/" + p.Database
+	}
+
+	if p.Version != "" {
+		s += " (version " + p.Version + ")"
+	}
+
+	if p.Error != "" {
+		s += ": " + p.Error
+	}
+
+	return s
+}
+
+// Slack formats payloads for Slack incoming webhooks, as a plain message with
+// the payload's summary. See WithFormat.
+func Slack(p Payload) any {
+	return map[string]string{
+		"text": p.Summary(),
+	}
+}
+
+// Notifier POSTs events to webhooks. It implements lazydsn.Observer.
+type Notifier struct {
+	urls    []string
+	client  *http.Client
+	header  http.Header
+	kinds   []lazydsn.EventKind
+	format  func(Payload) any
+	onError func(error)
+	size    int
+
+	mu     sync.Mutex
+	queue  chan Payload
+	closed bool
+	done   chan struct{}
+}
+
+// An Option configures optional behavior for a Notifier.
+type Option func(*Notifier)
+
+// WithKinds sets the kinds of events notified, instead of rotations,
+// fallbacks, policy violations, identity changes and evictions.
+func WithKinds(kinds ...lazydsn.EventKind) Option {
+	return func(n *Notifier) {
+		n.kinds = kinds
+	}
+}
+
+// WithClient sets the HTTP client that webhooks are called with, instead of
+// one with a 10s timeout.
+func WithClient(c *http.Client) Option {
+	return func(n *Notifier) {
+		n.client = c
+	}
+}
+
+// WithHeader adds a header to every request; e.g., for authorization.
+func WithHeader(key, value string) Option {
+	return func(n *Notifier) {
+		n.header.Add(key, value)
+	}
+}
+
+// WithFormat sets a function that builds the body for every payload, to be
+// encoded as JSON, for services that expect something else. See Slack.
+func WithFormat(f func(Payload) any) Option {
+	return func(n *Notifier) {
+		n.format = f
+	}
+}
+
+// WithErrorHandler sets a function that's called with every failure to
+// notify, including notifications dropped. It's called from the background
+// goroutine, except for ErrQueueFull.
+func WithErrorHandler(f func(error)) Option {
+	return func(n *Notifier) {
+		n.onError = f
+	}
+}
+
+// WithQueueSize sets how many notifications can be waiting to be sent, before
+// further ones are dropped. The default is 64.
+func WithQueueSize(size int) Option {
+	return func(n *Notifier) {
+		n.size = size
+	}
+}
+
+// New creates a notifier that POSTs events to every one of urls, and starts
+// sending them in the background. Close it once done.
+func New(urls []string, opts ...Option) *Notifier {
+	n := &Notifier{
+		urls:   slices.Clone(urls),
+		client: &http.Client{Timeout: defaultTimeout},
+		header: make(http.Header),
+		kinds:  defaultKinds,
+		format: func(p Payload) any { return p },
+		size:   defaultQueueSize,
+		done:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	n.queue = make(chan Payload, max(n.size, 1))
+	go n.run()
+
+	return n
+}
+
+// Observe implements lazydsn.Observer, queueing events of interest to be
+// notified.
+func (n *Notifier) Observe(e lazydsn.Event) {
+	if !slices.Contains(n.kinds, e.Kind) {
+		return
+	}
+
+	p := Payload{
+		Event:    e.Kind.String(),
+		Alias:    e.Alias,
+		User:     e.DSN.User,
+		Host:     e.DSN.Host,
+		Database: e.DSN.Database,
+		Version:  e.Version,
+		Time:     e.Time,
+	}
+
+	if e.Err != nil {
+		p.Class = e.Class.String()
+		p.Error = e.Err.Error()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return
+	}
+
+	select {
+	case n.queue <- p:
+	default:
+		n.fail(ErrQueueFull)
+	}
+}
+
+// Close stops accepting events, and waits for the ones queued to be sent.
+func (n *Notifier) Close() error {
+	n.mu.Lock()
+
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+
+	n.mu.Unlock()
+	<-n.done
+
+	return nil
+}
+
+// run sends queued payloads until the queue is closed.
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	for p := range n.queue {
+		body, err := json.Marshal(n.format(p))
+
+		if err != nil {
+			n.fail(fmt.Errorf("webhook: encoding payload: %w", err))
+			continue
+		}
+
+		for _, target := range n.urls {
+			if err := n.post(target, body); err != nil {
+				n.fail(err)
+			}
+		}
+	}
+}
+
+// post sends body to target. Webhook URLs often carry a secret in their path,
+// so errors only tell the host.
+func (n *Notifier) post(target string, body []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, target, bytes.NewReader(body))
+
+	if err != nil {
+		return errors.New("webhook: invalid URL")
+	}
+
+	req.Header = n.header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+
+	if err != nil {
+		var uerr *url.Error
+
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+
+		return fmt.Errorf("webhook: posting to %s: %w", req.URL.Host, err)
+	}
+
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s returned %s", req.URL.Host, resp.Status)
+	}
+
+	return nil
+}
+
+// fail reports err to the error handler, if any.
+func (n *Notifier) fail(err error) {
+	if n.onError != nil {
+		n.onError(err)
+	}
+}
+
+// Notifier implements the lazydsn.Observer and io.Closer interfaces.
+var (
+	_ lazydsn.Observer = &Notifier{}
+	_ io.Closer        = &Notifier{}
+)
@@ -0,0 +1,174 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gkristic/lazydsn"
+	"github.com/gkristic/lazydsn/webhook"
+)
+
+// recorder is a webhook that records the requests it gets, answering with
+// status.
+type recorder struct {
+	mu      sync.Mutex
+	bodies  []string
+	headers []http.Header
+	status  int
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	rec.bodies = append(rec.bodies, string(body))
+	rec.headers = append(rec.headers, r.Header)
+	status := rec.status
+	rec.mu.Unlock()
+
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+// rotation is an event notified by default.
+var rotation = lazydsn.Event{
+	Kind:    lazydsn.EventRotate,
+	Time:    time.Unix(1_700_000_000, 0).UTC(),
+	Alias:   "lazydsn:pgx",
+	DSN:     lazydsn.DSNView{User: "app", Host: "db:5432", Database: "orders"},
+	Version: "v2",
+}
+
+// TestNotify checks that events of interest are POSTed as payloads, with the
+// headers configured, and that others are not.
+func TestNotify(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := webhook.New([]string{srv.URL}, webhook.WithHeader("Authorization", "Bearer token"))
+	n.Observe(lazydsn.Event{Kind: lazydsn.EventConnect})
+	n.Observe(rotation)
+	n.Close()
+
+	if len(rec.bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rec.bodies))
+	}
+
+	var got webhook.Payload
+
+	if err := json.Unmarshal([]byte(rec.bodies[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := webhook.Payload{
+		Event:    "rotate",
+		Alias:    "lazydsn:pgx",
+		User:     "app",
+		Host:     "db:5432",
+		Database: "orders",
+		Version:  "v2",
+		Time:     rotation.Time,
+	}
+
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if auth := rec.headers[0].Get("Authorization"); auth != "Bearer token" {
+		t.Errorf("got Authorization %q, want the one configured", auth)
+	}
+
+	// Closed notifiers drop events.
+	n.Observe(rotation)
+
+	if len(rec.bodies) != 1 {
+		t.Errorf("got %d requests after Close, want 1", len(rec.bodies))
+	}
+}
+
+// TestFormat checks that bodies are built as configured.
+func TestFormat(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := webhook.New([]string{srv.URL}, webhook.WithFormat(webhook.Slack))
+	n.Observe(rotation)
+	n.Close()
+
+	want := `{"text":"lazydsn rotate on lazydsn:pgx for app@db:5432/orders (version v2)"}`
+
+	if len(rec.bodies) != 1 || rec.bodies[0] != want {
+		t.Errorf("got %q, want %q", rec.bodies, want)
+	}
+}
+
+// TestErrors checks that failures go to the error handler, telling the host
+// but not the rest of the URL, which often holds a secret.
+func TestErrors(t *testing.T) {
+	rec := &recorder{status: http.StatusInternalServerError}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	var errs []error
+
+	n := webhook.New([]string{srv.URL + "/hooks/s3cr3t"}, webhook.WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	n.Observe(rotation)
+	n.Close()
+
+	if len(errs) != 1 {
+		t.Fatalf("got errors %v, want 1", errs)
+	}
+
+	if msg := errs[0].Error(); strings.Contains(msg, "s3cr3t") || !strings.Contains(msg, "500") {
+		t.Errorf("got %q, want the status without the path", msg)
+	}
+}
+
+// TestQueueFull checks that events are dropped, rather than waited for, when
+// the queue is full.
+func TestQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+
+	defer srv.Close()
+
+	var (
+		mu      sync.Mutex
+		dropped int
+	)
+
+	n := webhook.New([]string{srv.URL}, webhook.WithQueueSize(1), webhook.WithErrorHandler(func(err error) {
+		if errors.Is(err, webhook.ErrQueueFull) {
+			mu.Lock()
+			dropped++
+			mu.Unlock()
+		}
+	}))
+
+	// One is being sent, one is queued, and the rest don't fit.
+	for range 5 {
+		n.Observe(rotation)
+	}
+
+	close(release)
+	n.Close()
+
+	if dropped < 3 {
+		t.Errorf("got %d dropped, want at least 3", dropped)
+	}
+}